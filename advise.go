@@ -0,0 +1,115 @@
+package corgi
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// 写入本进程的意向并清理已过期的意向，key的过期时间为所有意向中最晚的过期时间
+//
+// 意向存储在哈希中，字段为进程指纹，值为带expireAt(毫秒)的持有者信息JSON
+var adviseScript = redisLib.NewScript(`
+local now = tonumber(ARGV[1])
+local maxExpireAt = tonumber(ARGV[3])
+local all = redis.call("HGETALL", KEYS[1])
+for i = 1, #all, 2 do
+	if all[i] ~= ARGV[2] then
+		local ok, data = pcall(cjson.decode, all[i + 1])
+		local expireAt = ok and type(data) == "table" and tonumber(data.expireAt) or 0
+		if expireAt <= now then
+			redis.call("HDEL", KEYS[1], all[i])
+		elseif expireAt > maxExpireAt then
+			maxExpireAt = expireAt
+		end
+	end
+end
+redis.call("HSET", KEYS[1], ARGV[2], ARGV[4])
+redis.call("PEXPIREAT", KEYS[1], maxExpireAt)
+return 1
+`)
+
+type adviceEntry struct {
+	LockInfo
+	ExpireAt int64 `json:"expireAt"`
+}
+
+func adviceKey(key string) string {
+	return companionKey(key, "advice")
+}
+
+// Advise 登记本进程正在处理key的意向(软锁)，总是成功，ttl后自动失效
+//
+// 与锁不同，多个进程可以同时登记同一个key，其他进程可通过Peers查看后自行决定是否跳过，
+// 适用于不要求严格互斥的尽力协调。同一进程重复登记时刷新意向及其过期时间。
+func (rd *redisDriver) Advise(ctx context.Context, key string, ttl time.Duration) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	c := rd.cmdable()
+	if c == nil {
+		return ErrNotConfigured
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	return registerProcess(ctx, c, adviceKey(key), ttl)
+}
+
+// 在哈希hkey中登记本进程，ttl后自动失效
+func registerProcess(ctx context.Context, c redisLib.UniversalClient, hkey string, ttl time.Duration) error {
+	now := time.Now()
+	entry := adviceEntry{LockInfo: newLockInfo(newToken()), ExpireAt: now.Add(ttl).UnixNano() / int64(time.Millisecond)}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return adviseScript.Run(ctx, c, []string{hkey}, now.UnixNano()/int64(time.Millisecond), processFingerprint(), entry.ExpireAt, string(data)).Err()
+}
+
+// Peers 列出当前在key上登记了意向的进程，按登记时间排序
+func (rd *redisDriver) Peers(ctx context.Context, key string) ([]LockInfo, error) {
+	c := rd.cmdable()
+	if c == nil {
+		return nil, ErrNotConfigured
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	return registeredProcesses(ctx, c, adviceKey(key))
+}
+
+// 列出哈希hkey中登记且未过期的进程，按登记时间排序
+func registeredProcesses(ctx context.Context, c redisLib.UniversalClient, hkey string) ([]LockInfo, error) {
+	all, err := c.HGetAll(ctx, hkey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	peers := make([]LockInfo, 0, len(all))
+	for _, v := range all {
+		var entry adviceEntry
+		if json.Unmarshal([]byte(v), &entry) != nil {
+			continue
+		}
+		expireAt := time.Unix(0, entry.ExpireAt*int64(time.Millisecond))
+		if !expireAt.After(now) {
+			continue
+		}
+		entry.Key = hkey
+		entry.TTL = expireAt.Sub(now)
+		peers = append(peers, entry.LockInfo)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].LockedAt.Before(peers[j].LockedAt)
+	})
+
+	return peers, nil
+}
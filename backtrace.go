@@ -0,0 +1,58 @@
+package corgi
+
+import (
+	"runtime/debug"
+	"sort"
+	"time"
+)
+
+// WithAcquireBacktrace 获取成功时记录调用方的协程堆栈，用于通过LeakReport排查忘记释放的锁
+//
+// 仅建议在调试或测试环境中使用：每次加锁都会捕获完整的堆栈，有明显的开销。
+func WithAcquireBacktrace() LockOption {
+	return func(lo *lockOptions) {
+		lo.backtrace = true
+	}
+}
+
+// LeakedLock 持有时间超过阈值的锁
+type LeakedLock struct {
+	// Key 锁的key
+	Key string
+	// HeldFor 已持有的时长
+	HeldFor time.Duration
+	// Stack 获取锁时的协程堆栈，未使用WithAcquireBacktrace时为空
+	Stack string
+}
+
+// LeakReport 列出全局实例中本进程持有超过threshold的锁，按持有时长从长到短排列
+func LeakReport(threshold time.Duration) []LeakedLock {
+	return lockDriver.leakReport(threshold)
+}
+
+func (rd *redisDriver) leakReport(threshold time.Duration) []LeakedLock {
+	var (
+		now    = time.Now()
+		leaked []LeakedLock
+	)
+	rd.states.each(func(_ string, st *lockState) {
+		if st.ended.Load() {
+			return
+		}
+		if held := now.Sub(st.acquiredAt); held >= threshold {
+			leaked = append(leaked, LeakedLock{Key: st.key, HeldFor: held, Stack: string(st.stack)})
+		}
+	})
+
+	sort.Slice(leaked, func(i, j int) bool {
+		return leaked[i].HeldFor > leaked[j].HeldFor
+	})
+	return leaked
+}
+
+// 使用WithAcquireBacktrace时记录当前协程的堆栈，须在开始续期前调用
+func (st *lockState) captureStack(lo *lockOptions) {
+	if lo.backtrace {
+		st.stack = debug.Stack()
+	}
+}
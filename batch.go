@@ -0,0 +1,142 @@
+package corgi
+
+import (
+	"context"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// TryLockSome 尝试获取一组锁，返回其中获取成功的key
+//
+// 与全部成功或全部失败的获取方式不同，这里只要能获取到的都会保留，适用于工作窃取等
+// 场景：worker一次性认领当前空闲的分片。所有key通过一个pipeline发送，集群模式下
+// 由ClusterClient按key所在的槽位拆分到对应节点执行，不会产生跨槽位错误。
+//
+// 每个获取成功的key都会独立注册本地状态并自动续期，之后可分别通过Unlock释放。
+// 部分命令出错时，仍会返回已获取的key，同时返回遇到的第一个错误。
+// 仅支持WithAcquireTimeout、WithToken以及续期相关的选项。
+func (rd *redisDriver) TryLockSome(ctx context.Context, keys []string, opts ...LockOption) ([]string, error) {
+	for _, key := range keys {
+		if err := validateKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	lo := newLockOptions(opts)
+	c, err := rd.clientFor(lo)
+	if err != nil {
+		return nil, err
+	}
+
+	done, ok := rd.begin()
+	if !ok {
+		return nil, ErrClosed
+	}
+	defer done()
+
+	ctx, cancel := withTimeout(ctx, lo.timeout)
+	defer cancel()
+
+	if lo.acquireTimeout > 0 {
+		cwt, cancel := context.WithTimeout(ctx, lo.acquireTimeout)
+		defer cancel()
+		ctx = cwt
+	}
+	ctx = withAcquiring(ctx)
+	if err = rd.admitRenewal(); err != nil {
+		return nil, err
+	}
+
+	keys = uniqueKeys(keys)
+	labels := ownerLabels(ctx)
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		token := lo.token
+		if token == "" {
+			token = newToken()
+		}
+		info := newLockInfo(token)
+		info.Reason = lo.reason
+		info.Labels = labels
+		if values[i], _, err = provideValue(ctx, key, info); err != nil {
+			return nil, err
+		}
+	}
+
+	cmds := make([]*redisLib.StatusCmd, len(keys))
+	_, _ = c.Pipelined(ctx, func(pipe redisLib.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = setNX(ctx, pipe, redisKey(key), values[i], lo.initialTTL())
+		}
+		return nil
+	})
+
+	var (
+		acquired []string
+		firstErr error
+		now      = time.Now()
+	)
+	for i, key := range keys {
+		ok, err := setNXResult(cmds[i])
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if !ok {
+			rd.contention.record(key, now)
+			continue
+		}
+
+		lockedHook(key, values[i])
+		if renewalMode != RenewalNone {
+			st := newLockState(c, key, redisKey(key), values[i])
+			st.applyHeadroom(lo)
+			st.captureStack(lo)
+			rd.hold(st, lo)
+		}
+		acquired = append(acquired, key)
+	}
+	if len(acquired) > 0 {
+		registerOwner(ctx, c)
+	}
+
+	if firstErr != nil && lo.acquireTimeout > 0 && isTimeout(firstErr) {
+		firstErr = ErrTimeout
+	}
+
+	return acquired, firstErr
+}
+
+// UnlockMulti 按获取顺序的逆序逐个释放一组锁，返回释放成功的key(按释放顺序)
+//
+// keys应按获取的顺序传入(如TryLockSome的返回值，或按SortKeys排序后依次获取的key)，
+// 逆序释放与嵌套加锁的规则一致：后获取的先释放，等待方观察到的释放顺序是确定的，便于排查。
+// 每个key的释放规则与Unlock相同，某个key释放失败不影响其余key。
+func (rd *redisDriver) UnlockMulti(ctx context.Context, keys []string, opts ...LockOption) []string {
+	released := make([]string, 0, len(keys))
+	for i := len(keys) - 1; i >= 0; i-- {
+		if rd.Unlock(ctx, keys[i], opts...) {
+			released = append(released, keys[i])
+		}
+	}
+
+	return released
+}
+
+// 去除重复的key，保持原有顺序
+func uniqueKeys(keys []string) []string {
+	seen := make(map[string]struct{}, len(keys))
+	out := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		out = append(out, key)
+	}
+
+	return out
+}
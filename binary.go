@@ -0,0 +1,49 @@
+package corgi
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+)
+
+// 二进制编码的长度：16字节token + 4字节IPv4 + 2字节pid(大端)
+const binaryValueLength = 16 + 4 + 2
+
+// 当前进程的二进制编码，token不是16字节的十六进制串(如WithToken指定的token)时返回false
+func binaryValue(token string) (string, bool) {
+	raw, err := hex.DecodeString(token)
+	if err != nil || len(raw) != 16 {
+		return "", false
+	}
+
+	b := make([]byte, binaryValueLength)
+	copy(b, raw)
+	if ip, _ := GetLocalIP(); ip != "" {
+		if v4 := net.ParseIP(ip).To4(); v4 != nil {
+			copy(b[16:20], v4)
+		}
+	}
+	binary.BigEndian.PutUint16(b[20:], uint16(os.Getpid()))
+
+	return string(b), true
+}
+
+// DecodeOwner 解析二进制编码(ValueBinary)的持有者信息
+//
+// 二进制编码只包含token、IPv4和pid的低16位，不包含主机名和加锁时间。
+func DecodeOwner(b []byte) (LockInfo, error) {
+	var info LockInfo
+	if len(b) != binaryValueLength {
+		return info, fmt.Errorf("corgi: binary owner must be %d bytes, got %d", binaryValueLength, len(b))
+	}
+
+	info.Token = hex.EncodeToString(b[:16])
+	if ip := net.IP(b[16:20]); !ip.Equal(net.IPv4zero) {
+		info.IP = ip.String()
+	}
+	info.PID = int(binary.BigEndian.Uint16(b[20:]))
+
+	return info, nil
+}
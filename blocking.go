@@ -0,0 +1,176 @@
+package corgi
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// 阻塞加锁默认的重试间隔
+var defaultRetryInterval = time.Millisecond * 100
+
+// 释放锁时广播通知的频道前缀
+const unlockChannelPrefix = "corgi:unlock:"
+
+var unlockBroadcast = true
+
+// WithRetryInterval 设置阻塞加锁(Lock)的重试间隔
+func WithRetryInterval(d time.Duration) LockOption {
+	return func(lo *lockOptions) {
+		lo.retryInterval = d
+	}
+}
+
+// WithWaitCallback 阻塞加锁(Lock)每次尝试失败后调用fn，attempt为已尝试的次数，elapsed为已等待的时长
+//
+// 用于长时间等待时输出日志、更新进度或上报指标。fn在两次尝试之间调用，不会与redis操作同时进行；
+// fn的耗时计入重试间隔，较慢的fn不会推迟下一次尝试，但超过重试间隔时下一次尝试会在fn返回后立即进行。
+func WithWaitCallback(fn func(attempt int, elapsed time.Duration)) LockOption {
+	return func(lo *lockOptions) {
+		lo.waitCallback = fn
+	}
+}
+
+// SetUnlockBroadcast 设置释放锁时是否广播通知，默认开启
+//
+// 开启时Unlock会向corgi:unlock:<key>频道发送一条消息(尽力而为，失败不影响释放结果)，
+// 阻塞加锁(Lock)的等待方订阅该频道，锁被释放后可立即重试，不依赖服务端开启keyspace通知。
+// 广播无法感知锁因TTL到期而释放，也可能因订阅建立前的释放而错过消息，等待方仍会按重试间隔兜底轮询。
+// 代价是每次释放多一次PUBLISH，每个等待中的Lock占用一个订阅连接。
+func SetUnlockBroadcast(enabled bool) {
+	unlockBroadcast = enabled
+}
+
+func unlockChannel(rkey string) string {
+	return unlockChannelPrefix + rkey
+}
+
+// 广播锁已释放，失败时忽略
+func (rd *redisDriver) notifyUnlock(ctx context.Context, c redisLib.UniversalClient, rkey string) {
+	if !unlockBroadcast {
+		return
+	}
+	_ = c.Publish(ctx, unlockChannel(rkey), "").Err()
+}
+
+// Lock 阻塞获取锁
+//
+// ctx超时后，若所有尝试都只是因为锁被占用而失败，返回ErrLockWaitTimeout；
+// 若期间出现过其他错误(如redis不可用)，返回包装了最后一次错误的错误，可通过errors.Is判断。
+// ctx被取消时返回ctx.Err()。未配置、DB不支持、服务端版本过低等重试也无法成功的错误立即返回(见WithRetry)。
+func (rd *redisDriver) Lock(ctx context.Context, key string, opts ...LockOption) (*Lock, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	lo := newLockOptions(opts)
+	if lo.err != nil {
+		return nil, lo.err
+	}
+
+	//先在本地排队，排到后才在redis上竞争；获取成功后由锁的状态负责离开
+	if lo.localGate {
+		leave, err := rd.gates.enter(ctx, rd.stateKey(lo, redisKey(key)), true)
+		if err != nil {
+			return nil, waitError(ctx, 0, nil)
+		}
+		acquired := false
+		defer func() {
+			if !acquired || renewalMode == RenewalNone {
+				leave()
+			}
+		}()
+		opts = append(opts[:len(opts):len(opts)], func(lo *lockOptions) {
+			lo.ungate = leave
+		})
+		l, err := rd.lock(ctx, key, lo, opts)
+		acquired = err == nil
+		return l, err
+	}
+
+	return rd.lock(ctx, key, lo, opts)
+}
+
+// 阻塞加锁的重试循环
+func (rd *redisDriver) lock(ctx context.Context, key string, lo *lockOptions, opts []LockOption) (*Lock, error) {
+	interval := lo.retryInterval
+	if interval <= 0 {
+		interval = defaultRetryInterval
+	}
+
+	start := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	var (
+		released <-chan *redisLib.Message
+		attempts int
+		lastErr  error
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, waitError(ctx, attempts, lastErr)
+		case <-timer.C:
+		case <-released:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+
+		attempts++
+		l, err := rd.Acquire(ctx, key, opts...)
+		switch err {
+		case nil:
+			if hooks.OnAcquired != nil {
+				hooks.OnAcquired(key, time.Since(start))
+			}
+			return l, nil
+		case ErrNotAcquired:
+		default:
+			if permanentLockError(ctx, err) {
+				return nil, err
+			}
+			//ctx结束导致的错误不是真正的失败原因
+			if ctx.Err() == nil {
+				lastErr = err
+			}
+		}
+
+		//首次获取失败后再订阅，无竞争时不占用订阅连接
+		if released == nil && unlockBroadcast {
+			if c := rd.cmdable(); c != nil {
+				ps := c.Subscribe(ctx, unlockChannel(redisKey(key)))
+				defer ps.Close()
+				released = ps.Channel()
+			}
+		}
+
+		//先开始计时再回调，回调的耗时计入重试间隔而不会推迟下一次尝试
+		timer.Reset(interval)
+		if lo.waitCallback != nil {
+			lo.waitCallback(attempts, time.Since(start))
+		}
+	}
+}
+
+// 不会随等待消失的错误(与WithRetry不重试的错误相同，如未配置、DB不支持、服务端版本过低)，阻塞加锁立即返回
+//
+// ctx已结束时不视为确定性错误，由重试循环按ctx结束处理。
+func permanentLockError(ctx context.Context, err error) bool {
+	return err != ErrNotAcquired && ctx.Err() == nil && !retryable(ctx, err)
+}
+
+// 阻塞加锁因ctx结束而放弃时返回的错误
+func waitError(ctx context.Context, attempts int, lastErr error) error {
+	if ctx.Err() != context.DeadlineExceeded {
+		return ctx.Err()
+	}
+	if lastErr != nil {
+		return fmt.Errorf("corgi: lock wait timed out after %d attempts, last error: %w", attempts, lastErr)
+	}
+
+	return ErrLockWaitTimeout
+}
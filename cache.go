@@ -0,0 +1,82 @@
+package corgi
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 缓存IsLocked结果的Locker，见WithIsLockedCache
+type isLockedCache struct {
+	Locker
+
+	ttl     time.Duration
+	mux     sync.Mutex
+	entries map[string]*isLockedEntry
+}
+
+type isLockedEntry struct {
+	done   chan struct{}
+	at     time.Time
+	locked bool
+	err    error
+}
+
+// WithIsLockedCache 包装l，在进程内缓存IsLocked的结果ttl时长，同一key并发的查询合并为一次redis请求
+//
+// 缓存的结果最多滞后ttl(包括本进程自己的加锁和释放)，只适用于看板、提示等参考性的检查，
+// 不能据此判断是否可以安全地进入临界区，互斥仍须通过加锁保证。出错的结果不会缓存。
+func WithIsLockedCache(l Locker, ttl time.Duration) Locker {
+	return &isLockedCache{Locker: l, ttl: ttl, entries: make(map[string]*isLockedEntry)}
+}
+
+func (c *isLockedCache) IsLocked(ctx context.Context, key string) (bool, error) {
+	c.mux.Lock()
+	e, ok := c.entries[key]
+	if !ok || (isClosed(e.done) && time.Since(e.at) >= c.ttl) {
+		e = &isLockedEntry{done: make(chan struct{})}
+		c.entries[key] = e
+		c.mux.Unlock()
+
+		e.locked, e.err = c.Locker.IsLocked(ctx, key)
+		e.at = time.Now()
+		c.mux.Lock()
+		if e.err != nil && c.entries[key] == e {
+			delete(c.entries, key)
+		}
+		c.sweep(key)
+		c.mux.Unlock()
+		close(e.done)
+
+		return e.locked, e.err
+	}
+	c.mux.Unlock()
+
+	select {
+	case <-e.done:
+		return e.locked, e.err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// 缓存的key超过一定数量时清理过期的结果，调用方需持有c.mux
+func (c *isLockedCache) sweep(skip string) {
+	if len(c.entries) < tombstoneSweepThreshold {
+		return
+	}
+	for key, e := range c.entries {
+		if key != skip && isClosed(e.done) && time.Since(e.at) >= c.ttl {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
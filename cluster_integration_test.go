@@ -0,0 +1,153 @@
+//go:build integration
+
+package corgi
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// 需要一个至少有两个主节点的真实集群：
+//
+//	CORGI_CLUSTER_ADDRS=127.0.0.1:7000,127.0.0.1:7001 go test -tags integration -run Cluster ./...
+func newClusterTestDriver(t *testing.T) (*redisDriver, *redisLib.ClusterClient) {
+	t.Helper()
+
+	addrs := os.Getenv("CORGI_CLUSTER_ADDRS")
+	if addrs == "" {
+		t.Skip("CORGI_CLUSTER_ADDRS is not set")
+	}
+
+	client := redisLib.NewClusterClient(&redisLib.ClusterOptions{Addrs: strings.Split(addrs, ",")})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	rd := newDriver()
+	rd.clusterClient = client
+
+	return rd, client
+}
+
+func TestClusterReshardWhileLocked(t *testing.T) {
+	withRenewalInterval(t, 50*time.Millisecond)
+	rd, client := newClusterTestDriver(t)
+	ctx := context.Background()
+
+	key := "reshard-" + newToken()
+	l, err := rd.Acquire(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rkey := redisKey(key)
+	slot, err := client.ClusterKeySlot(ctx, rkey).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := client.MasterForKey(ctx, rkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mux     sync.Mutex
+		masters []*redisLib.Client
+	)
+	err = client.ForEachMaster(ctx, func(ctx context.Context, c *redisLib.Client) error {
+		if c.Options().Addr != source.Options().Addr {
+			mux.Lock()
+			masters = append(masters, c)
+			mux.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(masters) == 0 {
+		t.Skip("cluster needs at least two masters")
+	}
+	target := masters[0]
+
+	migrateSlot(t, ctx, client, source, target, slot)
+
+	//迁移后的续期和释放都应路由到新的节点
+	time.Sleep(4 * renewalCheckInterval)
+	select {
+	case reason := <-l.Lost():
+		t.Fatalf("lock lost after reshard: %v", reason)
+	default:
+	}
+	if err = l.Extend(ctx); err != nil {
+		t.Fatalf("extend after reshard: %v", err)
+	}
+	if v, err := target.Get(ctx, rkey).Result(); err != nil || v != l.Value() {
+		t.Fatalf("expected lock on target node, got %q %v", v, err)
+	}
+
+	if err = l.Unlock(ctx); err != nil {
+		t.Fatalf("unlock after reshard: %v", err)
+	}
+	if n, _ := target.Exists(ctx, rkey).Result(); n != 0 {
+		t.Fatal("expected key to be deleted on target node")
+	}
+}
+
+// 将槽位从source迁移到target(IMPORTING/MIGRATING、MIGRATE、SETSLOT NODE)
+func migrateSlot(t *testing.T, ctx context.Context, client *redisLib.ClusterClient, source, target *redisLib.Client, slot int64) {
+	t.Helper()
+
+	sourceID, err := source.Do(ctx, "cluster", "myid").Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetID, err := target.Do(ctx, "cluster", "myid").Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(target.Do(ctx, "cluster", "setslot", slot, "importing", sourceID).Err())
+	must(source.Do(ctx, "cluster", "setslot", slot, "migrating", targetID).Err())
+
+	host, port, err := net.SplitHostPort(target.Options().Addr)
+	must(err)
+	keys, err := source.ClusterGetKeysInSlot(ctx, int(slot), 1000).Result()
+	must(err)
+	for _, k := range keys {
+		must(source.Migrate(ctx, host, port, k, 0, 5*time.Second).Err())
+	}
+
+	for _, node := range []*redisLib.Client{target, source} {
+		must(node.Do(ctx, "cluster", "setslot", slot, "node", targetID).Err())
+	}
+	client.ReloadState(ctx)
+
+	t.Cleanup(func() {
+		//测试结束后迁回原节点
+		sourceHost, sourcePort, _ := net.SplitHostPort(source.Options().Addr)
+		_ = source.Do(ctx, "cluster", "setslot", slot, "importing", targetID).Err()
+		_ = target.Do(ctx, "cluster", "setslot", slot, "migrating", sourceID).Err()
+		if keys, err := target.ClusterGetKeysInSlot(ctx, int(slot), 1000).Result(); err == nil {
+			for _, k := range keys {
+				_ = target.Migrate(ctx, sourceHost, sourcePort, k, 0, 5*time.Second).Err()
+			}
+		}
+		for _, node := range []*redisLib.Client{source, target} {
+			_ = node.Do(ctx, "cluster", "setslot", slot, "node", sourceID).Err()
+		}
+	})
+}
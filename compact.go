@@ -0,0 +1,149 @@
+package corgi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// ValueEncoding 锁的值的编码方式
+type ValueEncoding int
+
+const (
+	// ValueJSON JSON编码的完整持有者信息(默认)
+	ValueJSON ValueEncoding = iota
+	// ValueCompact 紧凑编码，仅存储 <主机指纹><pid>:<token>
+	//
+	// 适用于持有海量锁、需要节省redis内存的场景。值中不包含主机名和ip，
+	// 可通过SetOwnerRegistry维护指纹到完整主机信息的映射，并用ResolveOwner查询。
+	ValueCompact
+	// ValueBinary 定长二进制编码：16字节token + 4字节IPv4 + 2字节pid(取低16位)，共22字节
+	//
+	// 适用于按流量计费(如跨可用区)等对每个字节都敏感的部署，可通过DecodeOwner解析。
+	// 仅能编码随机生成的token，使用WithToken等自定义token时回退为JSON编码。
+	ValueBinary
+)
+
+var (
+	valueEncoding = ValueJSON
+
+	ownerRegistryKey string
+	ownerRegistryTTL = time.Hour * 24
+	//最近一次写入映射表的时间(UnixNano)
+	ownerRegisteredAt atomic.Int64
+)
+
+// SetValueEncoding 设置锁的值的编码方式
+func SetValueEncoding(e ValueEncoding) {
+	valueEncoding = e
+}
+
+// SetOwnerRegistry 设置紧凑编码下指纹到完整主机信息的映射表
+//
+// 映射表为一个redis hash，每个进程对应一项，加锁时按需写入(每个进程每ttl/2最多写入一次)，
+// 整个hash在ttl后过期。key为空时不维护映射表。
+func SetOwnerRegistry(key string, ttl time.Duration) {
+	ownerRegistryKey = key
+	if ttl > 0 {
+		ownerRegistryTTL = ttl
+	}
+}
+
+// ResolveOwner 从映射表中查询指纹对应的持有者信息
+func ResolveOwner(ctx context.Context, fingerprint string) (LockInfo, error) {
+	var info LockInfo
+
+	c := lockDriver.cmdable()
+	if c == nil {
+		return info, ErrNotConfigured
+	}
+	if ownerRegistryKey == "" {
+		return info, fmt.Errorf("corgi: owner registry not configured")
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	raw, err := c.HGet(ctx, ownerRegistryKey, fingerprint).Result()
+	if err != nil {
+		return info, err
+	}
+	if err = json.Unmarshal([]byte(raw), &info); err != nil {
+		return info, err
+	}
+	info.Fingerprint = fingerprint
+
+	return info, nil
+}
+
+// 主机名的指纹(FNV-1a 32位)
+func hostFingerprint(hostname string) string {
+	h := uint32(2166136261)
+	for i := 0; i < len(hostname); i++ {
+		h ^= uint32(hostname[i])
+		h *= 16777619
+	}
+
+	return fmt.Sprintf("%08x", h)
+}
+
+// 当前进程的指纹：主机名指纹(8位16进制) + pid(8位16进制)
+func processFingerprint() string {
+	hostname, _ := os.Hostname()
+	return hostFingerprint(hostname) + fmt.Sprintf("%08x", uint32(os.Getpid()))
+}
+
+func compactValue(token string) string {
+	return processFingerprint() + ":" + token
+}
+
+func parseCompactValue(value string) (LockInfo, bool) {
+	var info LockInfo
+
+	if len(value) < 18 || value[16] != ':' {
+		return info, false
+	}
+	if _, err := strconv.ParseUint(value[:8], 16, 32); err != nil {
+		return info, false
+	}
+	pid, err := strconv.ParseUint(value[8:16], 16, 32)
+	if err != nil {
+		return info, false
+	}
+
+	info.Fingerprint = value[:16]
+	info.PID = int(pid)
+	info.Token = value[17:]
+
+	return info, true
+}
+
+// 按需将当前进程的指纹写入映射表
+func registerOwner(ctx context.Context, c redisLib.UniversalClient) {
+	if valueEncoding != ValueCompact || ownerRegistryKey == "" {
+		return
+	}
+
+	last := ownerRegisteredAt.Load()
+	now := time.Now().UnixNano()
+	if now-last < int64(ownerRegistryTTL/2) || !ownerRegisteredAt.CompareAndSwap(last, now) {
+		return
+	}
+
+	hostname, _ := os.Hostname()
+	ip, _ := GetLocalIP()
+	b, _ := json.Marshal(LockInfo{Host: hostname, IP: ip, PID: os.Getpid()})
+
+	pipe := c.Pipeline()
+	pipe.HSet(ctx, ownerRegistryKey, processFingerprint(), string(b))
+	pipe.Expire(ctx, ownerRegistryKey, ownerRegistryTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		ownerRegisteredAt.Store(0)
+	}
+}
@@ -0,0 +1,52 @@
+package corgi
+
+import (
+	"context"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// KEYS[2]的值等于ARGV[3]时才SET NX锁，返回1表示获取成功，0表示锁被占用，-1表示条件不满足
+var condLockScript = redisLib.NewScript(`
+if redis.call("GET", KEYS[2]) ~= ARGV[3] then
+	return -1
+end
+if not redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2], "NX") then
+	return 0
+end
+return 1
+`)
+
+// 按条件获取锁的条件，见TryLockIf
+type lockCondition struct {
+	key      string
+	expected string
+}
+
+func withCondition(condKey string, expected string) LockOption {
+	return func(lo *lockOptions) {
+		lo.cond = &lockCondition{key: condKey, expected: expected}
+	}
+}
+
+// TryLockIf condKey的值等于expected时才尝试获取锁，检查与获取在同一个脚本中完成
+//
+// 用于"任务状态为pending时才加锁"之类的场景，避免先检查再加锁之间状态被修改。
+// 条件不满足时返回ErrConditionNotMet(condKey不存在也视为不满足)，锁被占用时与TryLockE相同，返回false和nil。
+// condKey按原样使用，不添加锁的前缀；cluster模式下需通过hash tag保证两者位于同一个slot。
+func (rd *redisDriver) TryLockIf(ctx context.Context, key string, condKey string, expected string, opts ...LockOption) (bool, error) {
+	return rd.TryLockE(ctx, key, append(opts, withCondition(condKey, expected))...)
+}
+
+func setNXIf(ctx context.Context, c redisLib.UniversalClient, rkey string, value string, cond *lockCondition, ttl time.Duration) (bool, error) {
+	n, err := condLockScript.Run(ctx, c, []string{rkey, cond.key}, value, ttl.Milliseconds(), cond.expected).Int()
+	if err != nil {
+		return false, err
+	}
+	if n < 0 {
+		return false, ErrConditionNotMet
+	}
+
+	return n == 1, nil
+}
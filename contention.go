@@ -0,0 +1,95 @@
+package corgi
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	//争用计数的半衰期，为0时不统计
+	contentionHalfLife time.Duration
+	//最多跟踪的键数量，超出时淘汰最久未更新的键
+	contentionCapacity = 1024
+)
+
+// SetContentionTracking 开启加锁争用统计
+//
+// 每次因锁被占用而加锁失败时对该键计数，计数按半衰期halfLife指数衰减
+// (每经过halfLife减半)，因此Contention反映的是近期的争用压力而非累计总数，
+// 可用于热点发现及降载决策。最多跟踪capacity个键，按LRU淘汰。halfLife为0时关闭统计。
+func SetContentionTracking(halfLife time.Duration, capacity int) {
+	contentionHalfLife = halfLife
+	if capacity > 0 {
+		contentionCapacity = capacity
+	}
+}
+
+// Contention 返回键当前(衰减后)的争用计数
+func Contention(key string) float64 {
+	return lockDriver.contention.get(key, time.Now())
+}
+
+type contentionEntry struct {
+	key     string
+	count   float64
+	updated time.Time
+}
+
+// 按键统计的争用计数，读取时惰性衰减
+type contentionTracker struct {
+	mux   sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func newContentionTracker() *contentionTracker {
+	return &contentionTracker{ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+// 按经过的时间衰减计数
+func (e *contentionEntry) decay(now time.Time) {
+	if elapsed := now.Sub(e.updated); elapsed > 0 && contentionHalfLife > 0 {
+		e.count *= math.Exp2(-float64(elapsed) / float64(contentionHalfLife))
+	}
+	e.updated = now
+}
+
+func (t *contentionTracker) record(key string, now time.Time) {
+	if contentionHalfLife <= 0 {
+		return
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if el, ok := t.items[key]; ok {
+		e := el.Value.(*contentionEntry)
+		e.decay(now)
+		e.count++
+		t.ll.MoveToFront(el)
+		return
+	}
+
+	t.items[key] = t.ll.PushFront(&contentionEntry{key: key, count: 1, updated: now})
+	for t.ll.Len() > contentionCapacity {
+		oldest := t.ll.Back()
+		t.ll.Remove(oldest)
+		delete(t.items, oldest.Value.(*contentionEntry).key)
+	}
+}
+
+func (t *contentionTracker) get(key string, now time.Time) float64 {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	el, ok := t.items[key]
+	if !ok {
+		return 0
+	}
+	e := el.Value.(*contentionEntry)
+	e.decay(now)
+
+	return e.count
+}
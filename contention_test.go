@@ -0,0 +1,31 @@
+package corgi
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestContentionDecay(t *testing.T) {
+	SetContentionTracking(time.Minute, 2)
+	defer SetContentionTracking(0, 1024)
+
+	tracker := newContentionTracker()
+	now := time.Now()
+	for i := 0; i < 8; i++ {
+		tracker.record("hot", now)
+	}
+
+	if got := tracker.get("hot", now.Add(time.Minute)); math.Abs(got-4) > 1e-9 {
+		t.Fatalf("expected count to halve after one half-life, got %v", got)
+	}
+	if got := tracker.get("hot", now.Add(3*time.Minute)); math.Abs(got-1) > 1e-9 {
+		t.Fatalf("expected count to be 1 after three half-lives, got %v", got)
+	}
+
+	tracker.record("a", now)
+	tracker.record("b", now)
+	if got := tracker.get("hot", now); got != 0 {
+		t.Fatalf("expected least recently used key to be evicted, got %v", got)
+	}
+}
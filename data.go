@@ -0,0 +1,128 @@
+package corgi
+
+import (
+	"context"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// 获取锁成功时写入关联数据，两者使用相同的TTL，返回1表示获取成功
+//
+// 写入前先删除残留的关联数据，避免上一个持有者的字段混入
+var acquireWithDataScript = redisLib.NewScript(`
+if not redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2], "NX") then
+	return 0
+end
+redis.call("DEL", KEYS[2])
+if #ARGV > 2 then
+	redis.call("HSET", KEYS[2], unpack(ARGV, 3))
+	redis.call("PEXPIRE", KEYS[2], ARGV[2])
+end
+return 1
+`)
+
+// 锁的关联数据所在的键，锁的键带有hash tag时两者位于同一个slot
+func dataKey(rkey string) string {
+	return rkey + "#data"
+}
+
+// AcquireWithData 获取锁，成功时在同一个脚本中写入关联数据(如 leader=我、endpoint=x)
+//
+// 关联数据存储在与锁使用相同TTL的hash中，随锁一起自动续期，不会出现获取了锁却没有数据的中间状态。
+// 锁释放后关联数据在TTL内自然过期，AcquireData不再返回。ttl不大于0时使用默认TTL。
+// cluster模式下关联数据的键为锁的键加上后缀，需通过hash tag(如SetKeyRouter)保证两者位于同一个slot。
+func (rd *redisDriver) AcquireWithData(ctx context.Context, key string, data map[string]string, ttl time.Duration) (bool, error) {
+	if err := validateKey(key); err != nil {
+		return false, err
+	}
+	c := rd.cmdable()
+	if c == nil {
+		return false, ErrNotConfigured
+	}
+
+	done, ok := rd.begin()
+	if !ok {
+		return false, ErrClosed
+	}
+	defer done()
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+	ctx = withAcquiring(ctx)
+	if err := rd.admitRenewal(); err != nil {
+		return false, err
+	}
+
+	if ttl <= 0 {
+		ttl = lockTTL
+	}
+	info := newLockInfo(newToken())
+	info.Labels = ownerLabels(ctx)
+	value, _, err := provideValue(ctx, key, info)
+	if err != nil {
+		return false, err
+	}
+	rkey := redisKey(key)
+
+	args := make([]interface{}, 0, 2+2*len(data))
+	args = append(args, value, ttl.Milliseconds())
+	for field, v := range data {
+		args = append(args, field, v)
+	}
+	n, err := acquireWithDataScript.Run(ctx, c, []string{rkey, dataKey(rkey)}, args...).Int()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		rd.contention.record(key, time.Now())
+		return false, nil
+	}
+
+	registerOwner(ctx, c)
+	lockedHook(key, value)
+
+	if renewalMode != RenewalNone {
+		st := newLockState(c, key, rkey, value)
+		st.dataKey = dataKey(rkey)
+		if ttl != lockTTL {
+			st.ttl.Store(int64(ttl))
+		}
+		rd.hold(st, newLockOptions(nil))
+	}
+
+	return true, nil
+}
+
+// AcquireData 读取锁的关联数据，锁不存在时返回ErrNotHeld
+func (rd *redisDriver) AcquireData(ctx context.Context, key string) (map[string]string, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	c := rd.cmdable()
+	if c == nil {
+		return nil, ErrNotConfigured
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	rkey := redisKey(key)
+	var (
+		exists *redisLib.IntCmd
+		data   *redisLib.StringStringMapCmd
+	)
+	if _, err := c.TxPipelined(ctx, func(pipe redisLib.Pipeliner) error {
+		exists = pipe.Exists(ctx, rkey)
+		data = pipe.HGetAll(ctx, dataKey(rkey))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if exists.Val() == 0 {
+		return nil, ErrNotHeld
+	}
+
+	return data.Val(), nil
+}
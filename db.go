@@ -0,0 +1,100 @@
+package corgi
+
+import (
+	"fmt"
+	"strconv"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// WithDB 在指定的redis数据库(DB索引)中加锁，用于多租户的轻量隔离
+//
+// 每个DB使用一个独立的客户端(复用当前客户端的连接配置)，不同DB中的同名key互不影响。
+// 释放、续期、重建句柄时需传入相同的WithDB。仅支持standalone和fail-over模式，
+// 集群模式只有DB 0，此时返回ErrDBNotSupported。
+func WithDB(db int) LockOption {
+	return func(lo *lockOptions) {
+		lo.db = db
+		lo.selectDB = true
+	}
+}
+
+// 本次调用使用的redis客户端
+func (rd *redisDriver) clientFor(lo *lockOptions) (redisLib.UniversalClient, error) {
+	if lo.err != nil {
+		return nil, lo.err
+	}
+	if !lo.selectDB {
+		c := rd.cmdable()
+		if c == nil {
+			return nil, ErrNotConfigured
+		}
+		return c, nil
+	}
+
+	node, err := rd.dbClient(lo.db)
+	if err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// 指定DB的客户端，首次使用时创建
+func (rd *redisDriver) dbClient(db int) (*redisLib.Client, error) {
+	if rd.client == nil {
+		if rd.clusterClient != nil {
+			return nil, ErrDBNotSupported
+		}
+		return nil, ErrNotConfigured
+	}
+	if db == rd.client.Options().DB {
+		return rd.client, nil
+	}
+
+	rd.dbMux.Lock()
+	defer rd.dbMux.Unlock()
+
+	if node, ok := rd.dbClients[db]; ok {
+		return node, nil
+	}
+	opt := *rd.client.Options()
+	opt.DB = db
+	node := redisLib.NewClient(&opt)
+	if rd == lockDriver {
+		applyRedisHooks(node)
+	} else {
+		node.AddHook(commandHook{})
+	}
+	if rd.dbClients == nil {
+		rd.dbClients = make(map[int]*redisLib.Client)
+	}
+	rd.dbClients[db] = node
+
+	return node, nil
+}
+
+// 本地状态注册表中的key，非默认DB的锁带上DB索引以免与其他DB中的同名key冲突
+func (rd *redisDriver) stateKey(lo *lockOptions, rkey string) string {
+	if !lo.selectDB || (rd.client != nil && lo.db == rd.client.Options().DB) {
+		return rkey
+	}
+
+	return strconv.Itoa(lo.db) + "/" + rkey
+}
+
+// 关闭按DB创建的客户端
+func (rd *redisDriver) closeDBClients() []error {
+	rd.dbMux.Lock()
+	defer rd.dbMux.Unlock()
+
+	var errs []error
+	for db, node := range rd.dbClients {
+		if err := node.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close client of db %d: %w", db, err))
+		}
+	}
+	rd.dbClients = nil
+
+	return errs
+}
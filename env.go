@@ -0,0 +1,47 @@
+package corgi
+
+import (
+	"os"
+	"sync"
+)
+
+// EnvironmentVariable 默认读取环境名称的环境变量
+const EnvironmentVariable = "CORGI_ENV"
+
+var (
+	environment       = os.Getenv(EnvironmentVariable)
+	strictEnvironment bool
+	envWarnOnce       sync.Once
+)
+
+// SetEnvironment 设置环境名称(如staging、prod)，所有键都会加上"<env>:"前缀，位于命名空间之前
+//
+// 默认取环境变量CORGI_ENV的值，用于防止多个环境误用同一个redis时互相抢占对方的锁。
+// env为空时重新读取CORGI_ENV。与SetNamespace相同，需在加锁前设置。
+func SetEnvironment(env string) {
+	if env == "" {
+		env = os.Getenv(EnvironmentVariable)
+	}
+	environment = env
+}
+
+// SetStrictEnvironment 开启后，未设置环境名称时在第一次使用键时输出一次警告
+//
+// 适合在所有环境都应显式区分的部署中开启，尽早发现漏配CORGI_ENV的实例。
+func SetStrictEnvironment(strict bool) {
+	strictEnvironment = strict
+}
+
+// 加上环境名称前缀，严格模式下未设置时警告一次
+func environmentKey(key string) string {
+	if environment == "" {
+		if strictEnvironment {
+			envWarnOnce.Do(func() {
+				logger.Printf("WARNING: strict environment is enabled but no environment is set (SetEnvironment or %s); lock keys are shared with every environment using this redis", EnvironmentVariable)
+			})
+		}
+		return key
+	}
+
+	return environment + keySeparator + key
+}
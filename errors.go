@@ -0,0 +1,42 @@
+package corgi
+
+import "errors"
+
+var (
+	// ErrNotConfigured 未设置redis连接
+	ErrNotConfigured = errors.New("corgi: redis provider not configured")
+	// ErrTimeout 获取锁超时
+	ErrTimeout = errors.New("corgi: acquire timeout")
+	// ErrNotAcquired 锁已被占用，未能获取
+	ErrNotAcquired = errors.New("corgi: lock not acquired")
+	// ErrNotHeld 锁已不再由当前持有者持有(已过期或被其他持有者获取)
+	ErrNotHeld = errors.New("corgi: lock not held")
+	// ErrReplicationTimeout 写入未能在超时时间内同步到足够数量的副本
+	ErrReplicationTimeout = errors.New("corgi: replication wait timeout")
+	// ErrRenewalFailed 续期失败
+	ErrRenewalFailed = errors.New("corgi: renewal failed")
+	// ErrMaxLifetime 超过最长持有时间，已停止续期
+	ErrMaxLifetime = errors.New("corgi: max lifetime exceeded")
+	// ErrLockerNotFound 未注册的锁实例名称
+	ErrLockerNotFound = errors.New("corgi: locker not found")
+	// ErrClosed 已关闭，不再接受新的加锁请求
+	ErrClosed = errors.New("corgi: locker closed")
+	// ErrLockWaitTimeout 阻塞加锁等待超时，期间每次尝试都是因为锁被占用而失败
+	ErrLockWaitTimeout = errors.New("corgi: lock wait timed out")
+	// ErrInvalidKey 键为空或超过最大长度
+	ErrInvalidKey = errors.New("corgi: invalid key")
+	// ErrAlreadyHeldBySelf 锁已由本进程持有，见SetSelfContention
+	ErrAlreadyHeldBySelf = errors.New("corgi: lock already held by this process")
+	// ErrAlreadyLost 续期已放弃并宣告锁丢失，Unlock不再删除键
+	ErrAlreadyLost = errors.New("corgi: lock already lost")
+	// ErrConditionNotMet TryLockIf的条件不满足，未尝试获取锁
+	ErrConditionNotMet = errors.New("corgi: lock condition not met")
+	// ErrKeyTypeConflict 锁键已被其他业务用作非锁的键(如hash、list)，见WithTypeChecking
+	ErrKeyTypeConflict = errors.New("corgi: key holds a value not written by corgi")
+	// ErrRenewalSaturated 续期协程池已积压，新获取的锁无法保证按时续期
+	ErrRenewalSaturated = errors.New("corgi: renewal pool saturated")
+	// ErrInvalidOption 加锁选项的取值无效
+	ErrInvalidOption = errors.New("corgi: invalid lock option")
+	// ErrDBNotSupported 集群模式不支持选择DB
+	ErrDBNotSupported = errors.New("corgi: selecting a database is not supported in cluster mode")
+)
@@ -0,0 +1,68 @@
+package corgi
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// LockEventType 锁事件的类型，见WithPublishEvents
+type LockEventType string
+
+const (
+	// EventAcquired 获取锁成功
+	EventAcquired LockEventType = "acquired"
+	// EventLost 自动续期宣告锁丢失
+	EventLost LockEventType = "lost"
+	// EventReleased 释放锁(实际删除了锁)
+	EventReleased LockEventType = "released"
+)
+
+// LockEvent 发布到事件频道的消息，以JSON编码
+type LockEvent struct {
+	Type  LockEventType `json:"type"`
+	Key   string        `json:"key"`
+	Owner LockInfo      `json:"owner"`
+	// Reason 锁丢失的原因，仅EventLost
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// WithPublishEvents 获取锁、锁丢失及释放时向channel发布LockEvent(PUBLISH)，供其他服务订阅(如更新状态面板)
+//
+// 尽力而为：发布失败不影响加锁及释放。释放事件由按key的Unlock、UnlockAsync及句柄的Unlock发布，
+// Shutdown及自动释放(WithAutoRelease)不发布。每个事件多一次PUBLISH往返。
+func WithPublishEvents(channel string) LockOption {
+	return func(lo *lockOptions) {
+		lo.eventChannel = channel
+	}
+}
+
+// 发布锁事件，channel为空时为空操作，失败时忽略
+func publishEvent(ctx context.Context, c redisLib.UniversalClient, channel string, event LockEvent) {
+	if channel == "" || c == nil {
+		return
+	}
+	event.At = time.Now()
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	_ = c.Publish(ctx, channel, data).Err()
+}
+
+// 释放时发布事件的频道，本地持有时以获取时的设置为准
+func (rd *redisDriver) eventChannel(lo *lockOptions, rkey string) string {
+	if st, ok := rd.states.load(rd.stateKey(lo, rkey)); ok && st.eventChannel != "" {
+		return st.eventChannel
+	}
+	return lo.eventChannel
+}
+
+// 锁的持有者信息，value为写入redis的值
+func eventOwner(value string) LockInfo {
+	info, _ := parseLockerValue(value)
+	return info
+}
@@ -0,0 +1,141 @@
+package corgi
+
+import (
+	"context"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// 登记排队(KEYS[1]为按到达顺序排列的队列，KEYS[2]为各等待方的存活期限)并返回当前的排位(从0开始)
+//
+// 先清理超过存活期限(ARGV[2])未刷新的等待方，避免已退出的进程一直占据队首。
+var fairEnqueueScript = redisLib.NewScript(`
+local dead = redis.call("ZRANGEBYSCORE", KEYS[2], "-inf", ARGV[2])
+for _, member in ipairs(dead) do
+	redis.call("ZREM", KEYS[1], member)
+	redis.call("ZREM", KEYS[2], member)
+end
+if not redis.call("ZSCORE", KEYS[1], ARGV[1]) then
+	local last = redis.call("ZRANGE", KEYS[1], -1, -1, "WITHSCORES")
+	local seq = 1
+	if last[2] then
+		seq = tonumber(last[2]) + 1
+	end
+	redis.call("ZADD", KEYS[1], seq, ARGV[1])
+end
+redis.call("ZADD", KEYS[2], ARGV[3], ARGV[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+redis.call("PEXPIRE", KEYS[2], ARGV[4])
+return redis.call("ZRANK", KEYS[1], ARGV[1])
+`)
+
+// 等待方超过该数量的重试间隔未刷新时视为已退出
+const fairStaleIntervals = 5
+
+// 排队相关的键，两者同属一个hash tag，cluster模式下位于同一个slot
+func fairQueueKey(key string) string {
+	return companionKey(key, "queue")
+}
+
+func fairAliveKey(key string) string {
+	return companionKey(key, "queue-alive")
+}
+
+// WithQueuePosition 公平加锁(LockFair)排队时，排位变化后调用fn，position从1开始(1表示排在队首)
+//
+// 排位来自每次重试时登记排队的同一个脚本(ZRANK)，不产生额外的redis请求，更新频率不超过重试间隔。
+func WithQueuePosition(fn func(position int)) LockOption {
+	return func(lo *lockOptions) {
+		lo.queuePosition = fn
+	}
+}
+
+// LockFair 按到达顺序阻塞获取锁，直到成功或ctx结束
+//
+// 等待方在redis中按到达顺序排队，只有排在队首时才尝试获取锁，避免高竞争下后到者反复抢先。
+// 仅在LockFair的调用方之间保证先来先得，TryLock/Lock等不排队的调用仍可能抢先获得锁。
+// 排队使用token标识等待方，ctx结束时离开队列；进程退出未离开时，超过若干个重试间隔后被其他等待方清理。
+// 排队的键由本包添加hash tag，cluster模式下无需调用方自行添加。
+// 返回的错误与Lock相同。
+func (rd *redisDriver) LockFair(ctx context.Context, key string, opts ...LockOption) (*Lock, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	lo := newLockOptions(opts)
+	c, err := rd.clientFor(lo)
+	if err != nil {
+		return nil, err
+	}
+
+	token := lo.token
+	if token == "" {
+		token = newToken()
+		opts = append(opts[:len(opts):len(opts)], WithToken(token))
+	}
+	interval := lo.retryInterval
+	if interval <= 0 {
+		interval = defaultRetryInterval
+	}
+	keys := []string{fairQueueKey(key), fairAliveKey(key)}
+	stale := interval * fairStaleIntervals
+	defer func() {
+		leaveCtx, cancel := withExecuteTimeout(detach(ctx))
+		defer cancel()
+		_, _ = c.Pipelined(leaveCtx, func(pipe redisLib.Pipeliner) error {
+			pipe.ZRem(leaveCtx, keys[0], token)
+			pipe.ZRem(leaveCtx, keys[1], token)
+			return nil
+		})
+	}()
+
+	start := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	var (
+		attempts int
+		lastErr  error
+		position int
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, waitError(ctx, attempts, lastErr)
+		case <-timer.C:
+		}
+
+		attempts++
+		now := nowMillis()
+		rank, err := fairEnqueueScript.Run(ctx, c, keys, token, now, now+stale.Milliseconds(), (2 * stale).Milliseconds()).Int()
+		if err == nil && rank+1 != position {
+			position = rank + 1
+			if lo.queuePosition != nil {
+				lo.queuePosition(position)
+			}
+		}
+
+		if err == nil && rank == 0 {
+			var l *Lock
+			l, err = rd.Acquire(ctx, key, opts...)
+			if err == nil {
+				if hooks.OnAcquired != nil {
+					hooks.OnAcquired(key, time.Since(start))
+				}
+				return l, nil
+			}
+		}
+		if err != nil && permanentLockError(ctx, err) {
+			return nil, err
+		}
+		if err != nil && err != ErrNotAcquired && ctx.Err() == nil {
+			lastErr = err
+		}
+
+		timer.Reset(interval)
+		if lo.waitCallback != nil {
+			lo.waitCallback(attempts, time.Since(start))
+		}
+	}
+}
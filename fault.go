@@ -0,0 +1,152 @@
+package corgi
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// ErrInjectedFault 故障注入产生的错误
+var ErrInjectedFault = errors.New("corgi: injected fault")
+
+// FaultConfig 故障注入配置，各比例取值范围为[0, 1]
+type FaultConfig struct {
+	// DelayRate 注入延迟的比例
+	DelayRate float64
+	// Delay 注入的延迟时长
+	Delay time.Duration
+	// DropRate 丢弃命令的比例，被丢弃的命令不会发送到redis，阻塞到ctx结束后返回超时
+	DropRate float64
+	// ErrorRate 返回错误的比例，被注入错误的命令不会发送到redis
+	ErrorRate float64
+	// Err 注入的错误，默认为ErrInjectedFault
+	Err error
+	// Commands 仅对这些命令(小写，如"evalsha"、"set")注入故障，为空时对所有命令注入
+	Commands []string
+	// Seed 随机数种子，为0时使用当前时间
+	Seed int64
+}
+
+// FaultInjector 基于go-redis Hook的故障注入器，仅用于测试
+//
+// 用于验证续期失败、取消及超时等在redis故障下才会触发的逻辑，不应在生产环境中使用。
+type FaultInjector struct {
+	mux sync.Mutex
+	cfg FaultConfig
+	rnd *rand.Rand
+}
+
+var _ redisLib.Hook = (*FaultInjector)(nil)
+
+// NewFaultInjector 创建故障注入器，可通过client.AddHook安装到任意redis客户端
+func NewFaultInjector(cfg FaultConfig) *FaultInjector {
+	f := &FaultInjector{}
+	f.Set(cfg)
+	return f
+}
+
+// WithFaultInjection 在当前配置的redis客户端上安装故障注入器，仅用于测试
+//
+// go-redis不支持移除Hook，可通过返回的FaultInjector.Set(FaultConfig{})关闭注入。
+func WithFaultInjection(cfg FaultConfig) *FaultInjector {
+	f := NewFaultInjector(cfg)
+	if c := lockDriver.cmdable(); c != nil {
+		c.AddHook(f)
+	}
+	return f
+}
+
+// Set 更新故障注入配置，传入零值时关闭注入
+func (f *FaultInjector) Set(cfg FaultConfig) {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	f.mux.Lock()
+	f.cfg = cfg
+	f.rnd = rand.New(rand.NewSource(seed))
+	f.mux.Unlock()
+}
+
+// 按配置决定本次的故障
+func (f *FaultInjector) roll(names []string) (delay time.Duration, drop bool, err error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	cfg := f.cfg
+	if len(cfg.Commands) > 0 && !containsAny(cfg.Commands, names) {
+		return 0, false, nil
+	}
+
+	if cfg.DelayRate > 0 && f.rnd.Float64() < cfg.DelayRate {
+		delay = cfg.Delay
+	}
+	if cfg.DropRate > 0 && f.rnd.Float64() < cfg.DropRate {
+		drop = true
+	}
+	if cfg.ErrorRate > 0 && f.rnd.Float64() < cfg.ErrorRate {
+		err = cfg.Err
+		if err == nil {
+			err = ErrInjectedFault
+		}
+	}
+
+	return delay, drop, err
+}
+
+func (f *FaultInjector) inject(ctx context.Context, names []string) error {
+	delay, drop, err := f.roll(names)
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	if drop {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	return err
+}
+
+func (f *FaultInjector) BeforeProcess(ctx context.Context, cmd redisLib.Cmder) (context.Context, error) {
+	return ctx, f.inject(ctx, []string{cmd.Name()})
+}
+
+func (f *FaultInjector) AfterProcess(context.Context, redisLib.Cmder) error {
+	return nil
+}
+
+func (f *FaultInjector) BeforeProcessPipeline(ctx context.Context, cmds []redisLib.Cmder) (context.Context, error) {
+	names := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		names[i] = cmd.Name()
+	}
+	return ctx, f.inject(ctx, names)
+}
+
+func (f *FaultInjector) AfterProcessPipeline(context.Context, []redisLib.Cmder) error {
+	return nil
+}
+
+func containsAny(list []string, names []string) bool {
+	for _, item := range list {
+		for _, name := range names {
+			if strings.EqualFold(item, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,432 @@
+package corgi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// 临时缩短续期间隔
+func withRenewalInterval(t testing.TB, d time.Duration) {
+	t.Helper()
+	prev := renewalCheckInterval
+	renewalCheckInterval = d
+	t.Cleanup(func() {
+		renewalCheckInterval = prev
+	})
+}
+
+func TestRenewalUnderFault(t *testing.T) {
+	withRenewalInterval(t, 20*time.Millisecond)
+	rd, _ := newTestDriver(t)
+	faults := NewFaultInjector(FaultConfig{})
+	rd.client.AddHook(faults)
+	ctx := context.Background()
+
+	l, err := rd.Acquire(ctx, "fault", WithRenewalGrace(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//续期全部失败，超过宽限期后应视为锁丢失
+	faults.Set(FaultConfig{ErrorRate: 1, Commands: []string{"evalsha", "eval"}})
+	start := time.Now()
+	select {
+	case reason := <-l.Lost():
+		if !errors.Is(reason, ErrRenewalFailed) {
+			t.Fatalf("expected ErrRenewalFailed, got %v", reason)
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Fatalf("expected lock to survive the grace period, lost after %s", elapsed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected lock to be lost")
+	}
+}
+
+func TestRenewalPolicyUnderFault(t *testing.T) {
+	withRenewalInterval(t, 20*time.Millisecond)
+	rd, _ := newTestDriver(t)
+	faults := NewFaultInjector(FaultConfig{})
+	rd.client.AddHook(faults)
+	ctx := context.Background()
+
+	if _, err := rd.Acquire(ctx, "policy", WithRenewalPolicy(RenewalPolicy{Retries: 10, Grace: 100 * time.Millisecond})); !errors.Is(err, ErrInvalidOption) {
+		t.Fatalf("expected retries exceeding the grace to be rejected, got %v", err)
+	}
+	if _, err := rd.Acquire(ctx, "policy", WithRenewalPolicy(RenewalPolicy{Grace: time.Minute})); !errors.Is(err, ErrInvalidOption) {
+		t.Fatalf("expected grace exceeding the ttl to be rejected, got %v", err)
+	}
+
+	lost := make(chan error, 1)
+	l, err := rd.Acquire(ctx, "policy", WithRenewalPolicy(RenewalPolicy{Retries: 2, Grace: time.Second, OnLost: func(reason error) { lost <- reason }}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//宽限期远未结束，但连续出错超过Retries次后视为丢失
+	faults.Set(FaultConfig{ErrorRate: 1, Commands: []string{"evalsha", "eval"}})
+	start := time.Now()
+	select {
+	case reason := <-lost:
+		if !errors.Is(reason, ErrRenewalFailed) {
+			t.Fatalf("expected ErrRenewalFailed, got %v", reason)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("expected retries to end renewal before the grace, lost after %s", elapsed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected lock to be lost")
+	}
+	<-l.Lost()
+}
+
+func TestConfirmedRenewalUnderFault(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	//不使用脚本时确认续期与回滚分别通过PEXPIRE和DEL执行，可以只让确认续期失败
+	rd.noScripting.Store(true)
+	faults := NewFaultInjector(FaultConfig{ErrorRate: 1, Commands: []string{"pexpire"}})
+	rd.client.AddHook(faults)
+	ctx := context.Background()
+
+	if _, err := rd.Acquire(ctx, "confirmed", WithConfirmedRenewal()); !errors.Is(err, ErrRenewalFailed) {
+		t.Fatalf("expected ErrRenewalFailed, got %v", err)
+	}
+	if mr.Exists(redisKey("confirmed")) {
+		t.Fatal("expected the acquired lock to be rolled back")
+	}
+	if rd.states.count() != 0 {
+		t.Fatal("expected no local state for a rolled back lock")
+	}
+
+	faults.Set(FaultConfig{})
+	l, err := rd.Acquire(ctx, "confirmed", WithConfirmedRenewal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Unlock(ctx)
+}
+
+func TestAcquireTimeoutUnderFault(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	rd.client.AddHook(NewFaultInjector(FaultConfig{DropRate: 1, Commands: []string{"set"}}))
+
+	if _, err := rd.Acquire(context.Background(), "fault", WithAcquireTimeout(20*time.Millisecond)); err != ErrTimeout {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestPerCallTimeoutUnderFault(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+	if _, err := rd.Acquire(ctx, "timeout"); err != nil {
+		t.Fatal(err)
+	}
+	rd.client.AddHook(NewFaultInjector(FaultConfig{DropRate: 1, Commands: []string{"evalsha", "eval"}}))
+
+	start := time.Now()
+	if rd.Extend(ctx, "timeout", WithTimeout(20*time.Millisecond)) {
+		t.Fatal("expected extend to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected per-call timeout to apply, took %s", elapsed)
+	}
+}
+
+func TestPerCallTimeoutLongerThanGlobal(t *testing.T) {
+	prev := redisExecuteTimeout
+	redisExecuteTimeout = 20 * time.Millisecond
+	t.Cleanup(func() { redisExecuteTimeout = prev })
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	//重建的句柄不会续期，避免续期协程读取被修改的全局超时
+	if err := mr.Set(redisKey("slow"), "v1"); err != nil {
+		t.Fatal(err)
+	}
+	l := rd.Restore("slow", "v1", WithTimeout(time.Second))
+	rd.client.AddHook(NewFaultInjector(FaultConfig{DelayRate: 1, Delay: 50 * time.Millisecond, Commands: []string{"evalsha", "eval"}}))
+
+	if err := l.Extend(ctx); err != nil {
+		t.Fatalf("expected per-call timeout to override the global, got %v", err)
+	}
+	if err := l.Unlock(ctx); err != nil {
+		t.Fatalf("expected per-call timeout to override the global, got %v", err)
+	}
+	if mr.Exists(redisKey("slow")) {
+		t.Fatal("expected key to be deleted")
+	}
+}
+
+func TestLockWaitLastError(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	rd.client.AddHook(NewFaultInjector(FaultConfig{ErrorRate: 1, Commands: []string{"set"}}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := rd.Lock(ctx, "fault", WithRetryInterval(10*time.Millisecond))
+	if !errors.Is(err, ErrInjectedFault) || errors.Is(err, ErrLockWaitTimeout) {
+		t.Fatalf("expected the last injected fault to be wrapped, got %v", err)
+	}
+}
+
+// 前failures次加锁返回临时性错误的Locker
+type flakyLocker struct {
+	Locker
+	failures int
+	calls    int
+}
+
+// 记录一次调用，前failures次返回临时性错误
+func (fl *flakyLocker) fail() error {
+	fl.calls++
+	if fl.calls <= fl.failures {
+		return errors.New("connection reset by peer")
+	}
+	return nil
+}
+
+func (fl *flakyLocker) TryLockE(ctx context.Context, key string, opts ...LockOption) (bool, error) {
+	if err := fl.fail(); err != nil {
+		return false, err
+	}
+	return fl.Locker.TryLockE(ctx, key, opts...)
+}
+
+func (fl *flakyLocker) LockFair(ctx context.Context, key string, opts ...LockOption) (*Lock, error) {
+	if err := fl.fail(); err != nil {
+		return nil, err
+	}
+	return fl.Locker.LockFair(ctx, key, opts...)
+}
+
+func (fl *flakyLocker) AcquireUntil(ctx context.Context, key string, deadline time.Time, opts ...LockOption) (*Lock, error) {
+	if err := fl.fail(); err != nil {
+		return nil, err
+	}
+	return fl.Locker.AcquireUntil(ctx, key, deadline, opts...)
+}
+
+func (fl *flakyLocker) AcquireInPipeline(ctx context.Context, pipe redisLib.Pipeliner, key string, opts ...LockOption) (*PendingLock, error) {
+	if err := fl.fail(); err != nil {
+		return nil, err
+	}
+	return fl.Locker.AcquireInPipeline(ctx, pipe, key, opts...)
+}
+
+func (fl *flakyLocker) Lock(ctx context.Context, key string, opts ...LockOption) (*Lock, error) {
+	if err := fl.fail(); err != nil {
+		return nil, err
+	}
+	return fl.Locker.Lock(ctx, key, opts...)
+}
+
+func (fl *flakyLocker) TryLockSome(ctx context.Context, keys []string, opts ...LockOption) ([]string, error) {
+	if err := fl.fail(); err != nil {
+		return nil, err
+	}
+	return fl.Locker.TryLockSome(ctx, keys, opts...)
+}
+
+func (fl *flakyLocker) Rename(ctx context.Context, oldKey string, newKey string, opts ...LockOption) (bool, error) {
+	if err := fl.fail(); err != nil {
+		return false, err
+	}
+	return fl.Locker.Rename(ctx, oldKey, newKey, opts...)
+}
+
+func (fl *flakyLocker) UnlockE(ctx context.Context, key string, opts ...LockOption) (UnlockResult, time.Duration, error) {
+	if err := fl.fail(); err != nil {
+		return UnlockFailed, 0, err
+	}
+	return fl.Locker.UnlockE(ctx, key, opts...)
+}
+
+func (fl *flakyLocker) UnlockAsync(ctx context.Context, key string, opts ...LockOption) <-chan error {
+	errc := make(chan error, 1)
+	if err := fl.fail(); err != nil {
+		errc <- err
+		return errc
+	}
+	return fl.Locker.UnlockAsync(ctx, key, opts...)
+}
+
+func (fl *flakyLocker) AcquireWithData(ctx context.Context, key string, data map[string]string, ttl time.Duration) (bool, error) {
+	if err := fl.fail(); err != nil {
+		return false, err
+	}
+	return fl.Locker.AcquireWithData(ctx, key, data, ttl)
+}
+
+func TestWithRetry(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	fl := &flakyLocker{Locker: rd, failures: 2}
+	if !WithRetry(fl, 3, time.Millisecond).TryLock(ctx, "retry") {
+		t.Fatal("expected lock after retries")
+	}
+	if fl.calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", fl.calls)
+	}
+
+	//锁被占用不重试
+	fl = &flakyLocker{Locker: rd}
+	if ok, err := WithRetry(fl, 3, time.Millisecond).TryLockE(ctx, "retry"); ok || err != nil || fl.calls != 1 {
+		t.Fatalf("expected contention not to be retried, got %v %v after %d calls", ok, err, fl.calls)
+	}
+
+	fl = &flakyLocker{Locker: rd, failures: 5}
+	if _, err := WithRetry(fl, 2, time.Millisecond).TryLockE(ctx, "other"); err == nil || fl.calls != 2 {
+		t.Fatalf("expected last error after 2 attempts, got %v after %d calls", err, fl.calls)
+	}
+	for _, err := range []error{&StaleTermError{Current: 3}, fmt.Errorf("wrapped: %w", ErrTimeout)} {
+		if retryable(ctx, err) {
+			t.Fatalf("expected %v not to be retried", err)
+		}
+	}
+}
+
+func TestWithRetryAcquireWithData(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	fl := &flakyLocker{Locker: rd, failures: 2}
+	ok, err := WithRetry(fl, 3, time.Millisecond).AcquireWithData(ctx, "retry-data", map[string]string{"leader": "a"}, 0)
+	if !ok || err != nil || fl.calls != 3 {
+		t.Fatalf("expected lock after retries, got %v %v after %d calls", ok, err, fl.calls)
+	}
+}
+
+func TestWithRetryLockFair(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	fl := &flakyLocker{Locker: rd, failures: 2}
+	l, err := WithRetry(fl, 3, time.Millisecond).LockFair(ctx, "retry-fair")
+	if err != nil || fl.calls != 3 {
+		t.Fatalf("expected lock after retries, got %v after %d calls", err, fl.calls)
+	}
+	_ = l.Unlock(ctx)
+}
+
+func TestWithRetryLockUntil(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	fl := &flakyLocker{Locker: rd, failures: 2}
+	rl := WithRetry(fl, 3, time.Millisecond)
+	if err := rl.LockUntil(ctx, "retry-until", time.Now().Add(time.Minute)); err != nil || fl.calls != 3 {
+		t.Fatalf("expected lock after retries, got %v after %d calls", err, fl.calls)
+	}
+	rl.Unlock(ctx, "retry-until")
+
+	//截止时间已过不重试
+	fl = &flakyLocker{Locker: rd}
+	if _, err := WithRetry(fl, 3, time.Millisecond).AcquireUntil(ctx, "retry-until", time.Now().Add(-time.Second)); err != ErrDeadlinePassed || fl.calls != 1 {
+		t.Fatalf("expected ErrDeadlinePassed without retries, got %v after %d calls", err, fl.calls)
+	}
+}
+
+func TestWithRetryAcquireInPipeline(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	fl := &flakyLocker{Locker: rd, failures: 2}
+	pipe := rd.client.TxPipeline()
+	pending, err := WithRetry(fl, 3, time.Millisecond).AcquireInPipeline(ctx, pipe, "retry-tx")
+	if err != nil || fl.calls != 3 {
+		t.Fatalf("expected lock after retries, got %v after %d calls", err, fl.calls)
+	}
+	//失败的尝试不会向pipe加入命令
+	if n := pipe.Len(); n != 1 {
+		t.Fatalf("expected a single queued command, got %d", n)
+	}
+	_, _ = pipe.Exec(ctx)
+	l, err := pending.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Unlock(ctx)
+}
+
+func TestWithRetryLock(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	fl := &flakyLocker{Locker: rd, failures: 2}
+	l, err := WithRetry(fl, 3, time.Millisecond).Lock(ctx, "retry-lock")
+	if err != nil || fl.calls != 3 {
+		t.Fatalf("expected lock after retries, got %v after %d calls", err, fl.calls)
+	}
+	_ = l.Unlock(ctx)
+}
+
+func TestWithRetryTryLockSome(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	fl := &flakyLocker{Locker: rd, failures: 2}
+	acquired, err := WithRetry(fl, 3, time.Millisecond).TryLockSome(ctx, []string{"retry-a", "retry-b"})
+	if err != nil || len(acquired) != 2 || fl.calls != 3 {
+		t.Fatalf("expected both locks after retries, got %v %v after %d calls", acquired, err, fl.calls)
+	}
+
+	//已获取的key不会再次尝试
+	if got := excludeKeys([]string{"a", "b", "c"}, []string{"b"}); len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Fatalf("unexpected remaining keys %v", got)
+	}
+}
+
+func TestWithRetryRename(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	if _, err := rd.Acquire(ctx, "retry-old"); err != nil {
+		t.Fatal(err)
+	}
+	fl := &flakyLocker{Locker: rd, failures: 2}
+	if ok, err := WithRetry(fl, 3, time.Millisecond).Rename(ctx, "retry-old", "retry-new"); !ok || err != nil || fl.calls != 3 {
+		t.Fatalf("expected rename after retries, got %v %v after %d calls", ok, err, fl.calls)
+	}
+	if mr.Exists(redisKey("retry-old")) || !mr.Exists(redisKey("retry-new")) {
+		t.Fatal("expected the lock to move to the new key")
+	}
+}
+
+func TestWithRetryUnlockMulti(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	if _, err := rd.TryLockSome(ctx, []string{"retry-a", "retry-b"}); err != nil {
+		t.Fatal(err)
+	}
+	fl := &flakyLocker{Locker: rd, failures: 2}
+	released := WithRetry(fl, 3, time.Millisecond).UnlockMulti(ctx, []string{"retry-a", "retry-b"})
+	if len(released) != 2 || released[0] != "retry-b" || fl.calls != 4 {
+		t.Fatalf("expected both locks released in reverse order, got %v after %d calls", released, fl.calls)
+	}
+	if mr.Exists(redisKey("retry-a")) || mr.Exists(redisKey("retry-b")) {
+		t.Fatal("expected keys to be deleted")
+	}
+}
+
+func TestWithRetryUnlockAsync(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	if _, err := rd.Acquire(ctx, "retry-async"); err != nil {
+		t.Fatal(err)
+	}
+	fl := &flakyLocker{Locker: rd, failures: 1}
+	if err := <-WithRetry(fl, 3, time.Millisecond).UnlockAsync(ctx, "retry-async"); err != nil || fl.calls != 2 {
+		t.Fatalf("expected release after retries, got %v after %d calls", err, fl.calls)
+	}
+	if mr.Exists(redisKey("retry-async")) {
+		t.Fatal("expected key to be deleted")
+	}
+}
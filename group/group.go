@@ -0,0 +1,76 @@
+// Package group 使用corgi分布式锁保护一组协程(errgroup)
+//
+// 独立为子包，使corgi本身不依赖golang.org/x/sync。
+package group
+
+import (
+	"context"
+	"sync"
+
+	"github.com/keepchen/corgi"
+	"golang.org/x/sync/errgroup"
+)
+
+var locker corgi.Locker
+
+// SetLocker 设置LockGroup使用的锁实例，默认使用corgi.Wakeup()
+func SetLocker(l corgi.Locker) {
+	locker = l
+}
+
+// LockGroup 获取锁并返回受锁保护的errgroup
+//
+// 返回的context在锁丢失(如续期失败)、组内任务返回错误或ctx结束时取消，进行中的任务应据此中止。
+// release应在g.Wait()之后调用：停止监听并释放锁，锁已丢失时返回丢失的原因，可重复调用。
+// 释放不受ctx取消的影响。获取失败时返回corgi.ErrNotAcquired或redis的错误。
+func LockGroup(ctx context.Context, key string, opts ...corgi.LockOption) (*errgroup.Group, context.Context, func() error, error) {
+	l := locker
+	if l == nil {
+		l = corgi.Wakeup()
+	}
+
+	lock, err := l.Acquire(ctx, key, opts...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	g, gctx := errgroup.WithContext(lockCtx)
+
+	var (
+		mux  sync.Mutex
+		lost error
+		done = make(chan struct{})
+		once sync.Once
+	)
+	go func() {
+		select {
+		case reason := <-lock.Lost():
+			mux.Lock()
+			lost = reason
+			mux.Unlock()
+			cancel()
+		case <-done:
+		}
+	}()
+
+	release := func() error {
+		var err error
+		once.Do(func() {
+			close(done)
+			cancel()
+
+			//锁丢失后仍尝试按值释放，redis中残留的本进程的值随之清理
+			err = lock.Unlock(context.Background())
+
+			mux.Lock()
+			if lost != nil {
+				err = lost
+			}
+			mux.Unlock()
+		})
+		return err
+	}
+
+	return g, gctx, release, nil
+}
@@ -0,0 +1,74 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redisLib "github.com/go-redis/redis/v8"
+	"github.com/keepchen/corgi"
+)
+
+func newTestLocker(t *testing.T) *miniredis.Miniredis {
+	mr := miniredis.RunT(t)
+	l, err := corgi.NewLocker(&redisLib.Options{Addr: mr.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetLocker(l)
+	t.Cleanup(func() { SetLocker(nil) })
+	return mr
+}
+
+func TestLockGroup(t *testing.T) {
+	mr := newTestLocker(t)
+	ctx := context.Background()
+
+	g, gctx, release, err := LockGroup(ctx, "batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err = LockGroup(ctx, "batch"); err != corgi.ErrNotAcquired {
+		t.Fatalf("expected ErrNotAcquired, got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		g.Go(func() error { return gctx.Err() })
+	}
+	if err = g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err = release(); err != nil {
+		t.Fatal(err)
+	}
+	if len(mr.Keys()) != 0 {
+		t.Fatalf("expected lock to be released, got %v", mr.Keys())
+	}
+}
+
+func TestLockGroupLost(t *testing.T) {
+	newTestLocker(t)
+	renewal, stop := context.WithCancel(context.Background())
+
+	g, gctx, release, err := LockGroup(context.Background(), "batch", corgi.WithRenewalContext(renewal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Go(func() error {
+		<-gctx.Done()
+		return gctx.Err()
+	})
+
+	stop()
+	select {
+	case <-gctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected group context to be cancelled after the lock is lost")
+	}
+	_ = g.Wait()
+	if err = release(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected loss reason, got %v", err)
+	}
+}
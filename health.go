@@ -0,0 +1,130 @@
+package corgi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// 是否在初始化、Verify及Ping时逐个检查cluster主节点
+var clusterDeepCheck bool
+
+// SetClusterDeepCheck 设置是否对cluster的所有主节点进行健康检查
+//
+// cluster模式下默认的ping只会检查其中一个节点，部分分片不可用时仍能通过，
+// 但对应slot上的键会加锁失败。开启后在初始化、Verify及Ping时通过ForEachMaster逐个ping主节点，
+// 并在UnreachableShardsError中列出不可达的分片。节点较多时开销更大，默认不开启。
+func SetClusterDeepCheck(enabled bool) {
+	clusterDeepCheck = enabled
+}
+
+// UnreachableShardsError cluster中不可达的主节点
+type UnreachableShardsError struct {
+	// Shards 不可达的主节点地址及对应的错误
+	Shards map[string]error
+}
+
+func (e *UnreachableShardsError) Error() string {
+	addrs := make([]string, 0, len(e.Shards))
+	for addr := range e.Shards {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	details := make([]string, len(addrs))
+	for i, addr := range addrs {
+		details[i] = fmt.Sprintf("%s: %v", addr, e.Shards[addr])
+	}
+
+	return fmt.Sprintf("corgi: %d cluster shard(s) unreachable: %s", len(addrs), strings.Join(details, "; "))
+}
+
+// 逐个ping cluster的主节点
+func pingClusterMasters(ctx context.Context, c *redisLib.ClusterClient) error {
+	var (
+		mux         sync.Mutex
+		unreachable = make(map[string]error)
+	)
+
+	err := c.ForEachMaster(ctx, func(ctx context.Context, node *redisLib.Client) error {
+		if err := node.Ping(ctx).Err(); err != nil {
+			mux.Lock()
+			unreachable[node.Options().Addr] = err
+			mux.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(unreachable) > 0 {
+		return &UnreachableShardsError{Shards: unreachable}
+	}
+
+	return nil
+}
+
+// ErrRenewalLag 自动续期滞后，持有的锁有过期的风险
+var ErrRenewalLag = errors.New("corgi: renewal lagging")
+
+// 续期滞后超过锁TTL的该比例时Healthz视为不健康
+var healthzLagRatio = 0.5
+
+// SetHealthzLagRatio 设置Healthz判定不健康的续期滞后比例(相对于锁的TTL)，取值范围(0, 1]，默认0.5
+func SetHealthzLagRatio(ratio float64) {
+	if ratio > 0 && ratio <= 1 {
+		healthzLagRatio = ratio
+	}
+}
+
+// RenewalLag 本进程持有的所有锁中，距离最近一次成功续期(或获取)最久的时长
+//
+// 正常情况下不会超过续期间隔；持续增长并接近TTL说明redis响应变慢或续期受阻，锁有过期的风险。
+// 未持有锁时返回0。
+func RenewalLag() time.Duration {
+	lag, _, _ := lockDriver.renewalLag()
+	return lag
+}
+
+// Healthz 续期滞后超过锁TTL的一定比例(见SetHealthzLagRatio)时返回ErrRenewalLag，可直接用于健康检查接口
+//
+// 各锁按自身的TTL(包括Relock设置的TTL)判断。
+func Healthz() error {
+	_, key, lag := lockDriver.renewalLag()
+	if key != "" {
+		return fmt.Errorf("%w: %s not renewed for %s", ErrRenewalLag, key, lag.Round(time.Millisecond))
+	}
+	return nil
+}
+
+// 最大的续期滞后，以及滞后超过阈值的锁中相对于TTL最严重的一个及其滞后
+func (rd *redisDriver) renewalLag() (time.Duration, string, time.Duration) {
+	var (
+		now      = time.Now()
+		maxLag   time.Duration
+		worst    string
+		worstLag time.Duration
+		maxRatio float64
+		lo       lockOptions
+	)
+	rd.states.each(func(key string, st *lockState) {
+		if st.ended.Load() {
+			return
+		}
+		lag := now.Sub(time.Unix(0, st.lastRenewed.Load()))
+		if lag > maxLag {
+			maxLag = lag
+		}
+		if ratio := float64(lag) / float64(st.leaseTTL(&lo)); ratio > healthzLagRatio && ratio > maxRatio {
+			maxRatio, worst, worstLag = ratio, st.key, lag
+		}
+	})
+
+	return maxLag, worst, worstLag
+}
@@ -0,0 +1,77 @@
+package corgi
+
+import (
+	"context"
+	"strings"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// 祖先键均未被其他持有者占用时才获取锁
+//
+// KEYS[1]为锁的键，KEYS[2..]为祖先键；ARGV[1]为锁的值，ARGV[2]为TTL(毫秒)，
+// ARGV[3..]为本进程持有的对应祖先键的值(未持有时为空字符串)。
+var hierarchyLockScript = redisLib.NewScript(`
+for i = 2, #KEYS do
+	local v = redis.call("GET", KEYS[i])
+	if v and v ~= ARGV[i + 1] then
+		return 0
+	end
+end
+if redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2], "NX") then
+	return 1
+end
+return 0
+`)
+
+// WithHierarchy 按层级加锁
+//
+// 以分隔符":"划分层级，如"dir:a:b"的祖先为"dir"和"dir:a"。加锁前检查所有祖先键，
+// 任一祖先被其他持有者占用时视为锁被占用；本进程持有的祖先不影响加锁。
+// 注意只检查祖先，对父级加锁时不会检查子级；cluster模式下需通过hash tag(见SetKeyRouter)
+// 使同一层级的键位于同一个slot。每次加锁都需要额外检查祖先键，默认不开启。
+func WithHierarchy() LockOption {
+	return func(lo *lockOptions) {
+		lo.hierarchy = true
+	}
+}
+
+func (rd *redisDriver) setNXHierarchy(ctx context.Context, c redisLib.UniversalClient, key string, value string, lo *lockOptions) (bool, error) {
+	ancestors := ancestorKeys(key)
+	keys := make([]string, 0, len(ancestors)+1)
+	args := make([]interface{}, 0, len(ancestors)+2)
+
+	keys = append(keys, redisKey(key))
+	args = append(args, value, lo.initialTTL().Milliseconds())
+	for _, ancestor := range ancestors {
+		rkey := redisKey(ancestor)
+		keys = append(keys, rkey)
+
+		held := ""
+		if st, ok := rd.states.load(rd.stateKey(lo, rkey)); ok {
+			held = st.value
+		}
+		args = append(args, held)
+	}
+
+	n, err := hierarchyLockScript.Run(ctx, c, keys, args...).Int()
+	return n > 0, err
+}
+
+// 按层级拆分出所有祖先键，忽略被转义的分隔符(见NewKey)
+func ancestorKeys(key string) []string {
+	var ancestors []string
+	escaped := false
+	for i := 0; i < len(key); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case key[i] == '\\':
+			escaped = true
+		case strings.HasPrefix(key[i:], keySeparator) && i > 0:
+			ancestors = append(ancestors, key[:i])
+		}
+	}
+
+	return ancestors
+}
@@ -0,0 +1,75 @@
+package corgi
+
+import (
+	"context"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// Hooks 事件回调，未设置的回调不会被调用
+//
+// 回调在加锁/续期等流程中同步执行，应尽快返回。
+type Hooks struct {
+	// OnAcquired 阻塞加锁(Lock)成功后调用，waited为从开始等待到获取锁的时长
+	OnAcquired func(key string, waited time.Duration)
+	// OnRenewTick 自动续期每次执行续期命令后调用，success表示是否续期成功
+	OnRenewTick func(key string, success bool)
+	// OnLocked 每次获取锁成功后调用，info为写入的持有者信息
+	OnLocked func(key string, info LockInfo)
+	// OnReleased 释放锁(Unlock、句柄的Unlock、自动释放及Shutdown)后调用，released表示是否实际删除了锁
+	//
+	// info为释放时比较的持有者信息，与获取时OnLocked收到的token及labels(如trace ID)一致，
+	// 可用于关联同一把锁获取与释放的日志；本地未持有时从redis读取被删除的值。
+	OnReleased func(key string, info LockInfo, released bool)
+	// OnOwnershipLost Unlock或Extend发现redis中的值已是其他持有者写入的值时调用，expected为本进程写入的值，actual为当前的值
+	//
+	// 说明本进程仍以为持有锁时锁已过期并被其他持有者获取，两者可能同时执行了受保护的操作，
+	// 通常是TTL或续期间隔设置不当，适合单独告警。锁已不存在(过期后无人获取或重复释放)时不会调用。
+	OnOwnershipLost func(key string, expected string, actual string)
+}
+
+var hooks Hooks
+
+// SetHooks 设置事件回调
+func SetHooks(h Hooks) {
+	hooks = h
+}
+
+// 通知获取锁成功
+func lockedHook(key string, value string) {
+	if hooks.OnLocked == nil {
+		return
+	}
+	info, _ := parseLockerValue(value)
+	hooks.OnLocked(key, info)
+}
+
+// 通知锁已被其他持有者获取，actual为空(锁已不存在)或与expected相同时不通知
+func ownershipLostHook(key string, expected string, actual string) {
+	if hooks.OnOwnershipLost == nil || actual == "" || actual == expected {
+		return
+	}
+	hooks.OnOwnershipLost(key, expected, actual)
+}
+
+// 按值比较失败后读取当前的值，通知锁已被其他持有者获取，未设置OnOwnershipLost时不读取
+func checkOwnership(ctx context.Context, c redisLib.UniversalClient, key string, rkey string, expected string) {
+	if hooks.OnOwnershipLost == nil {
+		return
+	}
+	actual, err := c.Get(ctx, rkey).Result()
+	if err != nil {
+		return
+	}
+	ownershipLostHook(key, expected, actual)
+}
+
+// 通知释放锁，value为释放时比较(或删除)的值
+func releasedHook(key string, value string, released bool) {
+	if hooks.OnReleased == nil {
+		return
+	}
+	info, _ := parseLockerValue(value)
+	hooks.OnReleased(key, info, released)
+}
@@ -0,0 +1,52 @@
+package corgi
+
+import (
+	"context"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// SET NX锁成功时才INCR计数器KEYS[2]，返回计数器的新值，锁被占用时返回-1
+var lockIncrScript = redisLib.NewScript(`
+if not redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2], "NX") then
+	return -1
+end
+return redis.call("INCR", KEYS[2])
+`)
+
+// 获取锁时一并递增的计数器，count为递增后的值
+type lockIncr struct {
+	key   string
+	count int64
+}
+
+// TryLockAndIncr 尝试获取锁，仅在获取成功时在同一个脚本中递增counterKey，返回计数器的新值
+//
+// 用于"第一个获取到锁的才计数"之类需要与获取严格一一对应的副作用(去重统计、首次出现判断)，
+// 锁被占用时不修改计数器，返回false和0。counterKey按原样使用，不添加锁的前缀，也不设置过期时间；
+// cluster模式下需通过hash tag保证两者位于同一个slot。
+func (rd *redisDriver) TryLockAndIncr(ctx context.Context, lockKey string, counterKey string, opts ...LockOption) (acquired bool, newCount int64, err error) {
+	incr := &lockIncr{key: counterKey}
+	acquired, err = rd.TryLockE(ctx, lockKey, append(opts, func(lo *lockOptions) {
+		lo.incr = incr
+	})...)
+	if err != nil || !acquired {
+		return false, 0, err
+	}
+
+	return true, incr.count, nil
+}
+
+func setNXIncr(ctx context.Context, c redisLib.UniversalClient, rkey string, value string, incr *lockIncr, ttl time.Duration) (bool, error) {
+	n, err := lockIncrScript.Run(ctx, c, []string{rkey, incr.key}, value, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return false, err
+	}
+	if n < 0 {
+		return false, nil
+	}
+	incr.count = n
+
+	return true, nil
+}
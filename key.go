@@ -0,0 +1,165 @@
+package corgi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// 键各部分之间的分隔符
+const keySeparator = ":"
+
+var (
+	namespace      string
+	maxKeyLength   int
+	keyRouter      func(key string) string
+	keyPartEscaper = strings.NewReplacer(`\`, `\\`, keySeparator, `\`+keySeparator)
+)
+
+// SetNamespace 设置键的命名空间，所有操作的键都会加上"<ns>:"前缀
+//
+// 需在加锁前设置，运行期间修改会导致已持有的锁无法被正确释放。
+func SetNamespace(ns string) {
+	namespace = ns
+}
+
+// SetMaxKeyLength 设置键的最大长度(字节，不含命名空间前缀)，为0时不限制
+func SetMaxKeyLength(n int) {
+	maxKeyLength = n
+}
+
+// 校验键不为空且不超过最大长度
+//
+// 空键会让不相关的调用方互相冲突，基本都是调用方的bug。
+func validateKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("%w: empty key", ErrInvalidKey)
+	}
+	if maxKeyLength > 0 && len(key) > maxKeyLength {
+		return fmt.Errorf("%w: key length %d exceeds %d", ErrInvalidKey, len(key), maxKeyLength)
+	}
+
+	return nil
+}
+
+// Key 结构化的锁键
+//
+// 常用的加锁、续期、释放及查询提供了以Locker为参数的便捷方法，其余Locker的方法通过Key.String()传入键。
+type Key string
+
+// NewKey 使用分隔符拼接各部分生成键
+//
+// 各部分中出现的分隔符会被转义，避免 NewKey("a:b", "c") 与 NewKey("a", "b:c") 冲突。
+// 命名空间前缀在加锁时统一加上，无需包含在parts中。
+func NewKey(parts ...string) Key {
+	escaped := make([]string, len(parts))
+	for i, part := range parts {
+		escaped[i] = keyPartEscaper.Replace(part)
+	}
+
+	return Key(strings.Join(escaped, keySeparator))
+}
+
+// String 键的字符串形式，可直接传给Locker的各个方法
+func (k Key) String() string {
+	return string(k)
+}
+
+// Join 在键后追加各部分生成子键，各部分的转义规则与NewKey相同
+func (k Key) Join(parts ...string) Key {
+	if k == "" {
+		return NewKey(parts...)
+	}
+
+	return k + keySeparator + NewKey(parts...)
+}
+
+// TryLock 使用l尝试获取键k上的锁
+func (k Key) TryLock(ctx context.Context, l Locker, opts ...LockOption) (bool, error) {
+	return l.TryLockE(ctx, k.String(), opts...)
+}
+
+// Acquire 使用l尝试获取键k上的锁，成功时返回锁的句柄
+func (k Key) Acquire(ctx context.Context, l Locker, opts ...LockOption) (*Lock, error) {
+	return l.Acquire(ctx, k.String(), opts...)
+}
+
+// Unlock 使用l释放键k上的锁
+func (k Key) Unlock(ctx context.Context, l Locker, opts ...LockOption) (UnlockResult, error) {
+	res, _, err := l.UnlockE(ctx, k.String(), opts...)
+	return res, err
+}
+
+// Lock 使用l获取键k上的锁，锁被占用时等待直到获取成功或ctx结束
+func (k Key) Lock(ctx context.Context, l Locker, opts ...LockOption) (*Lock, error) {
+	return l.Lock(ctx, k.String(), opts...)
+}
+
+// Extend 使用l为本进程持有的键k上的锁续期
+func (k Key) Extend(ctx context.Context, l Locker, opts ...LockOption) bool {
+	return l.Extend(ctx, k.String(), opts...)
+}
+
+// IsLocked 使用l查询键k上的锁当前是否被(任意进程)持有
+func (k Key) IsLocked(ctx context.Context, l Locker) (bool, error) {
+	return l.IsLocked(ctx, k.String())
+}
+
+// SetKeyRouter 设置键路由函数，将(已加上命名空间前缀的)键映射为实际写入redis的键
+//
+// 用于cluster模式下通过hash tag控制键所在的slot，例如：
+//
+//	corgi.SetKeyRouter(func(key string) string { return "{dc1}" + key })
+//
+// 路由对所有按键操作的接口生效，按模式匹配的接口(如ReleaseByHost)会对加上命名空间前缀的模式同样应用路由，
+// 因此路由函数只应在键前后添加固定内容(如hash tag)。路由函数需对同一个键始终返回相同的结果。
+func SetKeyRouter(router func(key string) string) {
+	keyRouter = router
+}
+
+// 加上环境名称及命名空间前缀
+func namespacedKey(key string) string {
+	if namespace != "" {
+		key = namespace + keySeparator + key
+	}
+
+	return environmentKey(key)
+}
+
+// 实际写入redis的键
+func redisKey(key string) string {
+	key = namespacedKey(key)
+	if keyRouter != nil {
+		key = keyRouter(key)
+	}
+
+	return key
+}
+
+// 本包内部使用的附属于key的键(如信号量、排队)，以"#"加用途后缀与调用方的键区分
+//
+// 实际的键不含hash tag时以"{<键>}"作为hash tag，同一个key的各附属键与锁的键在cluster模式下位于同一个slot，
+// 多键脚本无需调用方自行添加hash tag。已含hash tag(如通过SetKeyRouter添加)时沿用其hash tag。
+func companionKey(key string, kind string) string {
+	rkey := redisKey(key)
+	if !hasHashTag(rkey) {
+		rkey = "{" + rkey + "}"
+	}
+
+	return rkey + "#" + kind
+}
+
+// 是否包含非空的hash tag
+func hasHashTag(key string) bool {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return false
+	}
+
+	return strings.IndexByte(key[start+1:], '}') > 0
+}
+
+// 实际用于SCAN匹配的模式
+func redisPattern(pattern string) string {
+	return redisKey(pattern)
+}
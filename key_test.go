@@ -0,0 +1,208 @@
+package corgi
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestNewKey(t *testing.T) {
+	if k := NewKey("order", "42"); k.String() != "order:42" {
+		t.Fatalf("unexpected key %q", k)
+	}
+	if NewKey("a:b", "c") == NewKey("a", "b:c") {
+		t.Fatal("expected keys with separators in parts not to collide")
+	}
+	if NewKey(`a\`, "b") == NewKey("a", `\b`) {
+		t.Fatal("expected keys with escape characters in parts not to collide")
+	}
+}
+
+func TestRedisKey(t *testing.T) {
+	SetNamespace("app")
+	SetKeyRouter(func(key string) string { return "{dc1}" + key })
+	defer func() {
+		SetNamespace("")
+		SetKeyRouter(nil)
+	}()
+
+	if k := redisKey("order:1"); k != "{dc1}app:order:1" {
+		t.Fatalf("unexpected redis key %q", k)
+	}
+	if p := redisPattern("order:*"); p != "{dc1}app:order:*" {
+		t.Fatalf("unexpected redis pattern %q", p)
+	}
+	//已含hash tag时沿用，否则以实际的键作为hash tag
+	if k := companionKey("order:1", "queue"); k != "{dc1}app:order:1#queue" {
+		t.Fatalf("unexpected companion key %q", k)
+	}
+	SetKeyRouter(nil)
+	if k := companionKey("order:1", "queue"); k != "{app:order:1}#queue" {
+		t.Fatalf("unexpected companion key %q", k)
+	}
+	if p := redisPattern("order:*"); p != "app:order:*" {
+		t.Fatalf("unexpected redis pattern %q", p)
+	}
+}
+
+func TestKeyLock(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	k := NewKey("order").Join("42", "a:b")
+	if k != NewKey("order", "42", "a:b") {
+		t.Fatalf("unexpected key %q", k)
+	}
+	l, err := k.Acquire(ctx, rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Key() != k.String() || !mr.Exists(redisKey(k.String())) {
+		t.Fatalf("unexpected key %q", l.Key())
+	}
+	if ok, err := k.TryLock(ctx, rd); ok || err != nil {
+		t.Fatalf("expected contention on the same key, got %v %v", ok, err)
+	}
+	if locked, err := k.IsLocked(ctx, rd); !locked || err != nil {
+		t.Fatalf("expected key to be locked, got %v %v", locked, err)
+	}
+	if !k.Extend(ctx, rd) {
+		t.Fatal("expected extend on the held key")
+	}
+	if res, err := k.Unlock(ctx, rd); res != UnlockReleased || err != nil {
+		t.Fatalf("expected unlock, got %v %v", res, err)
+	}
+	if locked, _ := k.IsLocked(ctx, rd); locked {
+		t.Fatal("expected key to be unlocked")
+	}
+
+	if l, err = k.Lock(ctx, rd); err != nil || l.Key() != k.String() {
+		t.Fatalf("expected lock, got %v", err)
+	}
+	_ = l.Unlock(ctx)
+}
+
+func TestEnvironmentKey(t *testing.T) {
+	rec := &recordingLogger{}
+	prev := logger
+	SetLogger(rec)
+	defer SetLogger(prev)
+	defer SetStrictEnvironment(false)
+	defer SetEnvironment("")
+
+	SetNamespace("app")
+	defer SetNamespace("")
+	SetEnvironment("staging")
+	if k := redisKey("order:1"); k != "staging:app:order:1" {
+		t.Fatalf("unexpected redis key %q", k)
+	}
+
+	t.Setenv(EnvironmentVariable, "prod")
+	SetEnvironment("")
+	if k := redisKey("order:1"); k != "prod:app:order:1" {
+		t.Fatalf("expected %s to be used, got %q", EnvironmentVariable, k)
+	}
+
+	t.Setenv(EnvironmentVariable, "")
+	SetEnvironment("")
+	SetStrictEnvironment(true)
+	envWarnOnce = sync.Once{}
+	redisKey("order:1")
+	redisKey("order:2")
+	if n := rec.count(); n != 1 {
+		t.Fatalf("expected one warning for the unset environment, got %d", n)
+	}
+}
+
+func TestAncestorKeys(t *testing.T) {
+	got := ancestorKeys(NewKey("dir", "a:b", "file").String())
+	want := []string{"dir", `dir:a\:b`}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+
+	if got = ancestorKeys("root"); len(got) != 0 {
+		t.Fatalf("expected no ancestors, got %v", got)
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	if _, err := rd.TryLockE(ctx, ""); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected ErrInvalidKey for empty key, got %v", err)
+	}
+	if rd.Unlock(ctx, "") || rd.Extend(ctx, "") {
+		t.Fatal("expected empty key to be rejected")
+	}
+	if _, err := rd.Owner(ctx, ""); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected Owner to reject empty key, got %v", err)
+	}
+	if _, err := rd.Owners(ctx, []string{"a", ""}); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected Owners to reject empty key, got %v", err)
+	}
+	if _, err := rd.AcquireData(ctx, ""); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected AcquireData to reject empty key, got %v", err)
+	}
+
+	prev := maxKeyLength
+	SetMaxKeyLength(8)
+	defer SetMaxKeyLength(prev)
+	if _, err := rd.TryLockE(ctx, "longer-than-8"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected ErrInvalidKey for oversized key, got %v", err)
+	}
+	if ok, err := rd.TryLockE(ctx, "short"); !ok || err != nil {
+		t.Fatalf("expected key within limit to acquire, got %v %v", ok, err)
+	}
+}
+
+type userResource struct {
+	UserID   int64
+	Resource string
+}
+
+func TestKeyEncoder(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	if k, err := EncodeKey([]any{"user", int64(42), "a:b"}); err != nil || k != `user:42:a\:b` {
+		t.Fatalf("unexpected key %q %v", k, err)
+	}
+	if _, err := EncodeKey(3.5); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected ErrInvalidKey for unsupported input, got %v", err)
+	}
+	if _, err := EncodeKey([]string{}); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected ErrInvalidKey for empty key, got %v", err)
+	}
+
+	SetKeyEncoder(func(v any) (string, error) {
+		if r, ok := v.(userResource); ok {
+			return NewKey("user", strconv.FormatInt(r.UserID, 10), r.Resource).String(), nil
+		}
+		return defaultKeyEncoder(v)
+	})
+	defer SetKeyEncoder(nil)
+
+	l, err := AcquireFor(ctx, rd, userResource{UserID: 7, Resource: "cart"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Key() != "user:7:cart" || !mr.Exists(redisKey("user:7:cart")) {
+		t.Fatalf("unexpected key %q", l.Key())
+	}
+	//同一个输入得到相同的键
+	if ok, err := TryLockFor(ctx, rd, userResource{UserID: 7, Resource: "cart"}); ok || err != nil {
+		t.Fatalf("expected contention on the same key, got %v %v", ok, err)
+	}
+	if ok, err := UnlockFor(ctx, rd, userResource{UserID: 7, Resource: "cart"}); !ok || err != nil {
+		t.Fatalf("expected unlock, got %v %v", ok, err)
+	}
+}
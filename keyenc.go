@@ -0,0 +1,107 @@
+package corgi
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+var keyEncoder = defaultKeyEncoder
+
+// SetKeyEncoder 设置将结构化的输入(如用户ID+资源类型)编码为锁键的函数，为nil时恢复默认的编码
+//
+// 供EncodeKey及AcquireFor等接受任意输入的加锁函数使用，保证不同调用方对"同一个东西"加锁时得到相同的键。
+// 编码函数需对相等的输入始终返回相同的结果。默认的编码支持string、Key、fmt.Stringer、整数、
+// 以及由它们组成的[]string/[]any(各元素按NewKey拼接并转义)。
+func SetKeyEncoder(enc func(v any) (string, error)) {
+	if enc == nil {
+		enc = defaultKeyEncoder
+	}
+	keyEncoder = enc
+}
+
+// EncodeKey 使用SetKeyEncoder设置的编码生成锁键，并校验键不为空且不超过最大长度
+func EncodeKey(v any) (string, error) {
+	key, err := keyEncoder(v)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	if err = validateKey(key); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+func defaultKeyEncoder(v any) (string, error) {
+	switch k := v.(type) {
+	case []string:
+		return NewKey(k...).String(), nil
+	case []any:
+		parts := make([]string, len(k))
+		for i, part := range k {
+			s, err := keyPart(part)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = s
+		}
+		return NewKey(parts...).String(), nil
+	default:
+		return keyPart(v)
+	}
+}
+
+// 单个键的组成部分
+func keyPart(v any) (string, error) {
+	switch k := v.(type) {
+	case string:
+		return k, nil
+	case Key:
+		return k.String(), nil
+	case fmt.Stringer:
+		return k.String(), nil
+	case int:
+		return strconv.Itoa(k), nil
+	case int64:
+		return strconv.FormatInt(k, 10), nil
+	case int32:
+		return strconv.FormatInt(int64(k), 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(k), 10), nil
+	case uint64:
+		return strconv.FormatUint(k, 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(k), 10), nil
+	default:
+		return "", fmt.Errorf("unsupported key type %T", v)
+	}
+}
+
+// TryLockFor 使用EncodeKey将v编码为键后尝试获取锁
+func TryLockFor(ctx context.Context, l Locker, v any, opts ...LockOption) (bool, error) {
+	key, err := EncodeKey(v)
+	if err != nil {
+		return false, err
+	}
+	return l.TryLockE(ctx, key, opts...)
+}
+
+// AcquireFor 使用EncodeKey将v编码为键后尝试获取锁，成功时返回锁的句柄
+func AcquireFor(ctx context.Context, l Locker, v any, opts ...LockOption) (*Lock, error) {
+	key, err := EncodeKey(v)
+	if err != nil {
+		return nil, err
+	}
+	return l.Acquire(ctx, key, opts...)
+}
+
+// UnlockFor 使用EncodeKey将v编码为键后释放锁
+func UnlockFor(ctx context.Context, l Locker, v any, opts ...LockOption) (bool, error) {
+	key, err := EncodeKey(v)
+	if err != nil {
+		return false, err
+	}
+	res, _, err := l.UnlockE(ctx, key, opts...)
+	return res == UnlockReleased, err
+}
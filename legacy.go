@@ -0,0 +1,65 @@
+package corgi
+
+import (
+	"context"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// 未加命名空间前缀的旧键(KEYS[2])不存在时才写入新键，返回1表示获取成功
+var setNXLegacyScript = redisLib.NewScript(`
+if redis.call("EXISTS", KEYS[2]) == 1 then
+	return 0
+end
+if redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2], "NX") then
+	return 1
+end
+return 0
+`)
+
+// WithLegacyKeyFallback 命名空间迁移期间同时检查未加前缀的旧键，仅作为临时的迁移手段
+//
+// 已在生产环境运行后再启用命名空间(SetNamespace)时，旧版本进程仍写入不带前缀的键，
+// 新旧进程可能同时获得同一把锁。开启后加锁在同一个脚本中确认旧键不存在才写入新键，
+// 释放时按相同的规则(按值比较或直接删除)一并清理旧键。所有进程升级完成、旧键全部过期后应移除该选项。
+//
+// 仅对TryLock/Acquire/Lock及释放生效，与任期、层级锁、等待副本同步等选项同时使用时不检查旧键；
+// cluster模式下新旧键需通过hash tag位于同一个slot。未设置命名空间时不起作用。
+func WithLegacyKeyFallback() LockOption {
+	return func(lo *lockOptions) {
+		lo.legacyFallback = true
+	}
+}
+
+// 未加命名空间前缀的旧键，与新键相同时返回空
+func legacyRedisKey(lo *lockOptions, key string) string {
+	if !lo.legacyFallback {
+		return ""
+	}
+
+	legacy := key
+	if keyRouter != nil {
+		legacy = keyRouter(key)
+	}
+	if legacy == redisKey(key) {
+		return ""
+	}
+	return legacy
+}
+
+// 旧键不存在时写入新键
+func setNXLegacy(ctx context.Context, c redisLib.UniversalClient, rkey string, legacy string, value string, ttl time.Duration) (bool, error) {
+	n, err := setNXLegacyScript.Run(ctx, c, []string{rkey, legacy}, value, ttl.Milliseconds()).Int()
+	return n > 0, err
+}
+
+// 清理旧键，value为空时直接删除，返回是否删除了旧键
+func (rd *redisDriver) unlockLegacy(ctx context.Context, c redisLib.UniversalClient, legacy string, value string) (bool, error) {
+	if value != "" {
+		return rd.compareAndDelete(ctx, c, legacy, value)
+	}
+
+	n, err := c.Del(ctx, legacy).Result()
+	return n > 0, err
+}
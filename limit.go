@@ -0,0 +1,285 @@
+package corgi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// ErrTooManyLocks 持有的锁数量已达到上限
+var ErrTooManyLocks = errors.New("corgi: too many locks held")
+
+// LimitedLocker 限制本进程同时持有的锁数量的Locker，见LimitConcurrentLocks
+type LimitedLocker struct {
+	Locker
+
+	max     int
+	mux     sync.Mutex
+	pending int
+	held    map[string]struct{}
+}
+
+// LimitConcurrentLocks 包装l，持有的锁达到max把后拒绝新的加锁并返回ErrTooManyLocks
+//
+// 用于防止循环中忘记释放等bug无限制地占用锁。l为本包创建的Locker时按其仍在续期的本地状态计数
+// (包括通过句柄获取和释放的锁，已丢失的锁不占用名额)；否则，以及RenewalNone模式下驱动不保存本地状态时，
+// 仅统计经由返回的Locker获取和按key释放的锁，通过句柄释放或随TTL过期的锁不会归还名额。
+func LimitConcurrentLocks(l Locker, max int) *LimitedLocker {
+	return &LimitedLocker{Locker: l, max: max, held: make(map[string]struct{})}
+}
+
+// Held 当前持有的锁数量
+func (ll *LimitedLocker) Held() int {
+	ll.mux.Lock()
+	defer ll.mux.Unlock()
+	return ll.heldLocked()
+}
+
+func (ll *LimitedLocker) heldLocked() int {
+	if rd, ok := ll.Locker.(*redisDriver); ok && renewalMode != RenewalNone {
+		return rd.states.live()
+	}
+	return len(ll.held)
+}
+
+// 预留n个名额，返回实际预留的数量
+func (ll *LimitedLocker) reserve(n int) int {
+	ll.mux.Lock()
+	defer ll.mux.Unlock()
+
+	free := ll.max - ll.heldLocked() - ll.pending
+	if free < n {
+		n = free
+	}
+	if n < 0 {
+		n = 0
+	}
+	ll.pending += n
+	return n
+}
+
+// 归还预留的名额，并记录获取成功的key
+func (ll *LimitedLocker) settle(reserved int, acquired ...string) {
+	ll.mux.Lock()
+	defer ll.mux.Unlock()
+
+	ll.pending -= reserved
+	for _, key := range acquired {
+		ll.held[key] = struct{}{}
+	}
+}
+
+// 预留一个名额执行fn，fn返回true时记录key
+func (ll *LimitedLocker) guard(key string, fn func() bool) error {
+	if ll.reserve(1) == 0 {
+		return ErrTooManyLocks
+	}
+	if fn() {
+		ll.settle(1, key)
+	} else {
+		ll.settle(1)
+	}
+	return nil
+}
+
+func (ll *LimitedLocker) TryLock(ctx context.Context, key string, opts ...LockOption) bool {
+	ok, _ := ll.TryLockE(ctx, key, opts...)
+	return ok
+}
+
+func (ll *LimitedLocker) TryLockE(ctx context.Context, key string, opts ...LockOption) (ok bool, err error) {
+	if gerr := ll.guard(key, func() bool {
+		ok, err = ll.Locker.TryLockE(ctx, key, opts...)
+		return ok
+	}); gerr != nil {
+		return false, gerr
+	}
+	return ok, err
+}
+
+func (ll *LimitedLocker) TryLockAs(ctx context.Context, key string, token string, opts ...LockOption) (ok bool, err error) {
+	if gerr := ll.guard(key, func() bool {
+		ok, err = ll.Locker.TryLockAs(ctx, key, token, opts...)
+		return ok
+	}); gerr != nil {
+		return false, gerr
+	}
+	return ok, err
+}
+
+func (ll *LimitedLocker) TryLockWithTerm(ctx context.Context, key string, term int64, opts ...LockOption) (ok bool, err error) {
+	if gerr := ll.guard(key, func() bool {
+		ok, err = ll.Locker.TryLockWithTerm(ctx, key, term, opts...)
+		return ok
+	}); gerr != nil {
+		return false, gerr
+	}
+	return ok, err
+}
+
+func (ll *LimitedLocker) TryLockIf(ctx context.Context, key string, condKey string, expected string, opts ...LockOption) (ok bool, err error) {
+	if gerr := ll.guard(key, func() bool {
+		ok, err = ll.Locker.TryLockIf(ctx, key, condKey, expected, opts...)
+		return ok
+	}); gerr != nil {
+		return false, gerr
+	}
+	return ok, err
+}
+
+func (ll *LimitedLocker) TryLockStealStale(ctx context.Context, key string, olderThan time.Duration, opts ...LockOption) (res StealResult, err error) {
+	if gerr := ll.guard(key, func() bool {
+		res, err = ll.Locker.TryLockStealStale(ctx, key, olderThan, opts...)
+		return res != StealNotAcquired
+	}); gerr != nil {
+		return StealNotAcquired, gerr
+	}
+	return res, err
+}
+
+func (ll *LimitedLocker) TryLockAndIncr(ctx context.Context, lockKey string, counterKey string, opts ...LockOption) (ok bool, n int64, err error) {
+	if gerr := ll.guard(lockKey, func() bool {
+		ok, n, err = ll.Locker.TryLockAndIncr(ctx, lockKey, counterKey, opts...)
+		return ok
+	}); gerr != nil {
+		return false, 0, gerr
+	}
+	return ok, n, err
+}
+
+func (ll *LimitedLocker) Acquire(ctx context.Context, key string, opts ...LockOption) (l *Lock, err error) {
+	if gerr := ll.guard(key, func() bool {
+		l, err = ll.Locker.Acquire(ctx, key, opts...)
+		return err == nil
+	}); gerr != nil {
+		return nil, gerr
+	}
+	return l, err
+}
+
+func (ll *LimitedLocker) Lock(ctx context.Context, key string, opts ...LockOption) (l *Lock, err error) {
+	if gerr := ll.guard(key, func() bool {
+		l, err = ll.Locker.Lock(ctx, key, opts...)
+		return err == nil
+	}); gerr != nil {
+		return nil, gerr
+	}
+	return l, err
+}
+
+func (ll *LimitedLocker) LockFair(ctx context.Context, key string, opts ...LockOption) (l *Lock, err error) {
+	if gerr := ll.guard(key, func() bool {
+		l, err = ll.Locker.LockFair(ctx, key, opts...)
+		return err == nil
+	}); gerr != nil {
+		return nil, gerr
+	}
+	return l, err
+}
+
+func (ll *LimitedLocker) LockUntil(ctx context.Context, key string, deadline time.Time, opts ...LockOption) error {
+	_, err := ll.AcquireUntil(ctx, key, deadline, opts...)
+	return err
+}
+
+func (ll *LimitedLocker) AcquireUntil(ctx context.Context, key string, deadline time.Time, opts ...LockOption) (l *Lock, err error) {
+	if gerr := ll.guard(key, func() bool {
+		l, err = ll.Locker.AcquireUntil(ctx, key, deadline, opts...)
+		return err == nil
+	}); gerr != nil {
+		return nil, gerr
+	}
+	return l, err
+}
+
+// AcquireInPipeline 加入pipeline时预留一个名额，Commit得到结果后结算，未调用Commit的锁一直占用该名额
+func (ll *LimitedLocker) AcquireInPipeline(ctx context.Context, pipe redisLib.Pipeliner, key string, opts ...LockOption) (*PendingLock, error) {
+	if ll.reserve(1) == 0 {
+		return nil, ErrTooManyLocks
+	}
+	p, err := ll.Locker.AcquireInPipeline(ctx, pipe, key, opts...)
+	if err != nil {
+		ll.settle(1)
+		return nil, err
+	}
+
+	inner := p.settled
+	p.settled = func(acquired bool) {
+		if inner != nil {
+			inner(acquired)
+		}
+		if acquired {
+			ll.settle(1, key)
+		} else {
+			ll.settle(1)
+		}
+	}
+	return p, nil
+}
+
+func (ll *LimitedLocker) AcquireWithData(ctx context.Context, key string, data map[string]string, ttl time.Duration) (ok bool, err error) {
+	if gerr := ll.guard(key, func() bool {
+		ok, err = ll.Locker.AcquireWithData(ctx, key, data, ttl)
+		return ok
+	}); gerr != nil {
+		return false, gerr
+	}
+	return ok, err
+}
+
+// TryLockSome 超出剩余名额的key不会尝试获取
+func (ll *LimitedLocker) TryLockSome(ctx context.Context, keys []string, opts ...LockOption) ([]string, error) {
+	n := ll.reserve(len(keys))
+	if n == 0 && len(keys) > 0 {
+		return nil, ErrTooManyLocks
+	}
+
+	acquired, err := ll.Locker.TryLockSome(ctx, keys[:n], opts...)
+	ll.settle(n, acquired...)
+	return acquired, err
+}
+
+func (ll *LimitedLocker) Unlock(ctx context.Context, key string, opts ...LockOption) bool {
+	ok := ll.Locker.Unlock(ctx, key, opts...)
+	ll.forget(key)
+	return ok
+}
+
+func (ll *LimitedLocker) UnlockE(ctx context.Context, key string, opts ...LockOption) (UnlockResult, time.Duration, error) {
+	res, remaining, err := ll.Locker.UnlockE(ctx, key, opts...)
+	ll.forget(key)
+	return res, remaining, err
+}
+
+func (ll *LimitedLocker) UnlockAsync(ctx context.Context, key string, opts ...LockOption) <-chan error {
+	errc := ll.Locker.UnlockAsync(ctx, key, opts...)
+	ll.forget(key)
+	return errc
+}
+
+func (ll *LimitedLocker) UnlockMulti(ctx context.Context, keys []string, opts ...LockOption) []string {
+	released := ll.Locker.UnlockMulti(ctx, keys, opts...)
+	for _, key := range keys {
+		ll.forget(key)
+	}
+	return released
+}
+
+// Rename 转移成功时同时转移计数的key，不占用新的名额
+func (ll *LimitedLocker) Rename(ctx context.Context, oldKey string, newKey string, opts ...LockOption) (bool, error) {
+	ok, err := ll.Locker.Rename(ctx, oldKey, newKey, opts...)
+	if ok {
+		ll.forget(oldKey)
+		ll.settle(0, newKey)
+	}
+	return ok, err
+}
+
+func (ll *LimitedLocker) forget(key string) {
+	ll.mux.Lock()
+	delete(ll.held, key)
+	ll.mux.Unlock()
+}
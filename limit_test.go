@@ -0,0 +1,187 @@
+package corgi
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimitConcurrentLocks(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+	ll := LimitConcurrentLocks(rd, 2)
+
+	for _, key := range []string{"limit-1", "limit-2"} {
+		if ok, err := ll.TryLockE(ctx, key); !ok || err != nil {
+			t.Fatalf("expected %s to acquire, got %v %v", key, ok, err)
+		}
+	}
+	if _, err := ll.TryLockE(ctx, "limit-3"); err != ErrTooManyLocks {
+		t.Fatalf("expected ErrTooManyLocks, got %v", err)
+	}
+	if ll.Held() != 2 {
+		t.Fatalf("expected 2 held locks, got %d", ll.Held())
+	}
+
+	ll.Unlock(ctx, "limit-1")
+	l, err := ll.Acquire(ctx, "limit-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//通过句柄释放同样会归还名额
+	if err = l.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ll.Held() != 1 {
+		t.Fatalf("expected 1 held lock, got %d", ll.Held())
+	}
+}
+
+func TestLimitConcurrentLocksRenewalNone(t *testing.T) {
+	SetRenewalMode(RenewalNone)
+	defer SetRenewalMode(RenewalAuto)
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+	ll := LimitConcurrentLocks(rd, 1)
+
+	//驱动不保存本地状态时按经由ll获取的key计数
+	if ok, err := ll.TryLockE(ctx, "limit-1"); !ok || err != nil {
+		t.Fatalf("expected to acquire, got %v %v", ok, err)
+	}
+	if _, err := ll.TryLockE(ctx, "limit-2"); err != ErrTooManyLocks {
+		t.Fatalf("expected ErrTooManyLocks, got %v", err)
+	}
+	ll.Unlock(ctx, "limit-1")
+	if ok, err := ll.TryLockE(ctx, "limit-2"); !ok || err != nil {
+		t.Fatalf("expected to acquire after unlock, got %v %v", ok, err)
+	}
+}
+
+func TestLimitConcurrentLocksLost(t *testing.T) {
+	withRenewalInterval(t, 20*time.Millisecond)
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+	ll := LimitConcurrentLocks(rd, 1)
+
+	l, err := ll.Acquire(ctx, "limit-lost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr.Del(redisKey("limit-lost"))
+	select {
+	case <-l.Lost():
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected lock to be lost")
+	}
+
+	//已丢失但未释放的锁不占用名额
+	if ll.Held() != 0 {
+		t.Fatalf("expected no held locks, got %d", ll.Held())
+	}
+	if ok, err := ll.TryLockE(ctx, "limit-other"); !ok || err != nil {
+		t.Fatalf("expected to acquire, got %v %v", ok, err)
+	}
+}
+
+func TestLimitAcquireWithData(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+	ll := LimitConcurrentLocks(rd, 1)
+
+	if ok, err := ll.AcquireWithData(ctx, "limit-data-1", map[string]string{"leader": "a"}, 0); !ok || err != nil {
+		t.Fatalf("expected to acquire, got %v %v", ok, err)
+	}
+	if _, err := ll.AcquireWithData(ctx, "limit-data-2", nil, 0); err != ErrTooManyLocks {
+		t.Fatalf("expected ErrTooManyLocks, got %v", err)
+	}
+	ll.Unlock(ctx, "limit-data-1")
+	if ok, err := ll.AcquireWithData(ctx, "limit-data-2", nil, 0); !ok || err != nil {
+		t.Fatalf("expected to acquire after unlock, got %v %v", ok, err)
+	}
+}
+
+func TestLimitLockFair(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+	ll := LimitConcurrentLocks(rd, 1)
+
+	l, err := ll.LockFair(ctx, "limit-fair-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ll.LockFair(ctx, "limit-fair-2"); err != ErrTooManyLocks {
+		t.Fatalf("expected ErrTooManyLocks, got %v", err)
+	}
+	if err = l.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if l, err = ll.LockFair(ctx, "limit-fair-2"); err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Unlock(ctx)
+}
+
+func TestLimitLockUntil(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+	ll := LimitConcurrentLocks(rd, 1)
+	deadline := time.Now().Add(time.Minute)
+
+	if err := ll.LockUntil(ctx, "limit-until-1", deadline); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ll.AcquireUntil(ctx, "limit-until-2", deadline); err != ErrTooManyLocks {
+		t.Fatalf("expected ErrTooManyLocks, got %v", err)
+	}
+	ll.Unlock(ctx, "limit-until-1")
+	l, err := ll.AcquireUntil(ctx, "limit-until-2", deadline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Unlock(ctx)
+}
+
+func TestLimitAcquireInPipeline(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+	ll := LimitConcurrentLocks(rd, 1)
+
+	pipe := rd.client.TxPipeline()
+	pending, err := ll.AcquireInPipeline(ctx, pipe, "limit-tx-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//提交前已预留名额
+	if _, err = ll.AcquireInPipeline(ctx, rd.client.TxPipeline(), "limit-tx-2"); err != ErrTooManyLocks {
+		t.Fatalf("expected ErrTooManyLocks, got %v", err)
+	}
+	_, _ = pipe.Exec(ctx)
+	l, err := pending.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ll.Held() != 1 {
+		t.Fatalf("expected 1 held lock, got %d", ll.Held())
+	}
+	if _, err = ll.TryLockE(ctx, "limit-tx-2"); err != ErrTooManyLocks {
+		t.Fatalf("expected ErrTooManyLocks, got %v", err)
+	}
+
+	//加锁失败时归还名额
+	if err = l.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mr.Set(redisKey("limit-tx-3"), "held elsewhere")
+	pipe = rd.client.TxPipeline()
+	if pending, err = ll.AcquireInPipeline(ctx, pipe, "limit-tx-3"); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = pipe.Exec(ctx)
+	if _, err = pending.Commit(ctx); err != ErrNotAcquired {
+		t.Fatalf("expected ErrNotAcquired, got %v", err)
+	}
+	if ok, err := ll.TryLockE(ctx, "limit-tx-2"); !ok || err != nil {
+		t.Fatalf("expected to acquire after a failed commit, got %v %v", ok, err)
+	}
+}
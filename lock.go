@@ -0,0 +1,669 @@
+package corgi
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// Lock 已获取的锁的句柄
+type Lock struct {
+	driver *redisDriver
+	key    string
+	token  string
+	value  string
+	lost   <-chan error
+	ticks  <-chan bool
+	lo     *lockOptions
+	info   LockInfo
+	//本地的状态，RenewalNone及重建的句柄为nil
+	st *lockState
+}
+
+// Key 锁的key
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Token 锁的token
+func (l *Lock) Token() string {
+	return l.token
+}
+
+// Value 实际存储在redis中的值
+//
+// 可由调用方自行保存，用于之后(包括在其他进程中)按值比较释放锁。
+func (l *Lock) Value() string {
+	return l.value
+}
+
+// Info 加锁时写入redis的持有者信息，由实际存储的值解析得到
+//
+// Key为redis中的键，TTL为加锁时设置的过期时间。
+func (l *Lock) Info() LockInfo {
+	return l.info
+}
+
+// Lost 锁丢失时收到丢失的原因
+//
+// 原因为ErrRenewalFailed(续期失败)、ErrMaxLifetime(超过最长持有时间)
+// 或续期context的错误(如context.Canceled)；主动释放锁时不会收到通知。
+func (l *Lock) Lost() <-chan error {
+	return l.lost
+}
+
+// Wait 阻塞直到锁丢失并返回丢失的原因(与Lost收到的相同)，或ctx结束时返回ctx.Err()
+//
+// 用于"持有期间一直运行，失去锁后退出"的循环(如选主后的leader)，不会消耗Lost中的通知。
+// 锁被主动释放时返回ErrNotHeld，AcquireUntil的锁到达截止时间时返回ErrDeadlinePassed；
+// 重建的句柄(Restore)及RenewalNone模式下只会因ctx结束而返回。
+func (l *Lock) Wait(ctx context.Context) error {
+	if l.st == nil {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	select {
+	case <-l.st.gone:
+		return l.st.goneReason
+	case <-l.st.cancel:
+		//释放与丢失同时发生时以丢失为准
+		select {
+		case <-l.st.gone:
+			return l.st.goneReason
+		default:
+			return ErrNotHeld
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Unlock 释放锁，仅当redis中的值仍为本句柄写入的值时才会删除
+//
+// 自动续期已放弃并通过Lost宣告锁丢失时返回ErrAlreadyLost，不再删除键，锁随TTL过期。
+func (l *Lock) Unlock(ctx context.Context) error {
+	ok, err := l.driver.unlockValue(ctx, l.key, l.value, l.lo)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotHeld
+	}
+
+	return nil
+}
+
+// Extend 手动续期，仅当redis中的值仍为本句柄写入的值时生效
+func (l *Lock) Extend(ctx context.Context) error {
+	ok, err := l.driver.extendValue(ctx, l.key, l.value, l.lo)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotHeld
+	}
+
+	return nil
+}
+
+// Relock 将锁的TTL原子地调整为newTTL(按值比较后设置过期时间)，锁未被本句柄持有时返回ErrNotHeld
+//
+// 用于分阶段的任务在不释放锁的前提下收紧或放宽租期。之后的自动续期和Extend均使用newTTL，
+// 续期间隔按newTTL与默认TTL的比例缩放(默认TTL 10s、间隔1s时，newTTL为2s则每200ms续期一次)。
+func (l *Lock) Relock(ctx context.Context, newTTL time.Duration) error {
+	return l.driver.relock(ctx, l.key, l.value, l.lo, newTTL)
+}
+
+// RenewTicks 每次自动续期后收到续期是否成功，未及时接收的结果会被丢弃
+//
+// 可用于在测试中等待一次续期发生，代替sleep。重建的句柄(Restore)不会收到通知。
+func (l *Lock) RenewTicks() <-chan bool {
+	return l.ticks
+}
+
+func newLockState(c redisLib.UniversalClient, key string, rkey string, value string) *lockState {
+	st := &lockState{
+		client:     c,
+		key:        key,
+		rkey:       rkey,
+		value:      value,
+		cancel:     make(chan struct{}),
+		extended:   make(chan struct{}, 1),
+		lost:       make(chan error, 1),
+		gone:       make(chan struct{}),
+		ticks:      make(chan bool, 1),
+		acquiredAt: time.Now(),
+	}
+	st.lastRenewed.Store(st.acquiredAt.UnixNano())
+
+	return st
+}
+
+// 本地持有的锁的状态
+type lockState struct {
+	client redisLib.UniversalClient
+	key    string
+	rkey   string
+	value  string
+	cancel chan struct{}
+	once   sync.Once
+
+	//最近一次手动续期的时间(UnixNano)，自动续期据此跳过冗余的续期
+	lastExtended atomic.Int64
+	extended     chan struct{}
+	//最近一次自动续期成功的时间(UnixNano)，获取锁时为获取的时间
+	lastRenewed atomic.Int64
+	//每次自动续期的结果，无人接收时丢弃
+	ticks chan bool
+
+	//续期与修改TTL互斥，避免续期使用旧的TTL覆盖Relock设置的TTL
+	mux sync.Mutex
+	//Relock设置的TTL，为0时使用默认TTL
+	ttl atomic.Int64
+
+	lost chan error
+	//续期结束(锁丢失或到达截止时间)时关闭，goneReason为结束的原因，见Lock.Wait
+	gone       chan struct{}
+	goneReason error
+
+	//离开本地排队(WithLocalGate)，释放或丢失锁时调用
+	ungate func()
+	//关联数据的键(AcquireWithData)，续期时一并续期
+	dataKey string
+	//自动续期已因锁丢失等原因结束
+	ended atomic.Bool
+	//续期已放弃并宣告锁丢失，此后的Unlock不再删除键，返回ErrAlreadyLost
+	lostFlag atomic.Bool
+	//WithRenewalHeadroom的倍数，续期间隔为TTL除以该倍数
+	headroom int
+	//获取的时间，以及WithAcquireBacktrace记录的获取时的堆栈
+	acquiredAt time.Time
+	stack      []byte
+	//WithPublishEvents的频道，释放及丢失时发布事件
+	eventChannel string
+}
+
+// 记录一次手动续期，并通知续期协程重置计时
+func (st *lockState) touch() {
+	now := time.Now().UnixNano()
+	st.lastExtended.Store(now)
+	st.lastRenewed.Store(now)
+	select {
+	case st.extended <- struct{}{}:
+	default:
+	}
+}
+
+// 通知一次自动续期的结果，不会阻塞续期
+func (st *lockState) tick(success bool) {
+	if hooks.OnRenewTick != nil {
+		hooks.OnRenewTick(st.key, success)
+	}
+	select {
+	case st.ticks <- success:
+	default:
+	}
+}
+
+// 续期使用的TTL，Relock设置过时以设置的值为准
+func (st *lockState) leaseTTL(lo *lockOptions) time.Duration {
+	if ttl := st.ttl.Load(); ttl > 0 {
+		return time.Duration(ttl)
+	}
+	return lo.renewalTTL()
+}
+
+// 最短的自动续期间隔
+const minRenewalInterval = 10 * time.Millisecond
+
+// 使用WithRenewalHeadroom时记录其TTL及倍数，须在开始续期前调用
+func (st *lockState) applyHeadroom(lo *lockOptions) {
+	if lo.headroomFactor > 0 {
+		st.ttl.Store(int64(lo.initialTTL()))
+		st.headroom = lo.headroomFactor
+	}
+}
+
+// 自动续期间隔，Relock设置过TTL时按新TTL与默认TTL的比例缩放，使用WithRenewalHeadroom时为TTL除以其倍数
+func (st *lockState) interval() time.Duration {
+	ttl := st.ttl.Load()
+	if ttl <= 0 {
+		return renewalCheckInterval
+	}
+
+	var d time.Duration
+	if st.headroom > 0 {
+		d = time.Duration(ttl) / time.Duration(st.headroom)
+	} else {
+		d = time.Duration(float64(renewalCheckInterval) * float64(ttl) / float64(lockTTL))
+	}
+	if d < minRenewalInterval {
+		d = minRenewalInterval
+	}
+	return d
+}
+
+// 记录续期结束的原因并唤醒Lock.Wait，只调用一次
+func (st *lockState) finish(reason error) {
+	st.goneReason = reason
+	close(st.gone)
+}
+
+// 停止自动续期
+func (st *lockState) stop() {
+	st.once.Do(func() {
+		close(st.cancel)
+	})
+	st.leaveGate()
+}
+
+// 离开本地排队，未使用时为空操作
+func (st *lockState) leaveGate() {
+	if st.ungate != nil {
+		st.ungate()
+	}
+}
+
+func (rd *redisDriver) Acquire(ctx context.Context, key string, opts ...LockOption) (*Lock, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	lo := newLockOptions(opts)
+	c, err := rd.clientFor(lo)
+	if err != nil {
+		return nil, err
+	}
+	if err = rd.checkVersion(ctx, lo); err != nil {
+		return nil, err
+	}
+
+	done, ok := rd.begin()
+	if !ok {
+		return nil, ErrClosed
+	}
+	defer done()
+
+	ctx, cancel := withTimeout(ctx, lo.timeout)
+	defer cancel()
+
+	if lo.acquireTimeout > 0 {
+		cwt, cancel := context.WithTimeout(ctx, lo.acquireTimeout)
+		defer cancel()
+		ctx = cwt
+	}
+	ctx = withAcquiring(ctx)
+
+	if err = rd.admitRenewal(); err != nil {
+		return nil, err
+	}
+
+	rkey := redisKey(key)
+
+	if selfContention != SelfContentionFail {
+		if st, ok := rd.states.load(rd.stateKey(lo, rkey)); ok && !st.ended.Load() {
+			if selfContention == SelfContentionError {
+				return nil, ErrAlreadyHeldBySelf
+			}
+			stored, _ := parseLockerValue(st.value)
+			stored.Key = rkey
+			stored.Token = valueToken(st.value)
+			return &Lock{driver: rd, key: key, token: stored.Token, value: st.value, lost: st.lost, ticks: st.ticks, lo: lo, info: stored, st: st}, nil
+		}
+	}
+
+	//本地排队，获取失败时离开，成功后由锁的状态在释放或丢失时离开
+	var leave func()
+	if lo.localGate && lo.ungate == nil {
+		if leave, err = rd.gates.enter(ctx, rd.stateKey(lo, rkey), false); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if leave != nil {
+				leave()
+			}
+		}()
+	}
+
+	token := lo.token
+	if token == "" {
+		token = newToken()
+	}
+	info := newLockInfo(token)
+	info.Term = lo.term
+	info.Reason = lo.reason
+	info.Labels = ownerLabels(ctx)
+	value, token, err := provideValue(ctx, key, info)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case lo.incr != nil:
+		ok, err = setNXIncr(ctx, c, rkey, value, lo.incr, lo.initialTTL())
+	case lo.steal != nil:
+		ok, err = rd.setNXStealStale(ctx, c, rkey, value, lo.steal, lo.initialTTL())
+	case lo.cond != nil:
+		ok, err = setNXIf(ctx, c, rkey, value, lo.cond, lo.initialTTL())
+	case lo.term > 0:
+		ok, err = rd.setTerm(ctx, c, rkey, value, lo.term, lo.initialTTL())
+	case lo.hierarchy:
+		ok, err = rd.setNXHierarchy(ctx, c, key, value, lo)
+	case lo.waitReplicas > 0:
+		ok, err = rd.setNXWait(ctx, rkey, value, lo)
+	case legacyRedisKey(lo, key) != "":
+		ok, err = setNXLegacy(ctx, c, rkey, legacyRedisKey(lo, key), value, lo.initialTTL())
+	default:
+		ok, err = setNXResult(setNX(ctx, c, rkey, value, lo.initialTTL()))
+	}
+	if err != nil {
+		if lo.acquireTimeout > 0 && isTimeout(err) {
+			return nil, ErrTimeout
+		}
+		return nil, err
+	}
+	if !ok {
+		if lo.typeCheck {
+			if err = checkKeyType(ctx, c, rkey); err != nil {
+				return nil, err
+			}
+		}
+		rd.contention.record(key, time.Now())
+		if lo.trackWaiters {
+			_ = registerProcess(ctx, c, waitersKey(key), waiterTTL)
+		}
+		return nil, ErrNotAcquired
+	}
+	if lo.trackWaiters {
+		_ = c.HDel(ctx, waitersKey(key), processFingerprint()).Err()
+	}
+
+	if lo.confirmRenewal {
+		if err = rd.confirmRenewal(ctx, c, rkey, value, lo.initialTTL()); err != nil {
+			return nil, err
+		}
+	}
+
+	registerOwner(ctx, c)
+	lockedHook(key, value)
+
+	stored, _ := parseLockerValue(value)
+	stored.Key = rkey
+	stored.Token = token
+	stored.TTL = lo.initialTTL()
+	l := &Lock{driver: rd, key: key, token: token, value: value, lo: lo, info: stored}
+	publishEvent(ctx, c, lo.eventChannel, LockEvent{Type: EventAcquired, Key: key, Owner: stored})
+	if renewalMode == RenewalNone {
+		return l, nil
+	}
+
+	st := newLockState(c, key, rkey, value)
+	st.applyHeadroom(lo)
+	st.captureStack(lo)
+	st.eventChannel = lo.eventChannel
+	if st.ungate = lo.ungate; st.ungate == nil {
+		st.ungate, leave = leave, nil
+	}
+	rd.hold(st, lo)
+	if lo.detectDeadlock {
+		rd.order.acquired(key)
+	}
+	l.lost, l.ticks, l.st = st.lost, st.ticks, st
+
+	return l, nil
+}
+
+// 记录本地持有的锁并启动自动续期
+func (rd *redisDriver) hold(st *lockState, lo *lockOptions) {
+	if prev, ok := rd.states.store(rd.stateKey(lo, st.rkey), st); ok {
+		prev.stop()
+	}
+
+	//自动续期
+	rd.renewals.Add(1)
+	if pool := rd.renewalPool(); pool != nil {
+		pool.add(st, lo)
+		return
+	}
+	go func() {
+		defer rd.renewals.Done()
+		rd.renewal(st, lo)
+	}()
+}
+
+// Restore 根据之前保存的Lock.Key()和Lock.Value()重建锁的句柄
+//
+// 用于进程重启等本地状态丢失后释放或续期锁：句柄的Unlock和Extend仅依赖key和value按值比较，
+// 不需要本地状态。重建的句柄不会自动续期，Lost也不会收到通知。
+func (rd *redisDriver) Restore(key string, value string, opts ...LockOption) *Lock {
+	info, _ := parseLockerValue(value)
+	info.Key = redisKey(key)
+	info.Token = valueToken(value)
+	return &Lock{driver: rd, key: key, token: info.Token, value: value, lo: newLockOptions(opts), info: info}
+}
+
+// 按值比较后释放锁，不依赖本地状态
+func (rd *redisDriver) unlockValue(ctx context.Context, key string, value string, lo *lockOptions) (bool, error) {
+	c, err := rd.clientFor(lo)
+	if err != nil {
+		return false, err
+	}
+
+	if done, ok := rd.begin(); ok {
+		defer done()
+	}
+
+	ctx, cancel := withTimeout(ctx, lo.timeout)
+	defer cancel()
+
+	rkey := redisKey(key)
+	if st, ok := rd.states.removeIf(rd.stateKey(lo, rkey), value); ok {
+		st.stop()
+		if st.lostFlag.Load() {
+			return false, ErrAlreadyLost
+		}
+		rd.order.released(key)
+	}
+
+	ok, err := rd.compareAndDelete(ctx, c, rkey, value)
+	if legacy := legacyRedisKey(lo, key); legacy != "" && err == nil {
+		legacyOK, legacyErr := rd.unlockLegacy(ctx, c, legacy, value)
+		ok, err = ok || legacyOK, legacyErr
+	}
+	if ok && err == nil {
+		rd.notifyUnlock(ctx, c, rkey)
+		publishEvent(ctx, c, lo.eventChannel, LockEvent{Type: EventReleased, Key: key, Owner: eventOwner(value)})
+	}
+	if !ok && err == nil {
+		checkOwnership(ctx, c, key, rkey, value)
+	}
+	releasedHook(key, value, ok && err == nil)
+
+	return ok, err
+}
+
+func (rd *redisDriver) Extend(ctx context.Context, key string, opts ...LockOption) bool {
+	if validateKey(key) != nil {
+		return false
+	}
+
+	lo := newLockOptions(opts)
+	st, ok := rd.states.load(rd.stateKey(lo, redisKey(key)))
+	if !ok {
+		return false
+	}
+
+	ok, err := rd.extendValue(ctx, key, st.value, lo)
+	return ok && err == nil
+}
+
+// 按值比较后续期，本地持有时使用Relock设置的TTL
+func (rd *redisDriver) extendValue(ctx context.Context, key string, value string, lo *lockOptions) (bool, error) {
+	c, err := rd.clientFor(lo)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := withTimeout(ctx, lo.timeout)
+	defer cancel()
+
+	rkey := redisKey(key)
+	st, held := rd.states.load(rd.stateKey(lo, rkey))
+	if !held || st.value != value {
+		ok, err := rd.compareAndExpire(ctx, c, rkey, value, lockTTL)
+		if err == nil && !ok {
+			checkOwnership(ctx, c, key, rkey, value)
+		}
+		return ok, err
+	}
+
+	st.mux.Lock()
+	ttl := lockTTL
+	if custom := st.ttl.Load(); custom > 0 {
+		ttl = time.Duration(custom)
+	}
+	ok, err := rd.expireState(ctx, st, ttl)
+	st.mux.Unlock()
+	if err != nil || !ok {
+		if err == nil {
+			checkOwnership(ctx, c, key, rkey, value)
+		}
+		return ok, err
+	}
+
+	st.touch()
+	return true, nil
+}
+
+// 按值比较后调整锁的TTL，并切换本地续期使用的TTL和间隔
+func (rd *redisDriver) relock(ctx context.Context, key string, value string, lo *lockOptions, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("corgi: invalid ttl %s", ttl)
+	}
+	c, err := rd.clientFor(lo)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	rkey := redisKey(key)
+	st, held := rd.states.load(rd.stateKey(lo, rkey))
+	if held && st.value != value {
+		held = false
+	}
+
+	var ok bool
+	if held {
+		st.mux.Lock()
+		ok, err = rd.expireState(ctx, st, ttl)
+	} else {
+		ok, err = rd.compareAndExpire(ctx, c, rkey, value, ttl)
+	}
+	if held {
+		if ok && err == nil {
+			st.ttl.Store(int64(ttl))
+		}
+		st.mux.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotHeld
+	}
+
+	if held {
+		st.touch()
+		if pool := rd.renewalPool(); pool != nil {
+			pool.reschedule(st)
+		}
+	}
+	return nil
+}
+
+// 同步执行一次续期以确认锁可以被续期，失败时回滚已获取的锁
+func (rd *redisDriver) confirmRenewal(ctx context.Context, c redisLib.UniversalClient, rkey string, value string, ttl time.Duration) error {
+	ok, err := rd.compareAndExpire(ctx, c, rkey, value, ttl)
+	if err == nil && ok {
+		return nil
+	}
+
+	rollbackCtx, cancel := context.WithTimeout(context.Background(), redisExecuteTimeout)
+	defer cancel()
+	_, _ = rd.compareAndDelete(rollbackCtx, c, rkey, value)
+
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRenewalFailed, err)
+	}
+
+	return ErrRenewalFailed
+}
+
+// SafeExtend 按token续期，仅当剩余TTL不低于floor时才将TTL设置为newTTL
+//
+// 检查值和剩余TTL与续期在同一个脚本中原子执行。剩余TTL已低于下限时不再续期，
+// 返回包装了ErrNotHeld的错误，并停止本进程对该锁的自动续期：此时锁随时可能过期并被他人获取，
+// 续期可能延长一个即将易主的锁。用于在GC停顿、调度延迟等导致续期迟到时收紧正确性。
+func (rd *redisDriver) SafeExtend(ctx context.Context, key string, token string, floor time.Duration, newTTL time.Duration) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	lo := newLockOptions(nil)
+	c, err := rd.clientFor(lo)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	//按token找到完整的值，脚本按完整的值比较，读取后值被修改时不会误续期
+	rkey := redisKey(key)
+	value := ""
+	if st, ok := rd.states.load(rd.stateKey(lo, rkey)); ok {
+		if valueToken(st.value) == token {
+			value = st.value
+		}
+	}
+	if value == "" {
+		stored, err := c.Get(ctx, rkey).Result()
+		if err == redisLib.Nil {
+			return ErrNotHeld
+		}
+		if err != nil {
+			return err
+		}
+		if valueToken(stored) != token {
+			return ErrNotHeld
+		}
+		value = stored
+	}
+
+	res, err := safeExtendScript.Run(ctx, c, []string{rkey}, value, floor.Milliseconds(), newTTL.Milliseconds()).Int64Slice()
+	if err != nil {
+		return err
+	}
+	switch res[0] {
+	case 1:
+		if st, ok := rd.states.load(rd.stateKey(lo, rkey)); ok && st.value == value {
+			st.touch()
+		}
+		return nil
+	case 0:
+		if st, ok := rd.states.removeIf(rd.stateKey(lo, rkey), value); ok {
+			st.stop()
+			rd.order.released(key)
+		}
+		return fmt.Errorf("%w: remaining ttl %s is below floor %s", ErrNotHeld, time.Duration(res[1])*time.Millisecond, floor)
+	default:
+		return ErrNotHeld
+	}
+}
@@ -0,0 +1,2165 @@
+package corgi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+func newTestDriver(t testing.TB) (*redisDriver, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redisLib.NewClient(&redisLib.Options{Addr: mr.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	rd := newDriver()
+	rd.client = client
+	t.Cleanup(func() {
+		//停止残留的续期，避免与后续测试修改的全局配置产生竞争
+		for _, st := range rd.states.drain() {
+			st.stop()
+		}
+		rd.renewals.Wait()
+		if rd.pool != nil {
+			rd.pool.stop()
+		}
+		_ = rd.closeDBClients()
+	})
+
+	return rd, mr
+}
+
+func TestAcquire(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	l, err := rd.Acquire(ctx, "acquire", WithToken("token-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := mr.Get("acquire"); stored != l.Value() {
+		t.Fatalf("expected stored value %q, got %q", l.Value(), stored)
+	}
+	if info, ok := parseLockerValue(l.Value()); !ok || info.Token != "token-1" {
+		t.Fatalf("unexpected stored value %q", l.Value())
+	}
+
+	if _, err = rd.Acquire(ctx, "acquire"); err != ErrNotAcquired {
+		t.Fatalf("expected ErrNotAcquired, got %v", err)
+	}
+
+	if err = l.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists("acquire") {
+		t.Fatal("expected key to be deleted")
+	}
+	if err = l.Unlock(ctx); err != ErrNotHeld {
+		t.Fatalf("expected ErrNotHeld, got %v", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"shutdown:1", "shutdown:2"} {
+		if _, err := rd.Acquire(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rd.shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Fatalf("expected held locks to be released, got %v", keys)
+	}
+	if _, err := rd.Acquire(ctx, "shutdown:3"); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestShutdownBatchedRelease(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	n := 2*shutdownReleaseBatch + 10
+	for i := 0; i < n; i++ {
+		if _, err := rd.Acquire(ctx, fmt.Sprintf("batch:%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//被其他持有者覆盖的锁不删除，也不视为未释放
+	_ = mr.Set(redisKey("batch:0"), "other")
+
+	if err := rd.shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if keys := mr.Keys(); len(keys) != 1 {
+		t.Fatalf("expected only the overwritten lock to remain, got %d keys", len(keys))
+	}
+}
+
+func TestShutdownReleaseDeadline(t *testing.T) {
+	rd, mr := newTestDriver(t)
+
+	for i := 0; i < 3; i++ {
+		if _, err := rd.Acquire(context.Background(), fmt.Sprintf("deadline:%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := rd.shutdown(ctx)
+	var relErr *ReleaseError
+	if !errors.As(err, &relErr) || len(relErr.Keys) != 3 || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected ReleaseError listing 3 keys, got %v", err)
+	}
+	if keys := mr.Keys(); len(keys) != 3 {
+		t.Fatalf("expected unreleased locks to be left to expire, got %v", keys)
+	}
+}
+
+func TestUnlockWithoutScripting(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	rd.noScripting.Store(true)
+	ctx := context.Background()
+
+	l, err := rd.Acquire(ctx, "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := rd.compareAndDelete(ctx, rd.cmdable(), "watch", "other"); ok || err != nil {
+		t.Fatalf("expected mismatched value to be kept, got %v %v", ok, err)
+	}
+	if err = l.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists("watch") {
+		t.Fatal("expected key to be deleted")
+	}
+}
+
+func TestTopology(t *testing.T) {
+	if mode := newDriver().topology().Mode; mode != TopologyUnconfigured {
+		t.Fatalf("expected unconfigured, got %s", mode)
+	}
+
+	rd, mr := newTestDriver(t)
+	if info := rd.topology(); info.Mode != TopologyStandalone || info.String() != "standalone("+mr.Addr()+")" {
+		t.Fatalf("unexpected topology %s", info)
+	}
+}
+
+func TestExtend(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	l, err := rd.Acquire(ctx, "extend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(lockTTL / 2)
+
+	if !rd.Extend(ctx, "extend") {
+		t.Fatal("expected extend to succeed")
+	}
+	if ttl := mr.TTL("extend"); ttl != lockTTL {
+		t.Fatalf("expected ttl to be reset to %s, got %s", lockTTL, ttl)
+	}
+
+	_ = l.Unlock(ctx)
+	if rd.Extend(ctx, "extend") {
+		t.Fatal("expected extend of a released lock to fail")
+	}
+	if err = l.Extend(ctx); err != ErrNotHeld {
+		t.Fatalf("expected ErrNotHeld, got %v", err)
+	}
+}
+
+func TestAcquireWithHierarchy(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	parent, err := rd.Acquire(ctx, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//本进程持有父级时可以对子级加锁
+	child, err := rd.Acquire(ctx, "dir:file", WithHierarchy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = child.Unlock(ctx)
+
+	//父级被其他持有者占用
+	_ = parent.Unlock(ctx)
+	_ = mr.Set("dir", "someone-else")
+	if _, err = rd.Acquire(ctx, "dir:file", WithHierarchy()); err != ErrNotAcquired {
+		t.Fatalf("expected ErrNotAcquired, got %v", err)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	if _, err := rd.Acquire(ctx, "inspect:1", WithToken("t1")); err != nil {
+		t.Fatal(err)
+	}
+	_ = mr.Set("inspect:other", "not a lock")
+
+	infos, err := rd.inspect(ctx, "inspect:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Key != "inspect:1" || infos[0].Token != "t1" || infos[0].TTL <= 0 {
+		t.Fatalf("unexpected infos %+v", infos)
+	}
+}
+
+func TestLostReason(t *testing.T) {
+	rd, _ := newTestDriver(t)
+
+	renewalCtx, cancel := context.WithCancel(context.Background())
+	l, err := rd.Acquire(context.Background(), "lost", WithRenewalContext(renewalCtx))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	if reason := <-l.Lost(); reason != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", reason)
+	}
+}
+
+func TestMaxLifetime(t *testing.T) {
+	withRenewalInterval(t, 10*time.Millisecond)
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	l, err := rd.Acquire(ctx, "forgotten", WithMaxLifetime(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case reason := <-l.Lost():
+		if reason != ErrMaxLifetime {
+			t.Fatalf("expected ErrMaxLifetime, got %v", reason)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the lock to be lost after max lifetime")
+	}
+
+	//停止续期，锁随TTL过期而不是被删除
+	before := mr.CommandCount()
+	time.Sleep(50 * time.Millisecond)
+	if n := mr.CommandCount() - before; n != 0 {
+		t.Fatalf("expected renewal to stop, got %d commands", n)
+	}
+	if !mr.Exists(redisKey("forgotten")) {
+		t.Fatal("expected the key to be left to expire")
+	}
+}
+
+func TestAutoRelease(t *testing.T) {
+	withRenewalInterval(t, 10*time.Millisecond)
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	released := make(chan bool, 1)
+	SetHooks(Hooks{OnReleased: func(key string, _ LockInfo, ok bool) { released <- ok }})
+	defer SetHooks(Hooks{})
+	waitReleased := func(key string) bool {
+		t.Helper()
+		select {
+		case ok := <-released:
+			return ok
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected %s to be auto released", key)
+			return false
+		}
+	}
+
+	//超过最长持有时间后立即删除
+	if _, err := rd.Acquire(ctx, "auto:lifetime", WithAutoRelease(), WithMaxLifetime(30*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if !waitReleased("auto:lifetime") || mr.Exists(redisKey("auto:lifetime")) {
+		t.Fatal("expected the key to be deleted after max lifetime")
+	}
+
+	//续期context结束后立即删除
+	renewalCtx, cancel := context.WithCancel(ctx)
+	if _, err := rd.Acquire(ctx, "auto:ctx", WithAutoRelease(), WithRenewalContext(renewalCtx)); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if !waitReleased("auto:ctx") || mr.Exists(redisKey("auto:ctx")) {
+		t.Fatal("expected the key to be deleted after the renewal context ended")
+	}
+
+	//已被其他持有者覆盖时不删除
+	renewalCtx, cancel = context.WithCancel(ctx)
+	l, err := rd.Acquire(ctx, "auto:taken", WithAutoRelease(), WithRenewalContext(renewalCtx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = mr.Set(redisKey("auto:taken"), "other")
+	cancel()
+	<-l.Lost()
+	if waitReleased("auto:taken") {
+		t.Fatal("expected auto release to skip the other owner's lock")
+	}
+	if v, _ := mr.Get(redisKey("auto:taken")); v != "other" {
+		t.Fatalf("expected the other owner's lock to remain, got %q", v)
+	}
+}
+
+func TestLockWait(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	renewalCtx, cancel := context.WithCancel(ctx)
+	l, err := rd.Acquire(ctx, "leader", WithRenewalContext(renewalCtx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wctx, wcancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer wcancel()
+	if err = l.Wait(wctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected wait to end with ctx, got %v", err)
+	}
+
+	go cancel()
+	if err = l.Wait(ctx); err != context.Canceled {
+		t.Fatalf("expected loss reason, got %v", err)
+	}
+	//不消耗Lost中的通知
+	if reason := <-l.Lost(); reason != context.Canceled {
+		t.Fatalf("expected Lost to still receive the reason, got %v", reason)
+	}
+
+	l, err = rd.Acquire(ctx, "released")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlocked := make(chan struct{})
+	go func() {
+		defer close(unlocked)
+		_ = l.Unlock(ctx)
+	}()
+	if err = l.Wait(ctx); err != ErrNotHeld {
+		t.Fatalf("expected ErrNotHeld after release, got %v", err)
+	}
+	<-unlocked
+}
+
+func TestUnlockAfterLost(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	lose := func(key string) *Lock {
+		renewalCtx, cancel := context.WithCancel(ctx)
+		l, err := rd.Acquire(ctx, key, WithRenewalContext(renewalCtx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		<-l.Lost()
+		return l
+	}
+
+	//续期已宣告丢失，不再删除键
+	lose("lost-key")
+	if res, _, err := rd.UnlockE(ctx, "lost-key"); err != ErrAlreadyLost || res != UnlockFailed {
+		t.Fatalf("expected ErrAlreadyLost, got %v %v", res, err)
+	}
+	if !mr.Exists(redisKey("lost-key")) {
+		t.Fatal("expected lost lock to be left to expire")
+	}
+	if n := rd.states.count(); n != 0 {
+		t.Fatalf("expected lost state to be removed, got %d", n)
+	}
+
+	l := lose("lost-handle")
+	if err := l.Unlock(ctx); err != ErrAlreadyLost {
+		t.Fatalf("expected ErrAlreadyLost, got %v", err)
+	}
+	if !mr.Exists(redisKey("lost-handle")) {
+		t.Fatal("expected lost lock to be left to expire")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	if err := Register("orders", &redisLib.Options{Addr: mr.Addr()}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = Unregister(context.Background(), "orders") })
+	if err := Register("orders", &redisLib.Options{Addr: mr.Addr()}); err == nil {
+		t.Fatal("expected duplicated registration to fail")
+	}
+
+	l, err := Get("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.TryLock(context.Background(), "registered") {
+		t.Fatal("expected registered locker to acquire")
+	}
+	l.Unlock(context.Background(), "registered")
+
+	if _, err = Get("missing"); !errors.Is(err, ErrLockerNotFound) {
+		t.Fatalf("expected ErrLockerNotFound, got %v", err)
+	}
+
+	if err = Unregister(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Get("orders"); !errors.Is(err, ErrLockerNotFound) {
+		t.Fatalf("expected ErrLockerNotFound after unregister, got %v", err)
+	}
+}
+
+func TestUnlockRestoredLock(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	l, err := rd.Acquire(ctx, "restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, value := l.Key(), l.Value()
+
+	//模拟进程重启：新的实例没有任何本地状态
+	restarted := newDriver()
+	restarted.client = rd.client
+	if _, ok := restarted.states.load(redisKey(key)); ok {
+		t.Fatal("expected no local state")
+	}
+
+	restored := restarted.Restore(key, value)
+	if restored.Token() != l.Token() {
+		t.Fatalf("expected token %q, got %q", l.Token(), restored.Token())
+	}
+	if err = restored.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists("restore") {
+		t.Fatal("expected key to be deleted")
+	}
+}
+
+func TestLockWaits(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	var waited time.Duration
+	SetHooks(Hooks{OnAcquired: func(key string, d time.Duration) { waited = d }})
+	defer SetHooks(Hooks{})
+
+	held, err := rd.Acquire(ctx, "blocking")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//恢复钩子之前等待释放的协程结束
+	unlocked := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() {
+		defer close(unlocked)
+		_ = held.Unlock(ctx)
+	})
+	defer func() { <-unlocked }()
+
+	l, err := rd.Lock(ctx, "blocking", WithRetryInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Unlock(ctx)
+	if waited < 50*time.Millisecond {
+		t.Fatalf("expected to wait at least 50ms, waited %s", waited)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 30*time.Millisecond)
+	defer cancel()
+	if _, err = rd.Lock(timeoutCtx, "blocking"); err != ErrLockWaitTimeout {
+		t.Fatalf("expected ErrLockWaitTimeout, got %v", err)
+	}
+}
+
+func TestLockFailsFastOnPermanentError(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	//miniredis无法获取版本，版本要求永远无法满足，不应等到ctx结束
+	start := time.Now()
+	if _, err := rd.Lock(ctx, "permanent", WithMinServerVersion("6.2"), WithRetryInterval(10*time.Millisecond)); !errors.Is(err, ErrServerVersion) {
+		t.Fatalf("expected ErrServerVersion, got %v", err)
+	}
+	if _, err := rd.LockFair(ctx, "permanent", WithMinServerVersion("6.2"), WithRetryInterval(10*time.Millisecond)); !errors.Is(err, ErrServerVersion) {
+		t.Fatalf("expected ErrServerVersion from LockFair, got %v", err)
+	}
+
+	cluster := newDriver()
+	cluster.clusterClient = redisLib.NewClusterClient(&redisLib.ClusterOptions{Addrs: []string{rd.client.Options().Addr}})
+	defer cluster.clusterClient.Close()
+	if _, err := cluster.Lock(ctx, "permanent", WithDB(1), WithRetryInterval(10*time.Millisecond)); err != ErrDBNotSupported {
+		t.Fatalf("expected ErrDBNotSupported, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected permanent errors to return at once, took %s", elapsed)
+	}
+}
+
+func TestReplicationWait(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+	rd.version.Store("7.0.11")
+
+	//miniredis不支持WAIT，由注册的命令返回已同步的副本数
+	var acked int64
+	if err := mr.Server().Register("WAIT", func(c *server.Peer, cmd string, args []string) {
+		c.WriteInt(int(atomic.LoadInt64(&acked)))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := newDriver()
+	cluster.version.Store("7.0.11")
+	cluster.clusterClient = redisLib.NewClusterClient(&redisLib.ClusterOptions{Addrs: []string{mr.Addr()}})
+	defer cluster.clusterClient.Close()
+
+	//ASK重定向后的回滚无法在miniredis中模拟
+	for name, d := range map[string]*redisDriver{"standalone": rd, "cluster": cluster} {
+		atomic.StoreInt64(&acked, 1)
+		if _, err := d.Acquire(ctx, "replicated", WithReplicationWait(2, 10*time.Millisecond)); err != ErrReplicationTimeout {
+			t.Fatalf("%s: expected ErrReplicationTimeout, got %v", name, err)
+		}
+		if mr.Exists(redisKey("replicated")) {
+			t.Fatalf("%s: expected the lock to be rolled back", name)
+		}
+
+		atomic.StoreInt64(&acked, 2)
+		l, err := d.Acquire(ctx, "replicated", WithReplicationWait(2, 10*time.Millisecond))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !mr.Exists(redisKey("replicated")) {
+			t.Fatalf("%s: expected the lock to be kept", name)
+		}
+		_ = l.Unlock(ctx)
+	}
+}
+
+func TestWaitCallback(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	held, err := rd.Acquire(ctx, "waiting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Unlock(ctx)
+
+	var attempts []int
+	timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = rd.Lock(timeoutCtx, "waiting", WithRetryInterval(20*time.Millisecond), WithWaitCallback(func(attempt int, elapsed time.Duration) {
+		attempts = append(attempts, attempt)
+		//回调耗时不应推迟下一次尝试
+		time.Sleep(15 * time.Millisecond)
+	}))
+	if err != ErrLockWaitTimeout {
+		t.Fatalf("expected ErrLockWaitTimeout, got %v", err)
+	}
+	if len(attempts) < 3 || attempts[0] != 1 || attempts[1] != 2 {
+		t.Fatalf("unexpected attempts %v", attempts)
+	}
+}
+
+func TestFlushHeldLocks(t *testing.T) {
+	if n := newDriver().flushHeldLocks(context.Background()); n != 0 {
+		t.Fatalf("expected unconfigured flush to be a no-op, got %d", n)
+	}
+
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+	if _, err := rd.TryLockSome(ctx, []string{"a", "b", "c"}); err != nil {
+		t.Fatal(err)
+	}
+	mr.Set(redisKey("c"), "other")
+
+	if n := rd.flushHeldLocks(ctx); n != 2 {
+		t.Fatalf("expected 2 flushed locks, got %d", n)
+	}
+	if rd.states.count() != 0 || mr.Exists(redisKey("a")) || !mr.Exists(redisKey("c")) {
+		t.Fatal("expected own locks to be deleted and others kept")
+	}
+}
+
+func TestTryLockUnconfigured(t *testing.T) {
+	rd := newDriver()
+	ctx := context.Background()
+	rec := &recordingLogger{}
+	prev := logger
+	SetLogger(rec)
+	defer SetLogger(prev)
+
+	if _, err := rd.TryLockE(ctx, "unconfigured"); err != ErrNotConfigured {
+		t.Fatalf("expected ErrNotConfigured, got %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if rd.TryLock(ctx, "unconfigured") {
+			t.Fatal("expected TryLock to fail without a provider")
+		}
+	}
+	if rec.count() != 1 || !strings.Contains(rec.lines[0], "redis provider") {
+		t.Fatalf("expected a single error log, got %v", rec.lines)
+	}
+}
+
+func TestOwners(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	if _, err := rd.TryLockSome(ctx, []string{"dash:a", "dash:b"}); err != nil {
+		t.Fatal(err)
+	}
+	mr.Set(redisKey("dash:foreign"), "not a corgi value")
+
+	owners, err := rd.Owners(ctx, []string{"dash:a", "dash:b", "dash:missing", "dash:foreign", "dash:a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owners) != 2 || owners["dash:a"].PID != os.Getpid() || owners["dash:b"].TTL <= 0 {
+		t.Fatalf("unexpected owners %+v", owners)
+	}
+}
+
+func TestLockFair(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	held, err := rd.Acquire(ctx, "fair")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mux       sync.Mutex
+		order     []string
+		positions = make(chan int, 16)
+		wg        sync.WaitGroup
+	)
+	wait := func(name string, opts ...LockOption) {
+		defer wg.Done()
+		l, err := rd.LockFair(ctx, "fair", append(opts, WithRetryInterval(10*time.Millisecond))...)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mux.Lock()
+		order = append(order, name)
+		mux.Unlock()
+		_ = l.Unlock(ctx)
+	}
+
+	wg.Add(1)
+	go wait("first")
+	time.Sleep(50 * time.Millisecond)
+	wg.Add(1)
+	go wait("second", WithQueuePosition(func(position int) { positions <- position }))
+	if p := <-positions; p != 2 {
+		t.Fatalf("expected to be second in line, got %d", p)
+	}
+
+	_ = held.Unlock(ctx)
+	wg.Wait()
+	if strings.Join(order, ",") != "first,second" {
+		t.Fatalf("expected arrival order, got %v", order)
+	}
+	if p := <-positions; p != 1 {
+		t.Fatalf("expected to move to the head of the line, got %d", p)
+	}
+	if mr.Exists(fairQueueKey("fair")) {
+		if members, _ := mr.ZMembers(fairQueueKey("fair")); len(members) != 0 {
+			t.Fatalf("expected waiters to leave the queue, got %v", members)
+		}
+	}
+	//排队的键不会与调用方"queue:<key>"上的锁冲突
+	if !rd.TryLock(ctx, "queue:fair") {
+		t.Fatal("expected plain lock to acquire")
+	}
+	fairCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	l, err := rd.LockFair(fairCtx, "fair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Unlock(ctx)
+}
+
+func TestIsLockedCache(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	hook := &countingHook{}
+	rd.client.AddHook(hook)
+	ctx := context.Background()
+	cached := WithIsLockedCache(rd, 50*time.Millisecond)
+
+	if !rd.TryLock(ctx, "hot") {
+		t.Fatal("expected lock")
+	}
+	before := hook.n.Load()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if locked, err := cached.IsLocked(ctx, "hot"); !locked || err != nil {
+				t.Errorf("expected locked, got %v %v", locked, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := hook.n.Load() - before; n != 1 {
+		t.Fatalf("expected one EXISTS for a burst of checks, got %d", n)
+	}
+
+	//缓存期内结果可能滞后，过期后重新查询
+	rd.Unlock(ctx, "hot")
+	if locked, _ := cached.IsLocked(ctx, "hot"); !locked {
+		t.Fatal("expected cached result within the ttl")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if locked, _ := cached.IsLocked(ctx, "hot"); locked {
+		t.Fatal("expected refreshed result after the ttl")
+	}
+}
+
+func TestLockUntil(t *testing.T) {
+	withRenewalInterval(t, 20*time.Millisecond)
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	if err := rd.LockUntil(ctx, "until", time.Now().Add(-time.Second)); err != ErrDeadlinePassed {
+		t.Fatalf("expected ErrDeadlinePassed, got %v", err)
+	}
+
+	deadline := time.Now().Add(150 * time.Millisecond)
+	l, err := rd.AcquireUntil(ctx, "until", deadline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL(redisKey("until")); ttl > 150*time.Millisecond {
+		t.Fatalf("expected ttl capped by the deadline, got %s", ttl)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if n := rd.states.count(); n != 0 {
+		t.Fatalf("expected renewal to stop at the deadline, got %d states", n)
+	}
+	select {
+	case reason := <-l.Lost():
+		t.Fatalf("expected no loss notification, got %v", reason)
+	default:
+	}
+
+	//提前释放
+	l, err = rd.AcquireUntil(ctx, "early", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Unlock(ctx); err != nil || mr.Exists(redisKey("early")) {
+		t.Fatalf("expected early release, got %v", err)
+	}
+}
+
+func TestCommandRateLimit(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	rd.client.AddHook(commandHook{})
+	ctx := context.Background()
+
+	SetCommandRateLimit(20, 0)
+	defer SetCommandRateLimit(0, 0)
+	if _, err := rd.Acquire(ctx, "limited:a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rd.Acquire(ctx, "limited:b"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected acquisition over the limit to fail fast, got %v", err)
+	}
+
+	//释放不会因限速失败，只会排队
+	start := time.Now()
+	if !rd.Unlock(ctx, "limited:a") {
+		t.Fatal("expected unlock to wait for its turn")
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("expected unlock to be queued, took %s", elapsed)
+	}
+}
+
+func TestNewLockerCommandRateLimit(t *testing.T) {
+	mr := miniredis.RunT(t)
+	l, err := NewLocker(&redisLib.Options{Addr: mr.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.(*redisDriver).shutdown(context.Background()) })
+	ctx := context.Background()
+
+	//独立实例及其按DB创建的客户端同样受限速约束
+	SetCommandRateLimit(20, 0)
+	defer SetCommandRateLimit(0, 0)
+	if _, err = l.Acquire(ctx, "limited:a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.Acquire(ctx, "limited:b"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected acquisition over the limit to fail fast, got %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err = l.Acquire(ctx, "limited:c", WithDB(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.Acquire(ctx, "limited:d", WithDB(1)); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected acquisition over the limit to fail fast, got %v", err)
+	}
+}
+
+func TestCommandStats(t *testing.T) {
+	stats := &commandStats{counters: make(map[string]*commandCounter)}
+	now := time.Now()
+	for i := 0; i < 30; i++ {
+		stats.record(now.Add(-time.Second), []redisLib.Cmder{redisLib.NewStringCmd(context.Background(), "get", "k")})
+	}
+	stats.record(now, []redisLib.Cmder{redisLib.NewStringCmd(context.Background(), "set", "k", "v")})
+	stats.record(now.Add(-time.Minute), []redisLib.Cmder{redisLib.NewStringCmd(context.Background(), "del", "k")})
+
+	rates := stats.rates(now)
+	if len(rates) != 1 || rates["get"] != 3 {
+		t.Fatalf("unexpected rates %v", rates)
+	}
+}
+
+func TestWaiterTracking(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	mr.Set(redisKey("hot"), "held elsewhere")
+	if rd.TryLock(ctx, "hot") {
+		t.Fatal("expected contention")
+	}
+	if waiters, _ := rd.Waiters(ctx, "hot"); len(waiters) != 0 {
+		t.Fatalf("expected no tracking by default, got %+v", waiters)
+	}
+
+	if rd.TryLock(ctx, "hot", WithWaiterTracking()) {
+		t.Fatal("expected contention")
+	}
+	waiters, err := rd.Waiters(ctx, "hot")
+	if err != nil || len(waiters) != 1 || waiters[0].PID != os.Getpid() {
+		t.Fatalf("unexpected waiters %+v %v", waiters, err)
+	}
+	if ttl := mr.TTL(waitersKey("hot")); ttl <= 0 || ttl > waiterTTL {
+		t.Fatalf("expected waiter set to self-expire, got ttl %s", ttl)
+	}
+
+	mr.Del(redisKey("hot"))
+	if !rd.TryLock(ctx, "hot", WithWaiterTracking()) {
+		t.Fatal("expected lock")
+	}
+	if waiters, _ = rd.Waiters(ctx, "hot"); len(waiters) != 0 {
+		t.Fatalf("expected waiter to be removed after acquiring, got %+v", waiters)
+	}
+	//登记的键不会与调用方"waiters:<key>"上的锁冲突
+	if !rd.TryLock(ctx, "waiters:cold") {
+		t.Fatal("expected plain lock to acquire")
+	}
+	mr.Set(redisKey("cold"), "held elsewhere")
+	_ = rd.TryLock(ctx, "cold", WithWaiterTracking())
+	if waiters, err = rd.Waiters(ctx, "cold"); err != nil || len(waiters) != 1 {
+		t.Fatalf("unexpected waiters %+v %v", waiters, err)
+	}
+}
+
+func TestTryLockWithTerm(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	if ok, err := rd.TryLockWithTerm(ctx, "leader", 2); !ok || err != nil {
+		t.Fatalf("expected term 2 to acquire, got %v %v", ok, err)
+	}
+
+	_, err := rd.TryLockWithTerm(ctx, "leader", 1)
+	var stale *StaleTermError
+	if !errors.As(err, &stale) || stale.Current != 2 {
+		t.Fatalf("expected stale term error with current term 2, got %v", err)
+	}
+
+	if ok, err := rd.TryLockWithTerm(ctx, "leader", 3); !ok || err != nil {
+		t.Fatalf("expected newer term to take over, got %v %v", ok, err)
+	}
+}
+
+func TestTryLockIf(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	if ok, err := rd.TryLockIf(ctx, "job", "job:status", "pending"); ok || err != ErrConditionNotMet {
+		t.Fatalf("expected missing condKey to fail the condition, got %v %v", ok, err)
+	}
+
+	_ = mr.Set("job:status", "done")
+	if ok, err := rd.TryLockIf(ctx, "job", "job:status", "pending"); ok || err != ErrConditionNotMet {
+		t.Fatalf("expected ErrConditionNotMet, got %v %v", ok, err)
+	}
+	if mr.Exists(redisKey("job")) {
+		t.Fatal("expected no lock when the condition fails")
+	}
+
+	_ = mr.Set("job:status", "pending")
+	if ok, err := rd.TryLockIf(ctx, "job", "job:status", "pending"); !ok || err != nil {
+		t.Fatalf("expected acquisition, got %v %v", ok, err)
+	}
+	if ok, err := rd.TryLockIf(ctx, "job", "job:status", "pending"); ok || err != nil {
+		t.Fatalf("expected contention to report false without error, got %v %v", ok, err)
+	}
+	if !rd.Unlock(ctx, "job") {
+		t.Fatal("expected unlock")
+	}
+}
+
+func TestTryLockStealStale(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	if res, err := rd.TryLockStealStale(ctx, "stale", time.Minute); res != StealAcquired || err != nil {
+		t.Fatalf("expected clean acquisition, got %v %v", res, err)
+	}
+
+	//持有者加锁不久，不接管
+	if res, err := rd.TryLockStealStale(ctx, "stale", time.Minute); res != StealNotAcquired || err != nil {
+		t.Fatalf("expected fresh lock not to be stolen, got %v %v", res, err)
+	}
+
+	info := newLockInfo("crashed")
+	info.LockedAt = time.Now().Add(-time.Hour)
+	_ = mr.Set(redisKey("stale"), encodeValue(info))
+	res, err := rd.TryLockStealStale(ctx, "stale", time.Minute)
+	if res != StealStolen || err != nil {
+		t.Fatalf("expected stale lock to be stolen, got %v %v", res, err)
+	}
+	owner, _ := rd.Owner(ctx, "stale")
+	if owner.Token == "crashed" {
+		t.Fatal("expected the stale owner to be replaced")
+	}
+}
+
+func TestTryLockAndIncr(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	ok, n, err := rd.TryLockAndIncr(ctx, "order:1", "processed")
+	if !ok || n != 1 || err != nil {
+		t.Fatalf("expected acquisition with count 1, got %v %d %v", ok, n, err)
+	}
+	//锁被占用时不计数
+	if ok, n, err = rd.TryLockAndIncr(ctx, "order:1", "processed"); ok || n != 0 || err != nil {
+		t.Fatalf("expected contention without counting, got %v %d %v", ok, n, err)
+	}
+	if v, _ := mr.Get("processed"); v != "1" {
+		t.Fatalf("expected counter to stay at 1, got %q", v)
+	}
+
+	if ok, n, _ = rd.TryLockAndIncr(ctx, "order:2", "processed"); !ok || n != 2 {
+		t.Fatalf("expected count 2, got %v %d", ok, n)
+	}
+}
+
+func TestOwnerWithReason(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	if _, err := rd.Acquire(ctx, "reason", WithReason("nightly-report-job")); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := rd.Owner(ctx, "reason")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Reason != "nightly-report-job" || info.Host == "" {
+		t.Fatalf("unexpected owner %+v", info)
+	}
+
+	if _, err = rd.Owner(ctx, "missing"); err != ErrNotHeld {
+		t.Fatalf("expected ErrNotHeld, got %v", err)
+	}
+}
+
+func TestTryLockSome(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	if err := mr.Set(redisKey("shard-2"), "other"); err != nil {
+		t.Fatal(err)
+	}
+
+	acquired, err := rd.TryLockSome(ctx, []string{"shard-1", "shard-2", "shard-3", "shard-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acquired) != 2 || acquired[0] != "shard-1" || acquired[1] != "shard-3" {
+		t.Fatalf("unexpected acquired keys %v", acquired)
+	}
+
+	for _, key := range acquired {
+		if !rd.Unlock(ctx, key) {
+			t.Fatalf("expected %q to be released", key)
+		}
+	}
+	if v, _ := mr.Get(redisKey("shard-2")); v != "other" {
+		t.Fatalf("expected foreign lock to be kept, got %q", v)
+	}
+}
+
+func TestUnlockMultiReverseOrder(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	var order []string
+	prev := hooks
+	SetHooks(Hooks{OnReleased: func(key string, _ LockInfo, ok bool) {
+		if ok {
+			order = append(order, key)
+		}
+	}})
+	defer SetHooks(prev)
+
+	acquired, err := rd.TryLockSome(ctx, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	released := rd.UnlockMulti(ctx, acquired)
+	if strings.Join(released, ",") != "c,b,a" || strings.Join(order, ",") != "c,b,a" {
+		t.Fatalf("expected release in reverse acquisition order, got %v (hooks %v)", released, order)
+	}
+}
+
+func TestLockWakesOnUnlockBroadcast(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	held, err := rd.Acquire(ctx, "broadcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//释放的协程在广播之后才调用钩子，测试结束前等待其结束
+	unlocked := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() {
+		defer close(unlocked)
+		_ = held.Unlock(ctx)
+	})
+	defer func() { <-unlocked }()
+
+	start := time.Now()
+	l, err := rd.Lock(ctx, "broadcast", WithRetryInterval(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Unlock(ctx)
+	if waited := time.Since(start); waited > time.Second {
+		t.Fatalf("expected to wake on unlock broadcast, waited %s", waited)
+	}
+}
+
+func TestAcquireWithDB(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	for _, db := range []int{1, 2} {
+		if _, err := rd.Acquire(ctx, "tenant", WithDB(db)); err != nil {
+			t.Fatalf("acquire in db %d: %v", db, err)
+		}
+	}
+	if !mr.DB(1).Exists(redisKey("tenant")) || !mr.DB(2).Exists(redisKey("tenant")) {
+		t.Fatal("expected locks in both databases")
+	}
+	if mr.Exists(redisKey("tenant")) {
+		t.Fatal("expected default database to be untouched")
+	}
+
+	if !rd.Unlock(ctx, "tenant", WithDB(1)) {
+		t.Fatal("expected unlock in db 1")
+	}
+	if mr.DB(1).Exists(redisKey("tenant")) || !mr.DB(2).Exists(redisKey("tenant")) {
+		t.Fatal("expected only db 1 lock to be released")
+	}
+
+	cluster := newDriver()
+	cluster.clusterClient = redisLib.NewClusterClient(&redisLib.ClusterOptions{Addrs: []string{mr.Addr()}})
+	defer cluster.clusterClient.Close()
+	if _, err := cluster.Acquire(ctx, "tenant", WithDB(1)); err != ErrDBNotSupported {
+		t.Fatalf("expected ErrDBNotSupported, got %v", err)
+	}
+}
+
+func TestDynamicTTL(t *testing.T) {
+	withRenewalInterval(t, 20*time.Millisecond)
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	var ttl atomic.Int64
+	ttl.Store(int64(time.Minute))
+	l, err := rd.Acquire(ctx, "dynamic", WithDynamicTTL(func() time.Duration { return time.Duration(ttl.Load()) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Unlock(ctx)
+
+	waitRenewTick(t, l)
+	if got := mr.TTL(redisKey("dynamic")); got != time.Minute {
+		t.Fatalf("expected ttl to follow callback, got %s", got)
+	}
+
+	//不大于续期间隔的TTL按续期间隔的2倍处理
+	ttl.Store(int64(time.Millisecond))
+	waitRenewTick(t, l)
+	waitRenewTick(t, l)
+	if got := mr.TTL(redisKey("dynamic")); got != 40*time.Millisecond {
+		t.Fatalf("expected ttl to be clamped, got %s", got)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	rd, mr := newTestDriver(t)
+
+	if err := rd.selfTest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Fatalf("expected no keys left behind, got %v", keys)
+	}
+}
+
+type countingHook struct {
+	n atomic.Int64
+}
+
+func (h *countingHook) BeforeProcess(ctx context.Context, _ redisLib.Cmder) (context.Context, error) {
+	h.n.Add(1)
+	return ctx, nil
+}
+
+func (h *countingHook) AfterProcess(context.Context, redisLib.Cmder) error { return nil }
+
+func (h *countingHook) BeforeProcessPipeline(ctx context.Context, _ []redisLib.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *countingHook) AfterProcessPipeline(context.Context, []redisLib.Cmder) error { return nil }
+
+func TestAddRedisHookBeforeSetup(t *testing.T) {
+	prev := redisHooks
+	defer func() { redisHooks = prev }()
+
+	hook := &countingHook{}
+	AddRedisHook(hook)
+
+	mr := miniredis.RunT(t)
+	client := redisLib.NewClient(&redisLib.Options{Addr: mr.Addr()})
+	defer client.Close()
+	applyRedisHooks(client)
+
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if hook.n.Load() != 1 {
+		t.Fatalf("expected pending hook to be installed, saw %d commands", hook.n.Load())
+	}
+}
+
+func TestSafeExtend(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	l, err := rd.Acquire(ctx, "safe")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = rd.SafeExtend(ctx, "safe", l.Token(), time.Second, 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL(redisKey("safe")); ttl != 30*time.Second {
+		t.Fatalf("expected ttl to be extended, got %s", ttl)
+	}
+
+	if err = rd.SafeExtend(ctx, "safe", "someone-else", time.Second, 30*time.Second); err != ErrNotHeld {
+		t.Fatalf("expected ErrNotHeld for foreign token, got %v", err)
+	}
+
+	mr.FastForward(29500 * time.Millisecond)
+	if err = rd.SafeExtend(ctx, "safe", l.Token(), time.Second, 30*time.Second); !errors.Is(err, ErrNotHeld) {
+		t.Fatalf("expected lock below floor to be reported lost, got %v", err)
+	}
+	if _, ok := rd.states.load(redisKey("safe")); ok {
+		t.Fatal("expected local renewal to stop")
+	}
+}
+
+// 等待一次成功的自动续期
+func waitRenewTick(t *testing.T, l *Lock) {
+	t.Helper()
+	select {
+	case ok := <-l.RenewTicks():
+		if !ok {
+			t.Fatal("expected renewal to succeed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for renewal")
+	}
+}
+
+func TestOnRenewTick(t *testing.T) {
+	withRenewalInterval(t, 10*time.Millisecond)
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	ticks := make(chan bool, 16)
+	SetHooks(Hooks{OnRenewTick: func(key string, success bool) {
+		select {
+		case ticks <- success:
+		default:
+		}
+	}})
+	defer SetHooks(Hooks{})
+
+	l, err := rd.Acquire(ctx, "ticks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Unlock(ctx)
+
+	select {
+	case ok := <-ticks:
+		if !ok {
+			t.Fatal("expected renewal to succeed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for renew tick hook")
+	}
+}
+
+type argsHook struct {
+	countingHook
+	mux  sync.Mutex
+	args [][]interface{}
+}
+
+func (h *argsHook) BeforeProcess(ctx context.Context, cmd redisLib.Cmder) (context.Context, error) {
+	h.mux.Lock()
+	h.args = append(h.args, cmd.Args())
+	h.mux.Unlock()
+	return ctx, nil
+}
+
+func TestAcquireUsesMillisecondTTL(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	hook := &argsHook{}
+	rd.client.AddHook(hook)
+	ctx := context.Background()
+
+	//通过单把锁的TTL选项设置，不修改续期协程会读取的全局TTL
+	for _, ttl := range []time.Duration{time.Second, 1500 * time.Millisecond} {
+		key := "ttl-" + ttl.String()
+		l, err := rd.Acquire(ctx, key, WithRenewalHeadroom(ttl/2, 2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := mr.TTL(redisKey(key)); got != ttl {
+			t.Fatalf("expected ttl %s, got %s", ttl, got)
+		}
+		_ = l.Unlock(ctx)
+	}
+
+	hook.mux.Lock()
+	defer hook.mux.Unlock()
+	for _, args := range hook.args {
+		if args[0] == "set" && args[3] != "px" {
+			t.Fatalf("expected SET with PX, got %v", args)
+		}
+	}
+}
+
+func TestUnlockIdempotent(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	if !rd.TryLock(ctx, "twice") {
+		t.Fatal("expected to acquire")
+	}
+	if !rd.Unlock(ctx, "twice") {
+		t.Fatal("expected first unlock to release")
+	}
+
+	//其他持有者在两次Unlock之间获取了锁
+	if err := mr.Set(redisKey("twice"), "other"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if rd.Unlock(ctx, "twice") {
+			t.Fatal("expected repeated unlock to be a no-op")
+		}
+	}
+	if v, _ := mr.Get(redisKey("twice")); v != "other" {
+		t.Fatalf("expected other holder's lock to be kept, got %q", v)
+	}
+}
+
+func TestLockInfo(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	l, err := rd.Acquire(ctx, "info", WithReason("audit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Unlock(ctx)
+
+	stored, _ := mr.Get(redisKey("info"))
+	want, _ := parseLockerValue(stored)
+	info := l.Info()
+	if info.Token != l.Token() || info.Host != want.Host || !info.LockedAt.Equal(want.LockedAt) || info.Reason != "audit" {
+		t.Fatalf("expected info to match stored value, got %+v want %+v", info, want)
+	}
+	if info.Key != redisKey("info") || info.TTL != lockTTL {
+		t.Fatalf("unexpected key/ttl %q %s", info.Key, info.TTL)
+	}
+}
+
+func TestAdvise(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	if _, err := rd.Owner(ctx, "soft"); err != ErrNotHeld {
+		t.Fatalf("expected ErrNotHeld, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := rd.Advise(ctx, "soft", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//意向的键不会与调用方"advice:<key>"上的锁冲突
+	if !rd.TryLock(ctx, "advice:plain") {
+		t.Fatal("expected plain lock to acquire")
+	}
+	if err := rd.Advise(ctx, "plain", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	//模拟另一个进程的意向及一个已过期的意向
+	other, _ := json.Marshal(adviceEntry{LockInfo: LockInfo{Token: "other", Host: "peer", LockedAt: time.Now().Add(time.Second)}, ExpireAt: time.Now().Add(time.Minute).UnixNano() / int64(time.Millisecond)})
+	stale, _ := json.Marshal(adviceEntry{LockInfo: LockInfo{Token: "stale"}, ExpireAt: time.Now().Add(-time.Second).UnixNano() / int64(time.Millisecond)})
+	mr.HSet(adviceKey("soft"), "other", string(other), "stale", string(stale))
+
+	peers, err := rd.Peers(ctx, "soft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 2 || peers[1].Token != "other" || peers[0].TTL <= 0 {
+		t.Fatalf("unexpected peers %+v", peers)
+	}
+	owner, err := rd.Owner(ctx, "soft")
+	if err != nil || owner.Token != "other" {
+		t.Fatalf("expected latest advisor as owner, got %+v %v", owner, err)
+	}
+
+	//再次登记时清理过期的意向
+	if err := rd.Advise(ctx, "soft", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if mr.HGet(adviceKey("soft"), "stale") != "" {
+		t.Fatal("expected stale advice to be removed")
+	}
+}
+
+type traceKey struct{}
+
+func TestOwnerEnrichers(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	SetOwnerEnrichers(map[string]func(ctx context.Context) string{
+		"trace": func(ctx context.Context) string {
+			v, _ := ctx.Value(traceKey{}).(string)
+			return v
+		},
+		"tenant": func(context.Context) string { return strings.Repeat("t", 1000) },
+		"zone":   func(context.Context) string { return "" },
+	})
+	defer SetOwnerEnrichers(nil)
+
+	ctx := context.WithValue(context.Background(), traceKey{}, "abc123")
+	l, err := rd.Acquire(ctx, "enriched")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Unlock(ctx)
+
+	info, err := rd.Owner(ctx, "enriched")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Labels["trace"] != "abc123" || len(info.Labels["tenant"]) != maxLabelLength {
+		t.Fatalf("unexpected labels %+v", info.Labels)
+	}
+	if _, ok := info.Labels["zone"]; ok {
+		t.Fatal("expected empty label to be omitted")
+	}
+}
+
+func TestRelock(t *testing.T) {
+	for _, size := range []int{0, 2} {
+		t.Run(fmt.Sprintf("pool=%d", size), func(t *testing.T) {
+			withRenewalPoolSize(t, size)
+			rd, mr := newTestDriver(t)
+			ctx := context.Background()
+
+			l, err := rd.Acquire(ctx, "phased")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rkey := redisKey("phased")
+
+			//收紧租期后续期间隔随之缩短(默认间隔为1s)，续期使用新的TTL
+			if err = l.Relock(ctx, time.Second); err != nil {
+				t.Fatal(err)
+			}
+			if ttl := mr.TTL(rkey); ttl != time.Second {
+				t.Fatalf("expected ttl 1s, got %s", ttl)
+			}
+			mr.SetTTL(rkey, 500*time.Millisecond)
+			select {
+			case ok := <-l.RenewTicks():
+				if !ok {
+					t.Fatal("expected renewal to succeed")
+				}
+			case <-time.After(500 * time.Millisecond):
+				t.Fatal("expected renewal interval to follow the new ttl")
+			}
+			if ttl := mr.TTL(rkey); ttl != time.Second {
+				t.Fatalf("expected renewal to keep ttl 1s, got %s", ttl)
+			}
+
+			//放宽租期，手动续期同样使用新的TTL
+			if err = l.Relock(ctx, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			mr.SetTTL(rkey, time.Second)
+			if err = l.Extend(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if ttl := mr.TTL(rkey); ttl != time.Minute {
+				t.Fatalf("expected ttl 1m, got %s", ttl)
+			}
+
+			if err = l.Unlock(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err = l.Relock(ctx, time.Second); err != ErrNotHeld {
+				t.Fatalf("expected ErrNotHeld, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRenewalNone(t *testing.T) {
+	SetRenewalMode(RenewalNone)
+	defer SetRenewalMode(RenewalAuto)
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	l, err := rd.Acquire(ctx, "stateless")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rd.TryLockSome(ctx, []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := rd.states.count(); n != 0 {
+		t.Fatalf("expected no local states, got %d", n)
+	}
+	if ttl := mr.TTL(redisKey("stateless")); ttl != lockTTL {
+		t.Fatalf("expected ttl %s, got %s", lockTTL, ttl)
+	}
+
+	//句柄按值比较后释放
+	mr.Set(redisKey("stateless"), "other")
+	if err = l.Unlock(ctx); err != ErrNotHeld {
+		t.Fatalf("expected ErrNotHeld, got %v", err)
+	}
+	if !rd.Unlock(ctx, "a") || mr.Exists(redisKey("a")) {
+		t.Fatal("expected unlock by key to delete the lock")
+	}
+}
+
+func TestRenewalLag(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	if lag, key, _ := rd.renewalLag(); lag != 0 || key != "" {
+		t.Fatalf("expected no lag without locks, got %s %q", lag, key)
+	}
+
+	l, err := rd.Acquire(ctx, "lagging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Unlock(ctx)
+	if _, key, _ := rd.renewalLag(); key != "" {
+		t.Fatalf("expected fresh lock to be healthy, got %q", key)
+	}
+
+	st, _ := rd.states.load(rd.stateKey(&lockOptions{}, redisKey("lagging")))
+	st.lastRenewed.Store(time.Now().Add(-lockTTL * 3 / 4).UnixNano())
+	lag, key, _ := rd.renewalLag()
+	if key != "lagging" || lag < lockTTL/2 {
+		t.Fatalf("expected lagging lock to be reported, got %s %q", lag, key)
+	}
+}
+
+func TestRenewalHeadroom(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	if _, err := rd.Acquire(ctx, "headroom", WithRenewalHeadroom(100*time.Millisecond, 1)); !errors.Is(err, ErrInvalidOption) {
+		t.Fatalf("expected ErrInvalidOption, got %v", err)
+	}
+
+	l, err := rd.Acquire(ctx, "headroom", WithRenewalHeadroom(50*time.Millisecond, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Unlock(ctx)
+	if ttl := mr.TTL(redisKey("headroom")); ttl != 150*time.Millisecond {
+		t.Fatalf("expected ttl 150ms, got %s", ttl)
+	}
+
+	mr.SetTTL(redisKey("headroom"), time.Millisecond)
+	select {
+	case ok := <-l.RenewTicks():
+		if !ok {
+			t.Fatal("expected renewal to succeed")
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("expected renewal at the headroom interval")
+	}
+	if ttl := mr.TTL(redisKey("headroom")); ttl != 150*time.Millisecond {
+		t.Fatalf("expected renewal to restore ttl 150ms, got %s", ttl)
+	}
+}
+
+func TestLeakReport(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	old, err := rd.Acquire(ctx, "forgotten", WithAcquireBacktrace())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Unlock(ctx)
+	fresh, err := rd.Acquire(ctx, "fresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fresh.Unlock(ctx)
+
+	st, _ := rd.states.load(rd.stateKey(&lockOptions{}, redisKey("forgotten")))
+	st.acquiredAt = st.acquiredAt.Add(-time.Minute)
+
+	leaked := rd.leakReport(30 * time.Second)
+	if len(leaked) != 1 || leaked[0].Key != "forgotten" || leaked[0].HeldFor < time.Minute {
+		t.Fatalf("unexpected report %+v", leaked)
+	}
+	if !strings.Contains(leaked[0].Stack, "TestLeakReport") {
+		t.Fatalf("expected acquisition stack, got %q", leaked[0].Stack)
+	}
+	if all := rd.leakReport(0); len(all) != 2 || all[1].Stack != "" {
+		t.Fatalf("expected both locks, stack only with the option, got %+v", all)
+	}
+}
+
+func TestRename(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	if _, err := rd.Rename(ctx, "missing", "other"); err != ErrNotHeld {
+		t.Fatalf("expected ErrNotHeld, got %v", err)
+	}
+
+	l, err := rd.Acquire(ctx, "resource:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr.Set(redisKey("resource:v2"), "busy")
+	if ok, err := rd.Rename(ctx, "resource:v1", "resource:v2"); ok || err != nil {
+		t.Fatalf("expected occupied new key to fail, got %v %v", ok, err)
+	}
+	mr.Del(redisKey("resource:v2"))
+
+	if ok, err := rd.Rename(ctx, "resource:v1", "resource:v2"); !ok || err != nil {
+		t.Fatalf("expected rename to succeed, got %v %v", ok, err)
+	}
+	if mr.Exists(redisKey("resource:v1")) {
+		t.Fatal("expected old key to be deleted")
+	}
+	if v, _ := mr.Get(redisKey("resource:v2")); v != l.Value() {
+		t.Fatalf("expected the same value on the new key, got %q", v)
+	}
+	if _, ok := rd.states.load(rd.stateKey(&lockOptions{}, redisKey("resource:v2"))); !ok {
+		t.Fatal("expected renewal to track the new key")
+	}
+	if !rd.Unlock(ctx, "resource:v2") || mr.Exists(redisKey("resource:v2")) {
+		t.Fatal("expected the new key to be released")
+	}
+}
+
+type prefixProvider struct{ n int }
+
+func (p *prefixProvider) Value(ctx context.Context, key string) (string, error) {
+	p.n++
+	return fmt.Sprintf("v1|%s|%d", key, p.n), nil
+}
+
+func (p *prefixProvider) Token(value string) string {
+	return value[strings.LastIndex(value, "|")+1:]
+}
+
+func TestValueProvider(t *testing.T) {
+	SetValueProvider(&prefixProvider{})
+	defer SetValueProvider(nil)
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	l, err := rd.Acquire(ctx, "custom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := mr.Get(redisKey("custom")); v != "v1|custom|1" || l.Token() != "1" {
+		t.Fatalf("unexpected value %q token %q", v, l.Token())
+	}
+	if err = rd.SafeExtend(ctx, "custom", "1", 0, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	SetValueProvider(nil)
+	l, err = rd.Acquire(ctx, "custom", WithToken("default-token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Unlock(ctx)
+	if l.Token() != "default-token" {
+		t.Fatalf("expected default provider to honor WithToken, got %q", l.Token())
+	}
+}
+
+func TestLocalGate(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	hook := &countingHook{}
+	rd.client.AddHook(hook)
+	ctx := context.Background()
+
+	l, err := rd.Acquire(ctx, "hot", WithLocalGate())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//本地已持有时直接失败，不访问redis
+	before := hook.n.Load()
+	if rd.TryLock(ctx, "hot", WithLocalGate()) {
+		t.Fatal("expected gated lock to fail")
+	}
+	if n := hook.n.Load() - before; n != 0 {
+		t.Fatalf("expected no redis commands, saw %d", n)
+	}
+
+	//阻塞加锁在本地等待，释放后获取
+	got := make(chan *Lock)
+	go func() {
+		l, err := rd.Lock(ctx, "hot", WithLocalGate(), WithRetryInterval(10*time.Millisecond))
+		if err != nil {
+			t.Error(err)
+		}
+		got <- l
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if n := hook.n.Load() - before; n != 0 {
+		t.Fatalf("expected waiter to queue locally, saw %d redis commands", n)
+	}
+	if err = l.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case l = <-got:
+	case <-time.After(time.Second):
+		t.Fatal("expected waiter to acquire after unlock")
+	}
+	if !rd.Unlock(ctx, "hot") {
+		t.Fatal("expected unlock")
+	}
+	if len(rd.gates.gates) != 0 {
+		t.Fatalf("expected gates to be cleaned up, got %d", len(rd.gates.gates))
+	}
+
+	shortCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	l, _ = rd.Acquire(ctx, "hot", WithLocalGate())
+	if _, err = rd.Lock(shortCtx, "hot", WithLocalGate()); err != ErrLockWaitTimeout {
+		t.Fatalf("expected ErrLockWaitTimeout, got %v", err)
+	}
+	_ = l.Unlock(ctx)
+}
+
+func TestAcquireWithData(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	ok, err := rd.AcquireWithData(ctx, "leader", map[string]string{"endpoint": "10.0.0.1:80"}, time.Second)
+	if err != nil || !ok {
+		t.Fatalf("expected acquisition, got %v %v", ok, err)
+	}
+	if ok, _ = rd.AcquireWithData(ctx, "leader", map[string]string{"endpoint": "10.0.0.2:80"}, time.Second); ok {
+		t.Fatal("expected second acquisition to fail")
+	}
+
+	data, err := rd.AcquireData(ctx, "leader")
+	if err != nil || data["endpoint"] != "10.0.0.1:80" {
+		t.Fatalf("unexpected data %v %v", data, err)
+	}
+
+	//关联数据随锁一起续期
+	dk := dataKey(redisKey("leader"))
+	mr.SetTTL(dk, 200*time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for mr.TTL(dk) != time.Second {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected data ttl to be renewed, got %s", mr.TTL(dk))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !rd.Unlock(ctx, "leader") {
+		t.Fatal("expected unlock")
+	}
+	if _, err = rd.AcquireData(ctx, "leader"); err != ErrNotHeld {
+		t.Fatalf("expected ErrNotHeld, got %v", err)
+	}
+}
+
+func TestOwnershipLostHook(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	type event struct{ key, expected, actual string }
+	var (
+		mux    sync.Mutex
+		events []event
+	)
+	prev := hooks
+	SetHooks(Hooks{
+		OnOwnershipLost: func(key string, expected string, actual string) {
+			mux.Lock()
+			defer mux.Unlock()
+			events = append(events, event{key, expected, actual})
+		},
+	})
+	defer SetHooks(prev)
+
+	for _, mode := range []string{"script", "watch"} {
+		rd.noScripting.Store(mode == "watch")
+		key := "stolen-" + mode
+		l, err := rd.Acquire(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = mr.Set(redisKey(key), "other")
+		if err = l.Extend(ctx); err != ErrNotHeld {
+			t.Fatalf("expected ErrNotHeld, got %v", err)
+		}
+		if rd.Unlock(ctx, key) {
+			t.Fatal("expected unlock to fail")
+		}
+		if err = l.Unlock(ctx); err != ErrNotHeld {
+			t.Fatalf("expected ErrNotHeld, got %v", err)
+		}
+	}
+	rd.noScripting.Store(false)
+
+	//锁已不存在时不通知
+	l, err := rd.Acquire(ctx, "expired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr.Del(redisKey("expired"))
+	_ = l.Unlock(ctx)
+
+	mux.Lock()
+	defer mux.Unlock()
+	if len(events) != 6 {
+		t.Fatalf("expected 6 ownership lost events, got %+v", events)
+	}
+	for _, e := range events {
+		if e.actual != "other" || e.expected == "" {
+			t.Fatalf("unexpected event %+v", e)
+		}
+	}
+}
+
+func TestPublishEvents(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	sub := rd.client.Subscribe(ctx, "lock-events")
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+	next := func() LockEvent {
+		t.Helper()
+		rctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		msg, err := sub.ReceiveMessage(rctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var e LockEvent
+		if err = json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	l, err := rd.Acquire(ctx, "evented", WithPublishEvents("lock-events"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e.Type != EventAcquired || e.Key != "evented" || e.Owner.Token != l.Token() {
+		t.Fatalf("unexpected acquired event %+v", e)
+	}
+	//按key释放时沿用获取时设置的频道
+	if !rd.Unlock(ctx, "evented") {
+		t.Fatal("expected unlock")
+	}
+	if e := next(); e.Type != EventReleased || e.Owner.Token != l.Token() {
+		t.Fatalf("unexpected released event %+v", e)
+	}
+
+	renewalCtx, cancel := context.WithCancel(ctx)
+	if _, err = rd.Acquire(ctx, "evented", WithPublishEvents("lock-events"), WithRenewalContext(renewalCtx)); err != nil {
+		t.Fatal(err)
+	}
+	_ = next()
+	cancel()
+	if e := next(); e.Type != EventLost || e.Reason != context.Canceled.Error() {
+		t.Fatalf("unexpected lost event %+v", e)
+	}
+}
+
+func TestReleasedHookCorrelation(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	var (
+		mux      sync.Mutex
+		locked   []LockInfo
+		released []LockInfo
+	)
+	prev := hooks
+	SetHooks(Hooks{
+		OnLocked: func(_ string, info LockInfo) {
+			mux.Lock()
+			defer mux.Unlock()
+			locked = append(locked, info)
+		},
+		OnReleased: func(_ string, info LockInfo, ok bool) {
+			mux.Lock()
+			defer mux.Unlock()
+			if ok {
+				released = append(released, info)
+			}
+		},
+	})
+	defer SetHooks(prev)
+
+	if !rd.TryLock(ctx, "traced") || !rd.Unlock(ctx, "traced") {
+		t.Fatal("expected lock and unlock")
+	}
+
+	//本地未持有时从redis读取被删除的值
+	mr.Set(redisKey("foreign"), lockerValue("foreign-token"))
+	if !rd.Unlock(ctx, "foreign") {
+		t.Fatal("expected unlock")
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	if len(locked) != 1 || len(released) != 2 || released[0].Token != locked[0].Token || released[1].Token != "foreign-token" {
+		t.Fatalf("expected release to carry the acquisition token, locked %+v released %+v", locked, released)
+	}
+}
+
+func TestUnlockE(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	if !rd.TryLock(ctx, "audit") {
+		t.Fatal("expected lock")
+	}
+	mr.SetTTL(redisKey("audit"), 200*time.Millisecond)
+	res, remaining, err := rd.UnlockE(ctx, "audit")
+	if err != nil || res != UnlockReleased || remaining != 200*time.Millisecond {
+		t.Fatalf("expected release with 200ms remaining, got %v %s %v", res, remaining, err)
+	}
+	if res, _, _ = rd.UnlockE(ctx, "audit"); res != UnlockMissing {
+		t.Fatalf("expected UnlockMissing, got %v", res)
+	}
+
+	if !rd.TryLock(ctx, "audit") {
+		t.Fatal("expected lock")
+	}
+	mr.Set(redisKey("audit"), "other")
+	if res, remaining, _ = rd.UnlockE(ctx, "audit"); res != UnlockNotHeld || remaining != 0 {
+		t.Fatalf("expected UnlockNotHeld, got %v %s", res, remaining)
+	}
+	if v, _ := mr.Get(redisKey("audit")); v != "other" {
+		t.Fatal("expected other holder's lock to be kept")
+	}
+}
+
+func TestSelfContention(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+	defer SetSelfContention(SelfContentionFail)
+
+	l, err := rd.Acquire(ctx, "self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := rd.TryLockE(ctx, "self"); ok || err != nil {
+		t.Fatalf("expected default to report plain contention, got %v %v", ok, err)
+	}
+
+	SetSelfContention(SelfContentionError)
+	if _, err = rd.TryLockE(ctx, "self"); err != ErrAlreadyHeldBySelf {
+		t.Fatalf("expected ErrAlreadyHeldBySelf, got %v", err)
+	}
+
+	SetSelfContention(SelfContentionSucceed)
+	again, err := rd.Acquire(ctx, "self")
+	if err != nil || again.Value() != l.Value() {
+		t.Fatalf("expected existing lock to be returned, got %v", err)
+	}
+	if err = again.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Unlock(ctx); err != ErrNotHeld {
+		t.Fatalf("expected a single unlock to release, got %v", err)
+	}
+}
+
+func TestUnlockAsync(t *testing.T) {
+	rd, mr := newTestDriver(t)
+
+	if !rd.TryLock(context.Background(), "async") {
+		t.Fatal("expected lock")
+	}
+	//后台删除不受ctx取消影响
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := rd.UnlockAsync(ctx, "async")
+	cancel()
+	if n := rd.states.count(); n != 0 {
+		t.Fatalf("expected local state to be removed synchronously, got %d", n)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(redisKey("async")) {
+		t.Fatal("expected lock to be deleted")
+	}
+	if err := <-rd.UnlockAsync(context.Background(), "async"); err != ErrNotHeld {
+		t.Fatalf("expected ErrNotHeld, got %v", err)
+	}
+}
+
+func TestLegacyKeyFallback(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+	SetNamespace("app")
+	defer SetNamespace("")
+
+	//旧版本进程持有不带前缀的键
+	mr.Set("job", lockerValue("legacy"))
+	if rd.TryLock(ctx, "job", WithLegacyKeyFallback()) {
+		t.Fatal("expected legacy holder to block acquisition")
+	}
+	mr.Set("report", lockerValue("legacy"))
+	if !rd.TryLock(ctx, "report") {
+		t.Fatal("expected acquisition without fallback to ignore the legacy key")
+	}
+
+	//本地未持有时按key释放一并清理旧键
+	if !rd.Unlock(ctx, "job", WithLegacyKeyFallback()) || mr.Exists("job") {
+		t.Fatal("expected legacy key to be cleaned up")
+	}
+	if !rd.TryLock(ctx, "job", WithLegacyKeyFallback()) {
+		t.Fatal("expected acquisition once the legacy key is gone")
+	}
+	if !rd.Unlock(ctx, "job", WithLegacyKeyFallback()) || mr.Exists("app:job") {
+		t.Fatal("expected unlock")
+	}
+}
+
+func TestAcquireInPipeline(t *testing.T) {
+	withRenewalInterval(t, 20*time.Millisecond)
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	var pending *PendingLock
+	_, err := rd.client.TxPipelined(ctx, func(pipe redisLib.Pipeliner) error {
+		var err error
+		if pending, err = rd.AcquireInPipeline(ctx, pipe, "tx"); err != nil {
+			return err
+		}
+		pipe.Set(ctx, "tx-data", "x", 0)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := pending.Commit(ctx)
+	if err != nil {
+		t.Fatalf("expected commit to acquire, got %v", err)
+	}
+	if v, _ := mr.Get("tx-data"); v != "x" {
+		t.Fatalf("expected other commands to run in the same transaction, got %q", v)
+	}
+
+	//提交后登记续期
+	rkey := redisKey("tx")
+	mr.SetTTL(rkey, 200*time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for mr.TTL(rkey) != lockTTL {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected committed lock to be renewed, got %s", mr.TTL(rkey))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, err = rd.client.TxPipelined(ctx, func(pipe redisLib.Pipeliner) error {
+		var err error
+		pending, err = rd.AcquireInPipeline(ctx, pipe, "tx")
+		return err
+	})
+	if err != redisLib.Nil {
+		t.Fatalf("expected pipeline to report redis.Nil, got %v", err)
+	}
+	if _, err = pending.Commit(ctx); err != ErrNotAcquired {
+		t.Fatalf("expected ErrNotAcquired, got %v", err)
+	}
+
+	if _, err = rd.AcquireInPipeline(ctx, rd.client.TxPipeline(), "tx", WithTerm(1)); err != ErrInvalidOption {
+		t.Fatalf("expected ErrInvalidOption, got %v", err)
+	}
+
+	if err = l.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTokenGenerator(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	n := 0
+	SetTokenGenerator(func() string {
+		n++
+		return fmt.Sprintf("token-%d", n)
+	})
+	defer SetTokenGenerator(nil)
+
+	l, err := rd.Acquire(ctx, "deterministic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := mr.Get(redisKey("deterministic"))
+	if info, _ := parseLockerValue(stored); info.Token != "token-1" || l.Token() != "token-1" || stored != l.Value() {
+		t.Fatalf("expected injected token, got %q", stored)
+	}
+
+	//其他持有者的值不会被删除
+	other := lockerValue(newToken())
+	_ = mr.Set(redisKey("deterministic"), other)
+	if err = l.Unlock(ctx); err != ErrNotHeld {
+		t.Fatalf("expected ErrNotHeld, got %v", err)
+	}
+	if v, _ := mr.Get(redisKey("deterministic")); v != other {
+		t.Fatalf("expected the other owner's value to remain, got %q", v)
+	}
+
+	SetTokenGenerator(nil)
+	if token := newToken(); len(token) != 32 {
+		t.Fatalf("expected default random token, got %q", token)
+	}
+}
+
+func TestTypeChecking(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	mr.HSet(redisKey("profile"), "name", "x")
+	if ok, err := rd.TryLockE(ctx, "profile"); ok || err != nil {
+		t.Fatalf("expected plain contention without type checking, got %v %v", ok, err)
+	}
+	if _, err := rd.TryLockE(ctx, "profile", WithTypeChecking()); !errors.Is(err, ErrKeyTypeConflict) {
+		t.Fatalf("expected ErrKeyTypeConflict for a hash, got %v", err)
+	}
+	lctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if _, err := rd.Lock(lctx, "profile", WithTypeChecking()); !errors.Is(err, ErrKeyTypeConflict) {
+		t.Fatalf("expected blocking lock to fail fast, got %v", err)
+	}
+
+	_ = mr.Set(redisKey("counter"), "17")
+	if _, err := rd.TryLockE(ctx, "counter", WithTypeChecking()); !errors.Is(err, ErrKeyTypeConflict) {
+		t.Fatalf("expected ErrKeyTypeConflict for a foreign string, got %v", err)
+	}
+
+	//真正的锁被占用时仍是普通的竞争
+	if _, err := rd.Acquire(ctx, "held"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := rd.TryLockE(ctx, "held", WithTypeChecking()); ok || err != nil {
+		t.Fatalf("expected contention, got %v %v", ok, err)
+	}
+}
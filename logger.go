@@ -0,0 +1,25 @@
+package corgi
+
+import (
+	"log"
+	"os"
+)
+
+// Logger 日志输出接口
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+var logger Logger = log.New(os.Stderr, "[corgi] ", log.LstdFlags)
+
+// SetLogger 设置日志输出，传入nil时不输出日志
+func SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	logger = l
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(string, ...interface{}) {}
@@ -0,0 +1,319 @@
+package corgi
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// 每批SCAN的键数量
+var scanBatchSize int64 = 100
+
+// ReleaseByHost 释放指定主机持有的锁
+//
+// 用于主机宕机后的运维恢复：通过SCAN遍历匹配keyPattern(自动加上命名空间前缀)的键，解析持有者信息，
+// 对属于hostname的锁执行compare-and-delete，返回释放的数量。
+func ReleaseByHost(ctx context.Context, hostname string, keyPattern string) (int, error) {
+	return lockDriver.releaseByHost(ctx, hostname, keyPattern)
+}
+
+func (rd *redisDriver) releaseByHost(ctx context.Context, hostname string, keyPattern string) (int, error) {
+	var (
+		mux      sync.Mutex
+		released int
+	)
+	err := rd.scan(ctx, redisPattern(keyPattern), func(c redisLib.UniversalClient, keys []string) error {
+		values, err := getValues(ctx, c, keys)
+		if err != nil {
+			return err
+		}
+
+		owned := make(map[string]string)
+		for i, value := range values {
+			if info, ok := parseLockerValue(value); ok && ownedByHost(info, hostname) {
+				owned[keys[i]] = value
+			}
+		}
+		if len(owned) == 0 {
+			return nil
+		}
+
+		n, err := rd.compareAndDeleteBatch(ctx, c, owned)
+		if err != nil {
+			return err
+		}
+
+		mux.Lock()
+		released += n
+		mux.Unlock()
+
+		return nil
+	})
+
+	return released, err
+}
+
+// Inspect 列出redis中匹配pattern(自动加上命名空间前缀)的所有锁及其持有者信息
+//
+// 覆盖所有进程持有的锁，而不仅是本进程持有的；通过SCAN分批遍历，不会阻塞redis。
+// 值无法解析(非corgi写入)的键会被忽略。
+func Inspect(ctx context.Context, pattern string) ([]LockInfo, error) {
+	return lockDriver.inspect(ctx, pattern)
+}
+
+func (rd *redisDriver) inspect(ctx context.Context, pattern string) ([]LockInfo, error) {
+	var (
+		mux   sync.Mutex
+		infos []LockInfo
+	)
+	err := rd.scan(ctx, redisPattern(pattern), func(c redisLib.UniversalClient, keys []string) error {
+		batch, err := readLockInfos(ctx, c, keys)
+		if err != nil {
+			return err
+		}
+
+		mux.Lock()
+		infos = append(infos, batch...)
+		mux.Unlock()
+
+		return nil
+	})
+
+	return infos, err
+}
+
+// IsLocked 锁当前是否被(任意进程)持有
+func (rd *redisDriver) IsLocked(ctx context.Context, key string) (bool, error) {
+	if err := validateKey(key); err != nil {
+		return false, err
+	}
+	c := rd.cmdable()
+	if c == nil {
+		return false, ErrNotConfigured
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	n, err := c.Exists(ctx, redisKey(key)).Result()
+	return n > 0, err
+}
+
+func (rd *redisDriver) Owner(ctx context.Context, key string) (LockInfo, error) {
+	if err := validateKey(key); err != nil {
+		return LockInfo{}, err
+	}
+
+	c := rd.cmdable()
+	if c == nil {
+		return LockInfo{}, ErrNotConfigured
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	infos, err := readLockInfos(ctx, c, []string{redisKey(key)})
+	if err != nil {
+		return LockInfo{}, err
+	}
+	if len(infos) > 0 {
+		return infos[0], nil
+	}
+
+	peers, err := rd.Peers(ctx, key)
+	if err != nil {
+		return LockInfo{}, err
+	}
+	if len(peers) == 0 {
+		return LockInfo{}, ErrNotHeld
+	}
+
+	return peers[len(peers)-1], nil
+}
+
+// Owners 批量查询一组锁的持有者信息，不存在或无法解析的锁不会出现在结果中
+//
+// 在一次pipeline中读取所有键(GET及PTTL)，cluster模式下由客户端按节点拆分，无需键位于同一个slot；
+// 不会像Owner那样回退到登记了意向(Advise)的进程。
+func (rd *redisDriver) Owners(ctx context.Context, keys []string) (map[string]LockInfo, error) {
+	for _, key := range keys {
+		if err := validateKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	c := rd.cmdable()
+	if c == nil {
+		return nil, ErrNotConfigured
+	}
+	owners := make(map[string]LockInfo, len(keys))
+	if len(keys) == 0 {
+		return owners, nil
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	keys = uniqueKeys(keys)
+	byRkey := make(map[string]string, len(keys))
+	rkeys := make([]string, len(keys))
+	for i, key := range keys {
+		rkeys[i] = redisKey(key)
+		byRkey[rkeys[i]] = key
+	}
+
+	infos, err := readLockInfos(ctx, c, rkeys)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		owners[byRkey[info.Key]] = info
+	}
+
+	return owners, nil
+}
+
+// 批量读取键的持有者信息及剩余过期时间，忽略不存在或无法解析的键
+func readLockInfos(ctx context.Context, c redisLib.UniversalClient, keys []string) ([]LockInfo, error) {
+	pipe := c.Pipeline()
+	getCmds := make([]*redisLib.StringCmd, len(keys))
+	ttlCmds := make([]*redisLib.DurationCmd, len(keys))
+	for i, key := range keys {
+		getCmds[i] = pipe.Get(ctx, key)
+		ttlCmds[i] = pipe.PTTL(ctx, key)
+	}
+	_, _ = pipe.Exec(ctx)
+
+	infos := make([]LockInfo, 0, len(keys))
+	for i, key := range keys {
+		value, err := getCmds[i].Result()
+		if err == redisLib.Nil || (err != nil && isWrongType(err)) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		info, ok := parseLockerValue(value)
+		if !ok {
+			continue
+		}
+		info.Key = key
+		info.TTL = ttlCmds[i].Val()
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// 是否为指定主机持有的锁，紧凑编码下按主机名指纹匹配
+func ownedByHost(info LockInfo, hostname string) bool {
+	if info.Host != "" {
+		return info.Host == hostname
+	}
+
+	return info.Fingerprint != "" && strings.HasPrefix(info.Fingerprint, hostFingerprint(hostname))
+}
+
+// 批量执行compare-and-delete，返回删除的数量
+func (rd *redisDriver) compareAndDeleteBatch(ctx context.Context, c redisLib.UniversalClient, values map[string]string) (int, error) {
+	n := 0
+	if rd.noScripting.Load() {
+		for key, value := range values {
+			ok, err := rd.compareAndDelete(ctx, c, key, value)
+			if err != nil {
+				return n, err
+			}
+			if ok {
+				n++
+			}
+		}
+		return n, nil
+	}
+
+	//pipeline中无法根据NOSCRIPT回退，直接使用EVAL
+	pipe := c.Pipeline()
+	cmds := make([]*redisLib.Cmd, 0, len(values))
+	for key, value := range values {
+		cmds = append(cmds, compareAndDeleteScript.Eval(ctx, pipe, []string{key}, value))
+	}
+	_, _ = pipe.Exec(ctx)
+
+	for _, cmd := range cmds {
+		cnt, err := cmd.Int()
+		if err != nil {
+			return n, err
+		}
+		n += cnt
+	}
+
+	return n, nil
+}
+
+// 使用pipeline批量读取键的值，键不存在或非字符串类型时对应位置为空字符串
+//
+// 不使用MGET，因为cluster模式下同一节点上的键也可能分属不同的slot。
+func getValues(ctx context.Context, c redisLib.UniversalClient, keys []string) ([]string, error) {
+	pipe := c.Pipeline()
+	cmds := make([]*redisLib.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Get(ctx, key)
+	}
+	_, _ = pipe.Exec(ctx)
+
+	values := make([]string, len(keys))
+	for i, cmd := range cmds {
+		value, err := cmd.Result()
+		if err == nil {
+			values[i] = value
+			continue
+		}
+		if err == redisLib.Nil || isWrongType(err) {
+			continue
+		}
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// 使用SCAN分批遍历匹配的键，cluster模式下逐个遍历主节点
+//
+// fn收到的keys均位于同一节点上，可直接使用该节点的客户端批量操作；
+// cluster模式下各主节点并发遍历，fn需要保证并发安全。
+func (rd *redisDriver) scan(ctx context.Context, match string, fn func(c redisLib.UniversalClient, keys []string) error) error {
+	if rd.client == nil && rd.clusterClient == nil {
+		return ErrNotConfigured
+	}
+
+	scanNode := func(ctx context.Context, c *redisLib.Client) error {
+		var cursor uint64
+		for {
+			keys, next, err := c.Scan(ctx, cursor, match, scanBatchSize).Result()
+			if err != nil {
+				return err
+			}
+			if len(keys) > 0 {
+				if err = fn(c, keys); err != nil {
+					return err
+				}
+			}
+			if next == 0 {
+				return nil
+			}
+			cursor = next
+		}
+	}
+
+	if rd.client != nil {
+		return scanNode(ctx, rd.client)
+	}
+
+	return rd.clusterClient.ForEachMaster(ctx, scanNode)
+}
+
+func isWrongType(err error) bool {
+	return strings.HasPrefix(err.Error(), "WRONGTYPE")
+}
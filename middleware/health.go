@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/keepchen/corgi"
+)
+
+// Healthz 健康检查接口，续期滞后(见corgi.Healthz)或redis不可达(见corgi.Ping)时返回503，否则返回200
+func Healthz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := corgi.Healthz()
+		if err == nil {
+			err = corgi.Ping(r.Context())
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
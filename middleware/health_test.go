@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	redisLib "github.com/go-redis/redis/v8"
+	"github.com/keepchen/corgi"
+)
+
+var (
+	healthzOnce  sync.Once
+	healthzRedis *miniredis.Miniredis
+)
+
+func TestHealthz(t *testing.T) {
+	//全局的redis连接只能设置一次，多次运行时复用同一个miniredis
+	healthzOnce.Do(func() {
+		healthzRedis = miniredis.NewMiniRedis()
+		if err := healthzRedis.Start(); err != nil {
+			t.Fatal(err)
+		}
+		corgi.SetRedisProviderClient(redisLib.NewClient(&redisLib.Options{Addr: healthzRedis.Addr()}))
+	})
+	mr := healthzRedis
+	ctx := context.Background()
+
+	status := func() int {
+		rec := httptest.NewRecorder()
+		Healthz().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		return rec.Code
+	}
+	if code := status(); code != http.StatusOK {
+		t.Fatalf("expected 200 when healthy, got %d", code)
+	}
+
+	//阈值极小时刚获取的锁即视为续期滞后
+	l, err := corgi.Wakeup().Acquire(ctx, "healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	corgi.SetHealthzLagRatio(1e-9)
+	code := status()
+	corgi.SetHealthzLagRatio(0.5)
+	_ = l.Unlock(ctx)
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 when renewal lags, got %d", code)
+	}
+
+	mr.Close()
+	defer func() { _ = mr.Restart() }()
+	if code = status(); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 when redis is unreachable, got %d", code)
+	}
+}
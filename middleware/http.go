@@ -0,0 +1,64 @@
+// Package middleware 基于corgi分布式锁的net/http中间件
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/keepchen/corgi"
+)
+
+var (
+	locker       corgi.BasicLocker
+	rejectStatus = http.StatusConflict
+)
+
+// SetLocker 设置中间件使用的锁实例，默认使用corgi.Wakeup()
+//
+// 中间件只需获取和释放锁，可传入任意Locker或corgi.QuorumLocker等BasicLocker实现。
+func SetLocker(l corgi.BasicLocker) {
+	locker = l
+}
+
+// SetRejectStatus 设置获取锁失败(锁被占用)时的响应状态码，默认409
+//
+// 常见取值为http.StatusConflict(409)或http.StatusTooManyRequests(429)
+func SetRejectStatus(code int) {
+	rejectStatus = code
+}
+
+// LockMiddleware 按请求派生的key串行化处理请求
+//
+// keyFunc返回空字符串时不加锁直接放行；锁被占用时返回SetRejectStatus设置的状态码，
+// redis异常时返回503；请求处理完成后释放锁。
+func LockMiddleware(keyFunc func(*http.Request) string, opts ...corgi.LockOption) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := keyFunc(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			l := locker
+			if l == nil {
+				l = corgi.Wakeup()
+			}
+
+			ok, err := l.TryLockE(r.Context(), key, opts...)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+			if !ok {
+				http.Error(w, http.StatusText(rejectStatus), rejectStatus)
+				return
+			}
+
+			//客户端断开时请求context会被取消，释放锁不应受其影响；释放时需传入相同的选项(如WithDB)
+			defer l.Unlock(context.Background(), key, opts...)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	redisLib "github.com/go-redis/redis/v8"
+	"github.com/keepchen/corgi"
+)
+
+type fakeLocker struct {
+	corgi.Locker
+	mux  sync.Mutex
+	held map[string]bool
+	err  error
+}
+
+func (f *fakeLocker) TryLock(ctx context.Context, key string, opts ...corgi.LockOption) bool {
+	ok, _ := f.TryLockE(ctx, key, opts...)
+	return ok
+}
+
+func (f *fakeLocker) TryLockE(_ context.Context, key string, _ ...corgi.LockOption) (bool, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.err != nil {
+		return false, f.err
+	}
+	if f.held[key] {
+		return false, nil
+	}
+	f.held[key] = true
+	return true, nil
+}
+
+func (f *fakeLocker) Unlock(_ context.Context, key string, _ ...corgi.LockOption) bool {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	ok := f.held[key]
+	delete(f.held, key)
+	return ok
+}
+
+func TestLockMiddleware(t *testing.T) {
+	fl := &fakeLocker{held: make(map[string]bool)}
+	SetLocker(fl)
+	defer SetLocker(nil)
+
+	keyFunc := func(r *http.Request) string { return r.URL.Query().Get("user") }
+
+	var inner *httptest.ResponseRecorder
+	handler := LockMiddleware(keyFunc)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//处理过程中同一个key的请求应被拒绝
+		inner = httptest.NewRecorder()
+		LockMiddleware(keyFunc)(http.NotFoundHandler()).ServeHTTP(inner, r)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?user=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if inner.Code != http.StatusConflict {
+		t.Fatalf("expected nested request to be rejected with 409, got %d", inner.Code)
+	}
+	if fl.held["1"] {
+		t.Fatal("lock should be released after the response")
+	}
+
+	fl.err = errors.New("redis down")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?user=1", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rec.Code)
+	}
+}
+
+func TestLockMiddlewareWithDB(t *testing.T) {
+	mr := miniredis.RunT(t)
+	l, err := corgi.NewLocker(&redisLib.Options{Addr: mr.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetLocker(l)
+	defer SetLocker(nil)
+
+	var held bool
+	handler := LockMiddleware(func(*http.Request) string { return "tenant" }, corgi.WithDB(1))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		held = mr.DB(1).Exists("tenant")
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || !held {
+		t.Fatalf("expected the lock to be held in db 1, got %d %v", rec.Code, held)
+	}
+	//释放时使用相同的选项，锁应从db 1中删除
+	if mr.DB(1).Exists("tenant") {
+		t.Fatal("expected the lock in db 1 to be released")
+	}
+}
@@ -0,0 +1,235 @@
+package corgi
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SelfContentionMode 本进程再次获取自己已持有的锁时的行为
+type SelfContentionMode int
+
+const (
+	// SelfContentionFail 与锁被其他进程持有时相同，返回未获取(默认)
+	SelfContentionFail SelfContentionMode = iota
+	// SelfContentionError 返回ErrAlreadyHeldBySelf，便于区分进程内与跨进程的竞争
+	SelfContentionError
+	// SelfContentionSucceed 视为获取成功，返回已持有的锁；不计数，一次Unlock即释放
+	SelfContentionSucceed
+)
+
+var selfContention = SelfContentionFail
+
+// SetSelfContention 设置本进程再次获取自己(按本地状态判断)已持有的锁时的行为
+//
+// 非默认模式下，本地持有且未丢失的锁直接按模式返回，不访问redis。
+func SetSelfContention(mode SelfContentionMode) {
+	selfContention = mode
+}
+
+// LockOption 加锁选项
+type LockOption func(*lockOptions)
+
+type lockOptions struct {
+	acquireTimeout time.Duration
+	renewalGrace   time.Duration
+	onLost         func(key string, reason error)
+	token          string
+	waitReplicas   int
+	waitTimeout    time.Duration
+	maxLifetime    time.Duration
+	autoRelease    bool
+	renewalCtx     context.Context
+	hierarchy      bool
+	confirmRenewal bool
+	retryInterval  time.Duration
+	term           int64
+	reason         string
+	timeout        time.Duration
+	db             int
+	selectDB       bool
+	detectDeadlock bool
+	dynamicTTL     func() time.Duration
+	localGate      bool
+	legacyFallback bool
+	//要求的最低服务端版本及对应的功能
+	minVersion        string
+	minVersionFeature string
+	//阻塞加锁已进入本地排队时离开的函数
+	ungate func()
+	//WithRenewalHeadroom设置的续期间隔及倍数
+	headroomInterval time.Duration
+	headroomFactor   int
+	backtrace        bool
+	waitCallback     func(attempt int, elapsed time.Duration)
+	//续期出错时最多容忍的连续次数，为0时不限制(仅受宽限期限制)
+	renewalRetries int
+	policy         *RenewalPolicy
+	queuePosition  func(position int)
+	trackWaiters   bool
+	//续期的截止时间，见AcquireUntil
+	renewUntil time.Time
+	//TryLockIf的条件
+	cond *lockCondition
+	//WithPublishEvents的频道
+	eventChannel string
+	//TryLockStealStale的设置
+	steal *staleSteal
+	//获取失败时检查键的类型，见WithTypeChecking
+	typeCheck bool
+	//TryLockAndIncr的计数器
+	incr *lockIncr
+	//无效选项的错误，加锁时返回
+	err error
+}
+
+func newLockOptions(opts []LockOption) *lockOptions {
+	lo := &lockOptions{}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(lo)
+		}
+	}
+	if lo.policy != nil && lo.err == nil {
+		lo.err = lo.policy.validate(lo)
+	}
+	return lo
+}
+
+// WithAcquireTimeout 设置本次获取锁(SetNX)的超时时间
+//
+// 与全局的redis执行超时相互独立，用于对延迟敏感的场景快速失败，
+// 超时后TryLock返回false，TryLockE返回ErrTimeout。
+// 注意：超时时写入可能已在服务端生效，此时锁将在TTL后自动过期。
+func WithAcquireTimeout(d time.Duration) LockOption {
+	return func(lo *lockOptions) {
+		lo.acquireTimeout = d
+	}
+}
+
+// WithRenewalGrace 设置续期宽限期
+//
+// 续期因redis短暂异常失败时，在宽限期内持续尝试恢复续期，期间不触发锁丢失回调，
+// 超过宽限期仍未续期成功才视为锁丢失。宽限期最长不超过锁的TTL。
+func WithRenewalGrace(d time.Duration) LockOption {
+	return func(lo *lockOptions) {
+		lo.renewalGrace = d
+	}
+}
+
+// WithLostCallback 设置锁丢失时的回调
+//
+// reason为ErrRenewalFailed(续期失败)、ErrMaxLifetime(超过最长持有时间)
+// 或续期context的错误(如context.Canceled)，见Lock.Lost。
+func WithLostCallback(fn func(key string, reason error)) LockOption {
+	return func(lo *lockOptions) {
+		lo.onLost = fn
+	}
+}
+
+// WithToken 使用调用方提供的token作为锁持有者的标识，默认随机生成
+//
+// token需保证唯一，否则无法区分不同的持有者。
+func WithToken(token string) LockOption {
+	return func(lo *lockOptions) {
+		lo.token = token
+	}
+}
+
+// WithMaxLifetime 设置锁的最长持有时间
+//
+// 这是防止调用方遗漏Unlock导致锁被无限续期的上限保护，而非正常的释放方式：
+// 持有时间超过d后停止续期(锁将在TTL后过期)，触发锁丢失回调并输出告警日志。
+func WithMaxLifetime(d time.Duration) LockOption {
+	return func(lo *lockOptions) {
+		lo.maxLifetime = d
+	}
+}
+
+// WithAutoRelease 续期结束(续期失败、超过最长持有时间、续期context结束)时立即删除锁
+//
+// 删除时按值比较，不会误删新的持有者获取的锁，用于缩短锁在持有者放弃后残留的时间。
+func WithAutoRelease() LockOption {
+	return func(lo *lockOptions) {
+		lo.autoRelease = true
+	}
+}
+
+// WithRenewalContext 将自动续期绑定到ctx，ctx结束时停止续期并视为锁丢失
+func WithRenewalContext(ctx context.Context) LockOption {
+	return func(lo *lockOptions) {
+		lo.renewalCtx = ctx
+	}
+}
+
+// WithConfirmedRenewal 获取锁后同步执行一次续期并确认成功后才返回
+//
+// 确认失败时回滚已获取的锁并返回ErrRenewalFailed，确保长时间任务依赖的锁确实可以被续期，
+// 代价是每次加锁多一次往返。
+func WithConfirmedRenewal() LockOption {
+	return func(lo *lockOptions) {
+		lo.confirmRenewal = true
+	}
+}
+
+// WithReason 设置加锁原因(如"nightly-report-job")，存储在锁的值中，可通过Owner和Inspect查看
+//
+// 超过128字节的部分会被截断。
+func WithReason(reason string) LockOption {
+	return func(lo *lockOptions) {
+		lo.reason = truncate(reason, maxReasonLength)
+	}
+}
+
+// WithTimeout 设置本次调用的redis执行超时时间，仅对当前调用生效，覆盖全局的执行超时
+//
+// 适用于TryLock/Acquire、Unlock、Extend等调用；ctx自带更早的截止时间时以ctx为准。
+func WithTimeout(d time.Duration) LockOption {
+	return func(lo *lockOptions) {
+		lo.timeout = d
+	}
+}
+
+// WithDynamicTTL 自动续期时由fn决定每次续期的TTL，用于运行时长难以预估的任务按剩余工作量调整租期
+//
+// fn在续期协程中每次续期前调用；返回值不大于续期间隔时按续期间隔的2倍处理，避免锁在两次续期之间过期。
+func WithDynamicTTL(fn func() time.Duration) LockOption {
+	return func(lo *lockOptions) {
+		lo.dynamicTTL = fn
+	}
+}
+
+// WithRenewalHeadroom 按续期间隔和容错倍数设置锁的TTL(interval × factor)，代替分别设置TTL和续期间隔
+//
+// 每interval续期一次，factor为TTL内可容纳的续期次数，至少为2以容忍一次续期失败，否则加锁返回ErrInvalidOption。
+// factor越大越能容忍redis抖动，但进程崩溃后其他进程需要等待更久(最长interval × factor)才能获得锁。
+// Relock调整TTL后续期间隔按相同的倍数随之调整。
+func WithRenewalHeadroom(interval time.Duration, factor int) LockOption {
+	return func(lo *lockOptions) {
+		if interval <= 0 || factor < 2 {
+			lo.err = fmt.Errorf("%w: renewal headroom requires a positive interval and factor >= 2, got %s x %d", ErrInvalidOption, interval, factor)
+			return
+		}
+		lo.headroomInterval, lo.headroomFactor = interval, factor
+	}
+}
+
+// 加锁时设置的TTL
+func (lo *lockOptions) initialTTL() time.Duration {
+	if lo.headroomFactor > 0 {
+		return lo.untilTTL(lo.headroomInterval * time.Duration(lo.headroomFactor))
+	}
+	return lo.untilTTL(lockTTL)
+}
+
+// 本次续期使用的TTL
+func (lo *lockOptions) renewalTTL() time.Duration {
+	if lo.dynamicTTL == nil {
+		return lo.initialTTL()
+	}
+	if ttl := lo.dynamicTTL(); ttl > renewalCheckInterval {
+		return ttl
+	}
+
+	return 2 * renewalCheckInterval
+}
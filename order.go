@@ -0,0 +1,92 @@
+package corgi
+
+import (
+	"bytes"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// SortKeys 返回按规范顺序(字典序)排列的key副本，不修改传入的切片
+//
+// 约定：需要同时持有多把锁时，所有调用方都应按SortKeys的顺序依次加锁，
+// 即使加锁分散在代码的不同位置，这样不同goroutine之间不会因加锁顺序相反而死锁。
+func SortKeys(keys ...string) []string {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// WithDeadlockDetection 开启加锁顺序检测，用于在开发环境中发现死锁风险
+//
+// 记录每个goroutine通过该选项获取且仍持有的锁，当goroutine获取的key在规范顺序(见SortKeys)上
+// 排在已持有的key之前时，通过logger输出警告。每次加锁和释放都有额外的记录开销，不建议在生产环境开启。
+func WithDeadlockDetection() LockOption {
+	return func(lo *lockOptions) {
+		lo.detectDeadlock = true
+	}
+}
+
+// 按goroutine记录持有的锁，检测加锁顺序反转
+type orderTracker struct {
+	mux    sync.Mutex
+	held   map[uint64]map[string]struct{}
+	owners map[string]uint64
+}
+
+func newOrderTracker() *orderTracker {
+	return &orderTracker{held: make(map[uint64]map[string]struct{}), owners: make(map[string]uint64)}
+}
+
+// 记录当前goroutine获取了key，顺序反转时输出警告
+func (t *orderTracker) acquired(key string) {
+	gid := goroutineID()
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	held := t.held[gid]
+	for h := range held {
+		if h > key {
+			logger.Printf("WARNING: goroutine %d acquired lock %q while holding %q, out of canonical order (see SortKeys); this may deadlock", gid, key, h)
+			break
+		}
+	}
+
+	if held == nil {
+		held = make(map[string]struct{})
+		t.held[gid] = held
+	}
+	held[key] = struct{}{}
+	t.owners[key] = gid
+}
+
+// 释放key，可以在获取锁以外的goroutine中调用
+func (t *orderTracker) released(key string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	gid, ok := t.owners[key]
+	if !ok {
+		return
+	}
+	delete(t.owners, key)
+	delete(t.held[gid], key)
+	if len(t.held[gid]) == 0 {
+		delete(t.held, gid)
+	}
+}
+
+// 当前goroutine的ID，仅用于调试
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		buf = buf[:i]
+	}
+
+	id, _ := strconv.ParseUint(string(buf), 10, 64)
+	return id
+}
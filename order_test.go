@@ -0,0 +1,63 @@
+package corgi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type recordingLogger struct {
+	mux   sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *recordingLogger) count() int {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return len(l.lines)
+}
+
+func TestSortKeys(t *testing.T) {
+	keys := []string{"b", "c", "a"}
+	sorted := SortKeys(keys...)
+	if strings.Join(sorted, ",") != "a,b,c" {
+		t.Fatalf("unexpected order %v", sorted)
+	}
+	if keys[0] != "b" {
+		t.Fatal("expected input to be left untouched")
+	}
+}
+
+func TestDeadlockDetection(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+	rec := &recordingLogger{}
+	prev := logger
+	SetLogger(rec)
+	defer SetLogger(prev)
+
+	for _, key := range SortKeys("order-b", "order-a") {
+		if _, err := rd.Acquire(ctx, key, WithDeadlockDetection()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rec.count() != 0 {
+		t.Fatalf("expected no warning for canonical order, got %v", rec.lines)
+	}
+	rd.Unlock(ctx, "order-a")
+
+	if _, err := rd.Acquire(ctx, "order-a", WithDeadlockDetection()); err != nil {
+		t.Fatal(err)
+	}
+	if rec.count() != 1 {
+		t.Fatalf("expected one out-of-order warning, got %v", rec.lines)
+	}
+}
@@ -0,0 +1,62 @@
+package corgi
+
+import (
+	"fmt"
+	"time"
+)
+
+// RenewalPolicy 续期失败时的容错及通知策略，见WithRenewalPolicy
+type RenewalPolicy struct {
+	// Retries 连续续期出错时最多容忍的次数，为0时仅受Grace限制
+	Retries int
+	// Grace 距离最近一次成功续期多久之内的续期错误不视为锁丢失，为0时首次出错即视为丢失
+	Grace time.Duration
+	// OnLost 锁丢失时的回调，reason同Lock.Lost
+	OnLost func(reason error)
+}
+
+var (
+	// StrictPolicy 续期首次出错即视为锁丢失，适用于互斥性要求高于可用性的场景
+	StrictPolicy = RenewalPolicy{}
+	// ResilientPolicy 容忍redis短暂抖动：连续出错不超过3次且距上次成功续期不超过5s时继续持有
+	//
+	// 要求锁的TTL不小于5s且续期间隔不大于Grace/Retries，默认的10s TTL、1s间隔满足要求。
+	ResilientPolicy = RenewalPolicy{Retries: 3, Grace: 5 * time.Second}
+)
+
+// WithRenewalPolicy 按策略设置续期的容错(代替WithRenewalGrace)及锁丢失的回调(代替WithLostCallback)
+//
+// 加锁时校验策略与锁的TTL及续期间隔是否一致，不一致时返回ErrInvalidOption：
+// Retries、Grace不能为负，Grace不能超过TTL，设置了Retries时Grace需足够容纳Retries次续期间隔。
+func WithRenewalPolicy(p RenewalPolicy) LockOption {
+	return func(lo *lockOptions) {
+		lo.policy = &p
+		lo.renewalGrace = p.Grace
+		lo.renewalRetries = p.Retries
+		lo.onLost = nil
+		if p.OnLost != nil {
+			lo.onLost = func(key string, reason error) {
+				p.OnLost(reason)
+			}
+		}
+	}
+}
+
+// 校验续期策略与TTL及续期间隔是否一致
+func (p *RenewalPolicy) validate(lo *lockOptions) error {
+	ttl := lo.initialTTL()
+	interval := renewalCheckInterval
+	if lo.headroomFactor > 0 {
+		interval = lo.headroomInterval
+	}
+
+	switch {
+	case p.Retries < 0 || p.Grace < 0:
+		return fmt.Errorf("%w: renewal policy retries and grace must not be negative", ErrInvalidOption)
+	case p.Grace > ttl:
+		return fmt.Errorf("%w: renewal grace %s exceeds lock ttl %s", ErrInvalidOption, p.Grace, ttl)
+	case p.Retries > 0 && time.Duration(p.Retries)*interval > p.Grace:
+		return fmt.Errorf("%w: renewal grace %s cannot fit %d retries at interval %s", ErrInvalidOption, p.Grace, p.Retries, interval)
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+package corgi
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// 续期协程池的大小，为0时每把锁使用独立的续期协程
+var renewalPoolSize int
+
+// SetRenewalPoolSize 设置续期协程池的大小
+//
+// 默认(n为0)每把锁使用一个独立的续期协程，实现简单但协程数量随持有的锁线性增长；
+// n为1时由单个协程为所有锁续期；n大于1时由固定数量的协程共同处理一个按下次续期时间排序的优先队列，
+// 无论持有多少把锁，续期协程数量都不超过n。
+//
+// 协程池模式下，释放锁、续期context结束等事件在该锁下一次到期检查时才会处理(最多延迟一个续期间隔)，
+// 锁丢失回调在续期协程中执行，会占用一个工作协程，应尽快返回。需在获取锁之前设置。
+//
+// 协程池处理不过来(最逾期的续期已晚于一个续期间隔)时，新的加锁在写入redis之前直接返回ErrRenewalSaturated，
+// 而不是返回一把无法按时续期、可能悄然过期的锁；已持有的锁继续按到期顺序续期。
+func SetRenewalPoolSize(n int) {
+	if n < 0 {
+		n = 0
+	}
+	renewalPoolSize = n
+}
+
+// 协程池模式下的续期协程池，首次使用时创建
+func (rd *redisDriver) renewalPool() *renewalPool {
+	rd.poolOnce.Do(func() {
+		if renewalPoolSize > 0 {
+			rd.pool = newRenewalPool(rd, renewalPoolSize)
+		}
+	})
+
+	return rd.pool
+}
+
+// 续期任务
+type renewalTask struct {
+	st    *lockState
+	lo    *lockOptions
+	p     *renewalProgress
+	due   time.Time
+	index int
+}
+
+// 按下次续期时间排序的小顶堆
+type renewalQueue []*renewalTask
+
+func (q renewalQueue) Len() int           { return len(q) }
+func (q renewalQueue) Less(i, j int) bool { return q[i].due.Before(q[j].due) }
+func (q renewalQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *renewalQueue) Push(x interface{}) {
+	task := x.(*renewalTask)
+	task.index = len(*q)
+	*q = append(*q, task)
+}
+
+func (q *renewalQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	task := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return task
+}
+
+// 固定数量协程组成的续期池
+type renewalPool struct {
+	rd    *redisDriver
+	mux   sync.Mutex
+	queue renewalQueue
+	wake  chan struct{}
+	quit  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup
+}
+
+func newRenewalPool(rd *redisDriver, size int) *renewalPool {
+	pool := &renewalPool{rd: rd, wake: make(chan struct{}, 1), quit: make(chan struct{})}
+	pool.wg.Add(size)
+	for i := 0; i < size; i++ {
+		go func() {
+			defer pool.wg.Done()
+			pool.work()
+		}()
+	}
+
+	return pool
+}
+
+// 加入续期任务，调用方需已对rd.renewals计数
+func (pool *renewalPool) add(st *lockState, lo *lockOptions) {
+	pool.push(&renewalTask{st: st, lo: lo, p: newRenewalProgress(lo), due: time.Now().Add(st.interval())})
+}
+
+func (pool *renewalPool) push(task *renewalTask) {
+	pool.mux.Lock()
+	heap.Push(&pool.queue, task)
+	first := task.index == 0
+	pool.mux.Unlock()
+
+	//新任务排在队首时唤醒一个等待中的协程重新计算等待时间
+	if first {
+		select {
+		case pool.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 距离队首任务到期的时长，已到期时返回0，队列为空时返回-1
+func (pool *renewalPool) pending(now time.Time) time.Duration {
+	pool.mux.Lock()
+	defer pool.mux.Unlock()
+
+	if len(pool.queue) == 0 {
+		return -1
+	}
+	if wait := pool.queue[0].due.Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// 取出一个已到期的任务，没有时返回nil
+//
+// 限速导致任务积压时取出其中最接近过期的一个：堆中已到期任务的父节点必然也已到期，
+// 只需遍历以堆顶为根的已到期部分。
+func (pool *renewalPool) take(now time.Time) *renewalTask {
+	pool.mux.Lock()
+	defer pool.mux.Unlock()
+
+	q := pool.queue
+	if len(q) == 0 || q[0].due.After(now) {
+		return nil
+	}
+	if renewalThrottle == nil {
+		return heap.Pop(&pool.queue).(*renewalTask)
+	}
+
+	best := 0
+	stack := []int{1, 2}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i >= len(q) || q[i].due.After(now) {
+			continue
+		}
+		if q[i].expiry().Before(q[best].expiry()) {
+			best = i
+		}
+		stack = append(stack, 2*i+1, 2*i+2)
+	}
+
+	return heap.Remove(&pool.queue, best).(*renewalTask)
+}
+
+// 锁预计的过期时间
+func (task *renewalTask) expiry() time.Time {
+	ttl := lockTTL
+	if custom := task.st.ttl.Load(); custom > 0 {
+		ttl = time.Duration(custom)
+	}
+	return task.p.lastRenewed.Add(ttl)
+}
+
+func (pool *renewalPool) work() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		wait := pool.pending(time.Now())
+		if wait == 0 {
+			if !renewalThrottle.wait(pool.quit) {
+				return
+			}
+			if task := pool.take(time.Now()); task != nil {
+				pool.run(task)
+			}
+			continue
+		}
+
+		var expired <-chan time.Time
+		if wait >= 0 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+			expired = timer.C
+		}
+
+		select {
+		case <-expired:
+		case <-pool.wake:
+		case <-pool.quit:
+			return
+		}
+	}
+}
+
+// 执行一次到期的续期检查，未结束时重新加入队列
+func (pool *renewalPool) run(task *renewalTask) {
+	st, lo := task.st, task.lo
+
+	done, reason := false, error(nil)
+	select {
+	case <-st.cancel:
+		done = true
+	default:
+	}
+	if !done && lo.renewalCtx != nil {
+		if err := lo.renewalCtx.Err(); err != nil {
+			done, reason = true, err
+		}
+	}
+	if !done {
+		select {
+		case <-st.extended:
+			task.p.lastRenewed = time.Unix(0, st.lastExtended.Load())
+		default:
+		}
+		done, reason = pool.rd.renewTick(st, lo, task.p)
+	}
+
+	if done {
+		pool.rd.renewalEnded(st, lo, reason)
+		pool.rd.renewals.Done()
+		return
+	}
+
+	task.due = time.Now().Add(st.interval())
+	pool.push(task)
+}
+
+// 锁的续期间隔缩短后提前下次续期的时间，正在执行的任务重新入队时自然使用新的间隔
+func (pool *renewalPool) reschedule(st *lockState) {
+	pool.mux.Lock()
+	first := false
+	for _, task := range pool.queue {
+		if task.st != st {
+			continue
+		}
+		if due := time.Now().Add(st.interval()); due.Before(task.due) {
+			task.due = due
+			heap.Fix(&pool.queue, task.index)
+		}
+		first = task.index == 0
+		break
+	}
+	pool.mux.Unlock()
+
+	if first {
+		select {
+		case pool.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 续期是否已积压：队首的任务逾期超过一个续期间隔，或协程池已停止
+//
+// 积压时新获取的锁很可能来不及续期就过期，加锁应直接失败，见ErrRenewalSaturated。
+func (pool *renewalPool) saturated(now time.Time) bool {
+	select {
+	case <-pool.quit:
+		return true
+	default:
+	}
+
+	pool.mux.Lock()
+	defer pool.mux.Unlock()
+
+	if len(pool.queue) == 0 {
+		return false
+	}
+	head := pool.queue[0]
+	return now.Sub(head.due) > head.st.interval()
+}
+
+// 协程池模式下续期已积压时返回ErrRenewalSaturated
+func (rd *redisDriver) admitRenewal() error {
+	if renewalMode == RenewalNone {
+		return nil
+	}
+	if pool := rd.renewalPool(); pool != nil && pool.saturated(time.Now()) {
+		return ErrRenewalSaturated
+	}
+	return nil
+}
+
+// 停止所有工作协程并等待退出，队列中剩余的任务不再续期
+func (pool *renewalPool) stop() {
+	pool.once.Do(func() {
+		close(pool.quit)
+	})
+	pool.wg.Wait()
+}
@@ -0,0 +1,195 @@
+package corgi
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// 临时设置续期协程池的大小
+func withRenewalPoolSize(t testing.TB, n int) {
+	t.Helper()
+	prev := renewalPoolSize
+	SetRenewalPoolSize(n)
+	t.Cleanup(func() {
+		renewalPoolSize = prev
+	})
+}
+
+func TestRenewalPool(t *testing.T) {
+	withRenewalInterval(t, 20*time.Millisecond)
+	withRenewalPoolSize(t, 2)
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+
+	//先建立连接，避免把redis连接相关的协程计入
+	if err := rd.client.Ping(ctx).Err(); err != nil {
+		t.Fatal(err)
+	}
+	base := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		if _, err := rd.Acquire(ctx, fmt.Sprintf("pool-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := runtime.NumGoroutine() - base; n > 2 {
+		t.Fatalf("expected at most 2 renewal goroutines, got %d", n)
+	}
+
+	mr.FastForward(5 * time.Second)
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		if ttl := mr.TTL(redisKey(fmt.Sprintf("pool-%d", i))); ttl != lockTTL {
+			t.Fatalf("expected pool-%d to be renewed, ttl %s", i, ttl)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		rd.Unlock(ctx, fmt.Sprintf("pool-%d", i))
+	}
+	if err := waitContext(ctx, rd.renewals.Wait); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenewalRateLimit(t *testing.T) {
+	withRenewalInterval(t, 20*time.Millisecond)
+	withRenewalPoolSize(t, 1)
+	SetRenewalRateLimit(50)
+	t.Cleanup(func() { SetRenewalRateLimit(0) })
+	rd, _ := newTestDriver(t)
+	hook := &countingHook{}
+	rd.client.AddHook(hook)
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		if _, err := rd.Acquire(ctx, fmt.Sprintf("throttled-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := hook.n.Load()
+	time.Sleep(200 * time.Millisecond)
+
+	//不限速时约为10把锁*10次续期
+	if n := hook.n.Load() - before; n > 15 {
+		t.Fatalf("expected renewals to be throttled to ~10, saw %d commands", n)
+	}
+	for i := 0; i < 10; i++ {
+		rd.Unlock(ctx, fmt.Sprintf("throttled-%d", i))
+	}
+}
+
+func TestRenewalPoolTakeNearestExpiry(t *testing.T) {
+	SetRenewalRateLimit(1)
+	defer SetRenewalRateLimit(0)
+
+	now := time.Now()
+	task := func(due time.Duration, lastRenewed time.Duration, ttl time.Duration) *renewalTask {
+		st := newLockState(nil, "k", "k", "v")
+		st.ttl.Store(int64(ttl))
+		return &renewalTask{st: st, p: &renewalProgress{lastRenewed: now.Add(lastRenewed)}, due: now.Add(due)}
+	}
+	pool := &renewalPool{wake: make(chan struct{}, 1)}
+	long := task(-100*time.Millisecond, -time.Second, 10*time.Second)
+	short := task(-50*time.Millisecond, -100*time.Millisecond, time.Second)
+	pool.push(long)
+	pool.push(short)
+	pool.push(task(time.Second, 0, 100*time.Millisecond))
+
+	if got := pool.take(now); got != short {
+		t.Fatal("expected the overdue task nearest to expiry first")
+	}
+	if got := pool.take(now); got != long {
+		t.Fatal("expected the remaining overdue task")
+	}
+	if got := pool.take(now); got != nil {
+		t.Fatal("expected no overdue task")
+	}
+}
+
+// 对比三种续期方式：每把锁一个协程、单个共享协程、固定大小的协程池
+//
+// goroutines为加锁后新增的协程数(含并发续期多建立的redis连接)，lag-ms为采样时所有锁距上次续期的最长时间
+func BenchmarkRenewalStrategies(b *testing.B) {
+	const locks = 200
+	interval := 10 * time.Millisecond
+
+	for _, bc := range []struct {
+		name string
+		size int
+	}{
+		{"per-lock", 0},
+		{"shared", 1},
+		{"pool-4", 4},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			withRenewalInterval(b, interval)
+			withRenewalPoolSize(b, bc.size)
+			rd, _ := newTestDriver(b)
+			ctx := context.Background()
+			b.Cleanup(func() { rd.flushHeldLocks(context.Background()) })
+			if err := rd.client.Ping(ctx).Err(); err != nil {
+				b.Fatal(err)
+			}
+
+			base := runtime.NumGoroutine()
+			states := make([]*lockState, 0, locks)
+			for i := 0; i < locks; i++ {
+				key := fmt.Sprintf("bench-%d", i)
+				if _, err := rd.Acquire(ctx, key); err != nil {
+					b.Fatal(err)
+				}
+				st, _ := rd.states.load(redisKey(key))
+				states = append(states, st)
+			}
+			goroutines := runtime.NumGoroutine() - base
+
+			b.ResetTimer()
+			var maxLag time.Duration
+			for i := 0; i < b.N; i++ {
+				time.Sleep(interval)
+				now := time.Now()
+				for _, st := range states {
+					if lag := now.Sub(time.Unix(0, st.lastRenewed.Load())); lag > maxLag {
+						maxLag = lag
+					}
+				}
+			}
+			b.StopTimer()
+
+			b.ReportMetric(float64(goroutines), "goroutines")
+			b.ReportMetric(float64(maxLag)/float64(time.Millisecond), "lag-ms")
+
+		})
+	}
+}
+
+func TestRenewalPoolSaturated(t *testing.T) {
+	rd, _ := newTestDriver(t)
+
+	now := time.Now()
+	st := newLockState(nil, "k", "k", "v")
+	st.ttl.Store(int64(lockTTL))
+	pool := &renewalPool{rd: rd, wake: make(chan struct{}, 1), quit: make(chan struct{})}
+	if pool.saturated(now) {
+		t.Fatal("empty pool should not be saturated")
+	}
+	pool.push(&renewalTask{st: st, p: &renewalProgress{}, due: now.Add(-st.interval() / 2)})
+	if pool.saturated(now) {
+		t.Fatal("slightly overdue pool should not be saturated")
+	}
+	pool.push(&renewalTask{st: st, p: &renewalProgress{}, due: now.Add(-2 * st.interval())})
+	if !pool.saturated(now) {
+		t.Fatal("pool overdue by more than an interval should be saturated")
+	}
+
+	rd.poolOnce.Do(func() { rd.pool = pool })
+	if _, err := rd.Acquire(context.Background(), "saturated"); err != ErrRenewalSaturated {
+		t.Fatalf("expected ErrRenewalSaturated, got %v", err)
+	}
+	if ok, _ := rd.IsLocked(context.Background(), "saturated"); ok {
+		t.Fatal("saturated acquisition should not write the lock")
+	}
+}
@@ -0,0 +1,70 @@
+package corgi
+
+import (
+	"context"
+	"fmt"
+)
+
+// ValueProvider 生成加锁时写入redis的值，用于完全自定义值的格式(如protobuf、加密后的持有者信息)
+type ValueProvider interface {
+	// Value 返回加锁时写入key的值，值在每次加锁时都应是唯一的
+	Value(ctx context.Context, key string) (string, error)
+	// Token 从值中取出token，用于Lock.Token及按token查找值(SafeExtend)
+	Token(value string) string
+}
+
+var valueProvider ValueProvider = defaultValueProvider{}
+
+// SetValueProvider 设置生成锁的值的ValueProvider，为nil时恢复默认
+//
+// 释放、续期始终按完整的值比较，与值的格式无关；LockInfo、Owner、Inspect等依赖默认格式的功能
+// 对无法解析的值不可用。自定义实现中可通过DefaultValueProvider生成默认的值再加以包装。
+func SetValueProvider(p ValueProvider) {
+	if p == nil {
+		p = defaultValueProvider{}
+	}
+	valueProvider = p
+}
+
+// DefaultValueProvider 默认的ValueProvider，按SetValueEncoding的编码写入持有者信息
+//
+// 在加锁过程中调用时使用加锁选项(WithToken、WithTerm、WithReason等)及SetOwnerEnrichers的标签，
+// 否则生成仅包含随机token的持有者信息。
+func DefaultValueProvider() ValueProvider {
+	return defaultValueProvider{}
+}
+
+type defaultValueProvider struct{}
+
+type lockInfoContextKey struct{}
+
+func (defaultValueProvider) Value(ctx context.Context, key string) (string, error) {
+	info, ok := ctx.Value(lockInfoContextKey{}).(LockInfo)
+	if !ok {
+		info = newLockInfo(newToken())
+		info.Labels = ownerLabels(ctx)
+	}
+	return encodeValue(info), nil
+}
+
+func (defaultValueProvider) Token(value string) string {
+	info, _ := parseLockerValue(value)
+	return info.Token
+}
+
+// 由ValueProvider生成加锁写入的值及其token，info为默认格式使用的持有者信息
+func provideValue(ctx context.Context, key string, info LockInfo) (string, string, error) {
+	value, err := valueProvider.Value(context.WithValue(ctx, lockInfoContextKey{}, info), key)
+	if err != nil {
+		return "", "", err
+	}
+	if value == "" {
+		return "", "", fmt.Errorf("corgi: value provider returned an empty value for %q", key)
+	}
+	return value, valueProvider.Token(value), nil
+}
+
+// 值中的token
+func valueToken(value string) string {
+	return valueProvider.Token(value)
+}
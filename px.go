@@ -0,0 +1,36 @@
+package corgi
+
+import (
+	"context"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// 可执行单个命令的客户端、连接或pipeline
+type processor interface {
+	Process(ctx context.Context, cmd redisLib.Cmder) error
+}
+
+// 执行SET key value PX <毫秒> NX
+//
+// TTL始终以毫秒(PX)发送：go-redis的SetNX在TTL为整秒时改用EX，这里不依赖其取整规则，
+// 任何粒度的TTL都按毫秒精度生效。
+func setNX(ctx context.Context, c processor, key string, value string, ttl time.Duration) *redisLib.StatusCmd {
+	cmd := redisLib.NewStatusCmd(ctx, "set", key, value, "px", ttl.Milliseconds(), "nx")
+	_ = c.Process(ctx, cmd)
+	return cmd
+}
+
+// SET NX的结果，未写入(键已存在)时返回false
+func setNXResult(cmd *redisLib.StatusCmd) (bool, error) {
+	err := cmd.Err()
+	if err == redisLib.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
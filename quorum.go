@@ -0,0 +1,236 @@
+package corgi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrQuorumLost 仍持有锁的后端数量低于quorum
+var ErrQuorumLost = errors.New("corgi: lock quorum lost")
+
+// BasicLocker Locker中按key获取、释放锁的子集，Locker及QuorumLocker均实现了该接口
+//
+// 只依赖获取与释放的调用方(如middleware)可接受BasicLocker，以便替换为QuorumLocker等组合实现。
+type BasicLocker interface {
+	// TryLock 尝试获取锁
+	TryLock(ctx context.Context, key string, opts ...LockOption) bool
+	// TryLockE 尝试获取锁，并返回失败原因
+	TryLockE(ctx context.Context, key string, opts ...LockOption) (bool, error)
+	// Unlock 释放锁，可安全地重复调用
+	Unlock(ctx context.Context, key string, opts ...LockOption) bool
+	// UnlockE 释放锁，返回释放结果及删除前观察到的剩余TTL
+	UnlockE(ctx context.Context, key string, opts ...LockOption) (UnlockResult, time.Duration, error)
+}
+
+var (
+	_ BasicLocker = Locker(nil)
+	_ BasicLocker = (*QuorumLocker)(nil)
+)
+
+// QuorumLocker 在多个相互独立的Locker(如各地域独立部署的redis)上获取同一把锁，
+// 多数(quorum个)后端获取成功才视为持有，见NewQuorumLocker
+//
+// 实现了BasicLocker；其余Locker的功能(阻塞等待、按key续期、读写锁等)不提供组合语义，需使用Acquire返回的句柄。
+type QuorumLocker struct {
+	lockers []Locker
+	quorum  int
+
+	mux  sync.Mutex
+	held map[string]*QuorumLock
+}
+
+// NewQuorumLocker 组合多个Locker，用于多地域双活等部署下的互斥
+//
+// 与直接操作redis节点的Redlock不同，各后端可以是任意Locker实现(包括本包创建的独立实例或装饰器)，
+// 续期由各后端自行完成。quorum不大于0时取多数(len/2+1)，大于后端数量时永远无法获取，返回ErrInvalidOption。
+func NewQuorumLocker(lockers []Locker, quorum int) (*QuorumLocker, error) {
+	if quorum > len(lockers) {
+		return nil, fmt.Errorf("%w: quorum %d exceeds %d lockers", ErrInvalidOption, quorum, len(lockers))
+	}
+	if quorum <= 0 {
+		quorum = len(lockers)/2 + 1
+	}
+	return &QuorumLocker{lockers: lockers, quorum: quorum, held: make(map[string]*QuorumLock)}, nil
+}
+
+// QuorumLock 通过QuorumLocker获取的锁的句柄
+type QuorumLock struct {
+	key    string
+	locks  []*Lock
+	quorum int
+	lost   chan error
+	done   chan struct{}
+	once   sync.Once
+
+	mux  sync.Mutex
+	held int
+}
+
+// Acquire 并发地在所有后端上获取锁，成功数达到quorum时返回句柄
+//
+// 未达到quorum时释放已在部分后端获取的锁：全部因锁被占用而失败时返回ErrNotAcquired，
+// 否则返回包装了其中一个错误的错误。
+func (q *QuorumLocker) Acquire(ctx context.Context, key string, opts ...LockOption) (*QuorumLock, error) {
+	locks := make([]*Lock, len(q.lockers))
+	errs := make([]error, len(q.lockers))
+
+	var wg sync.WaitGroup
+	for i, l := range q.lockers {
+		wg.Add(1)
+		go func(i int, l Locker) {
+			defer wg.Done()
+			locks[i], errs[i] = l.Acquire(ctx, key, opts...)
+		}(i, l)
+	}
+	wg.Wait()
+
+	var (
+		acquired []*Lock
+		firstErr error
+	)
+	for i, l := range locks {
+		if errs[i] == nil {
+			acquired = append(acquired, l)
+		} else if errs[i] != ErrNotAcquired && firstErr == nil {
+			firstErr = errs[i]
+		}
+	}
+
+	if len(acquired) < q.quorum {
+		//ctx可能已结束，回滚使用独立的超时
+		rollbackCtx, cancel := context.WithTimeout(context.Background(), redisExecuteTimeout)
+		defer cancel()
+		unlockAll(rollbackCtx, acquired)
+		if firstErr != nil {
+			return nil, fmt.Errorf("corgi: lock quorum not reached (%d/%d): %w", len(acquired), q.quorum, firstErr)
+		}
+		return nil, ErrNotAcquired
+	}
+
+	ql := &QuorumLock{key: key, locks: acquired, quorum: q.quorum, held: len(acquired), lost: make(chan error, 1), done: make(chan struct{})}
+	for _, l := range acquired {
+		go ql.watch(l)
+	}
+
+	return ql, nil
+}
+
+func (q *QuorumLocker) TryLock(ctx context.Context, key string, opts ...LockOption) bool {
+	ok, _ := q.TryLockE(ctx, key, opts...)
+	return ok
+}
+
+// TryLockE 通过Acquire获取锁，句柄按key保存在本地，由Unlock/UnlockE释放
+func (q *QuorumLocker) TryLockE(ctx context.Context, key string, opts ...LockOption) (bool, error) {
+	ql, err := q.Acquire(ctx, key, opts...)
+	if err == ErrNotAcquired {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	q.mux.Lock()
+	q.held[key] = ql
+	q.mux.Unlock()
+	return true, nil
+}
+
+func (q *QuorumLocker) Unlock(ctx context.Context, key string, opts ...LockOption) bool {
+	res, _, err := q.UnlockE(ctx, key, opts...)
+	return err == nil && res == UnlockReleased
+}
+
+// UnlockE 释放TryLockE获取的锁，释放成功的后端数量低于quorum时返回UnlockNotHeld
+//
+// 各后端按获取时的选项释放，opts被忽略；本地未持有时返回UnlockMissing。remaining始终为0。
+func (q *QuorumLocker) UnlockE(ctx context.Context, key string, _ ...LockOption) (UnlockResult, time.Duration, error) {
+	q.mux.Lock()
+	ql, ok := q.held[key]
+	delete(q.held, key)
+	q.mux.Unlock()
+	if !ok {
+		return UnlockMissing, 0, nil
+	}
+
+	if err := ql.Unlock(ctx); err != nil {
+		return UnlockNotHeld, 0, nil
+	}
+	return UnlockReleased, 0, nil
+}
+
+// 某个后端的锁丢失后减少持有数，低于quorum时通知锁丢失
+func (ql *QuorumLock) watch(l *Lock) {
+	select {
+	case <-l.Lost():
+	case <-ql.done:
+		return
+	}
+
+	ql.mux.Lock()
+	ql.held--
+	below := ql.held < ql.quorum
+	ql.mux.Unlock()
+
+	if below {
+		select {
+		case ql.lost <- ErrQuorumLost:
+		default:
+		}
+	}
+}
+
+// 并发释放一组锁，返回释放成功的数量
+func unlockAll(ctx context.Context, locks []*Lock) int {
+	var (
+		wg       sync.WaitGroup
+		mux      sync.Mutex
+		released int
+	)
+	for _, l := range locks {
+		wg.Add(1)
+		go func(l *Lock) {
+			defer wg.Done()
+			if l.Unlock(ctx) == nil {
+				mux.Lock()
+				released++
+				mux.Unlock()
+			}
+		}(l)
+	}
+	wg.Wait()
+
+	return released
+}
+
+// Key 锁的key
+func (ql *QuorumLock) Key() string {
+	return ql.key
+}
+
+// Held 当前仍持有锁的后端数量
+func (ql *QuorumLock) Held() int {
+	ql.mux.Lock()
+	defer ql.mux.Unlock()
+	return ql.held
+}
+
+// Lost 仍持有锁的后端数量低于quorum时收到ErrQuorumLost
+func (ql *QuorumLock) Lost() <-chan error {
+	return ql.lost
+}
+
+// Unlock 在所有后端上释放锁，释放成功的数量低于quorum时返回ErrNotHeld
+func (ql *QuorumLock) Unlock(ctx context.Context) error {
+	ql.once.Do(func() {
+		close(ql.done)
+	})
+
+	if unlockAll(ctx, ql.locks) < ql.quorum {
+		return ErrNotHeld
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package corgi
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestQuorumLocker(t *testing.T) {
+	withRenewalInterval(t, 10*time.Millisecond)
+	var (
+		lockers []Locker
+		servers []*miniredis.Miniredis
+	)
+	for i := 0; i < 3; i++ {
+		rd, mr := newTestDriver(t)
+		lockers = append(lockers, rd)
+		servers = append(servers, mr)
+	}
+	ctx := context.Background()
+	if _, err := NewQuorumLocker(lockers, 4); !errors.Is(err, ErrInvalidOption) {
+		t.Fatalf("expected ErrInvalidOption for an unreachable quorum, got %v", err)
+	}
+	q, err := NewQuorumLocker(lockers, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//两个后端已被占用，未达到quorum时释放已获取的部分
+	servers[0].Set(redisKey("region"), "other")
+	servers[1].Set(redisKey("region"), "other")
+	if _, err := q.Acquire(ctx, "region"); err != ErrNotAcquired {
+		t.Fatalf("expected ErrNotAcquired, got %v", err)
+	}
+	if servers[2].Exists(redisKey("region")) {
+		t.Fatal("expected partial acquisition to be released")
+	}
+	servers[0].Del(redisKey("region"))
+
+	ql, err := q.Acquire(ctx, "region")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ql.Held() != 2 {
+		t.Fatalf("expected 2 backends held, got %d", ql.Held())
+	}
+
+	//丢失一个后端后低于quorum
+	servers[0].Set(redisKey("region"), "stolen")
+	select {
+	case err := <-ql.Lost():
+		if err != ErrQuorumLost {
+			t.Fatalf("expected ErrQuorumLost, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected quorum to be lost")
+	}
+	if err = ql.Unlock(ctx); err != ErrNotHeld {
+		t.Fatalf("expected ErrNotHeld, got %v", err)
+	}
+}
+
+func TestQuorumLockerByKey(t *testing.T) {
+	var (
+		lockers []Locker
+		servers []*miniredis.Miniredis
+	)
+	for i := 0; i < 3; i++ {
+		rd, mr := newTestDriver(t)
+		lockers = append(lockers, rd)
+		servers = append(servers, mr)
+	}
+	ctx := context.Background()
+	q, err := NewQuorumLocker(lockers, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := q.TryLockE(ctx, "by-key"); !ok || err != nil {
+		t.Fatalf("expected quorum to be reached, got %v %v", ok, err)
+	}
+	if q.TryLock(ctx, "by-key") {
+		t.Fatal("expected the held key to be rejected")
+	}
+	if !q.Unlock(ctx, "by-key") {
+		t.Fatal("expected unlock to release the quorum")
+	}
+	for i, mr := range servers {
+		if mr.Exists(redisKey("by-key")) {
+			t.Fatalf("expected backend %d to be released", i)
+		}
+	}
+	if res, _, err := q.UnlockE(ctx, "by-key"); res != UnlockMissing || err != nil {
+		t.Fatalf("expected UnlockMissing for a released key, got %v %v", res, err)
+	}
+
+	//释放时仅剩一个后端仍持有，低于quorum
+	if !q.TryLock(ctx, "by-key") {
+		t.Fatal("expected quorum to be reached")
+	}
+	servers[0].Set(redisKey("by-key"), "other")
+	servers[1].Set(redisKey("by-key"), "other")
+	if res, _, err := q.UnlockE(ctx, "by-key"); res != UnlockNotHeld || err != nil {
+		t.Fatalf("expected UnlockNotHeld, got %v %v", res, err)
+	}
+}
@@ -0,0 +1,162 @@
+package corgi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// ErrRateLimited 命令数量超过限速，加锁直接失败
+var ErrRateLimited = errors.New("corgi: redis command rate limited")
+
+// 全局的命令限速，为nil时不限速
+var commandLimit atomic.Pointer[commandLimiter]
+
+type commandLimiter struct {
+	throttle *throttle
+	maxWait  time.Duration
+}
+
+// SetCommandRateLimit 限制本进程每秒发往redis的命令数量(包括加锁、释放、续期、查询等所有命令)，perSecond不大于0时不限速(默认)
+//
+// 超过限速的命令排队等待；加锁命令的排队时间超过maxWait时不再等待，直接返回ErrRateLimited，
+// 避免事故期间大量加锁请求压垮共享的redis。释放、续期等命令只排队不失败(仍受各自ctx的约束)，
+// 以免因限速而丢失已持有的锁。pipeline按其中的命令数量计算。限速由全局实例与NewLocker/Register创建的实例
+// (包括其按DB创建的客户端)共享。
+func SetCommandRateLimit(perSecond int, maxWait time.Duration) {
+	if perSecond <= 0 {
+		commandLimit.Store(nil)
+		return
+	}
+	commandLimit.Store(&commandLimiter{throttle: &throttle{interval: time.Second / time.Duration(perSecond)}, maxWait: maxWait})
+}
+
+// 是否统计各命令的速率
+var commandStatsEnabled atomic.Bool
+
+// SetCommandStats 设置是否统计本包创建或设置的所有客户端上各类命令的速率，见CommandStats，默认不开启
+func SetCommandStats(enabled bool) {
+	commandStatsEnabled.Store(enabled)
+}
+
+// CommandStats 最近10秒内各类命令(小写，如"evalsha"、"set")的平均每秒数量，需先开启SetCommandStats
+func CommandStats() map[string]float64 {
+	return commandCounters.rates(time.Now())
+}
+
+// 统计速率的窗口(秒)
+const commandStatsWindow = 10
+
+type commandCounter struct {
+	secs   [commandStatsWindow + 1]int64
+	counts [commandStatsWindow + 1]uint64
+}
+
+type commandStats struct {
+	mux      sync.Mutex
+	counters map[string]*commandCounter
+}
+
+var commandCounters = &commandStats{counters: make(map[string]*commandCounter)}
+
+func (s *commandStats) record(now time.Time, cmds []redisLib.Cmder) {
+	sec := now.Unix()
+	i := sec % int64(len(commandCounter{}.secs))
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, cmd := range cmds {
+		c, ok := s.counters[cmd.Name()]
+		if !ok {
+			c = &commandCounter{}
+			s.counters[cmd.Name()] = c
+		}
+		if c.secs[i] != sec {
+			c.secs[i], c.counts[i] = sec, 0
+		}
+		c.counts[i]++
+	}
+}
+
+// 按最近commandStatsWindow个完整的秒计算速率
+func (s *commandStats) rates(now time.Time) map[string]float64 {
+	sec := now.Unix()
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	rates := make(map[string]float64, len(s.counters))
+	for name, c := range s.counters {
+		var total uint64
+		for i, at := range c.secs {
+			if at < sec && at >= sec-commandStatsWindow {
+				total += c.counts[i]
+			}
+		}
+		if total > 0 {
+			rates[name] = float64(total) / commandStatsWindow
+		}
+	}
+	return rates
+}
+
+type acquiringContextKey struct{}
+
+// 标记加锁的命令，限速时可直接失败
+func withAcquiring(ctx context.Context) context.Context {
+	if commandLimit.Load() == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, acquiringContextKey{}, true)
+}
+
+// 限速及统计命令的Hook，安装在全局实例的客户端上
+type commandHook struct{}
+
+var _ redisLib.Hook = commandHook{}
+
+func (commandHook) admit(ctx context.Context, cmds []redisLib.Cmder) error {
+	if commandStatsEnabled.Load() {
+		commandCounters.record(time.Now(), cmds)
+	}
+
+	limiter := commandLimit.Load()
+	if limiter == nil {
+		return nil
+	}
+	maxWait := time.Duration(-1)
+	if acquiring, _ := ctx.Value(acquiringContextKey{}).(bool); acquiring {
+		maxWait = limiter.maxWait
+	}
+	wait, ok := limiter.throttle.reserveWithin(len(cmds), maxWait)
+	if !ok {
+		return ErrRateLimited
+	}
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h commandHook) BeforeProcess(ctx context.Context, cmd redisLib.Cmder) (context.Context, error) {
+	return ctx, h.admit(ctx, []redisLib.Cmder{cmd})
+}
+
+func (commandHook) AfterProcess(context.Context, redisLib.Cmder) error { return nil }
+
+func (h commandHook) BeforeProcessPipeline(ctx context.Context, cmds []redisLib.Cmder) (context.Context, error) {
+	return ctx, h.admit(ctx, cmds)
+}
+
+func (commandHook) AfterProcessPipeline(context.Context, []redisLib.Cmder) error { return nil }
@@ -2,9 +2,10 @@ package corgi
 
 import (
 	"context"
-	"fmt"
-	"os"
+	"errors"
+	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	redisLib "github.com/go-redis/redis/v8"
@@ -12,48 +13,137 @@ import (
 
 type Locker interface {
 	// TryLock 尝试获取锁
-	TryLock(ctx context.Context, key string) bool
-	// Unlock 释放锁
-	Unlock(ctx context.Context, key string) bool
+	TryLock(ctx context.Context, key string, opts ...LockOption) bool
+	// TryLockE 尝试获取锁，并返回失败原因
+	TryLockE(ctx context.Context, key string, opts ...LockOption) (bool, error)
+	// TryLockAs 使用调用方提供的token尝试获取锁
+	TryLockAs(ctx context.Context, key string, token string, opts ...LockOption) (bool, error)
+	// Acquire 尝试获取锁，成功时返回锁的句柄
+	Acquire(ctx context.Context, key string, opts ...LockOption) (*Lock, error)
+	// SafeExtend 剩余TTL不低于floor时才续期为newTTL，否则视为锁已丢失
+	SafeExtend(ctx context.Context, key string, token string, floor time.Duration, newTTL time.Duration) error
+	// GroupSemaphore 创建分组配额，组内成员共享limit个名额
+	GroupSemaphore(group string, limit int) *Semaphore
+	// NewRWLock 创建key上的读写锁
+	NewRWLock(key string) *RWLock
+	// SemaphoreHolders 列出分组配额当前的持有者
+	SemaphoreHolders(ctx context.Context, group string) ([]HolderInfo, error)
+	// Owner 查询锁的持有者信息，锁不存在时返回最近登记意向(Advise)的进程，都不存在时返回ErrNotHeld
+	Owner(ctx context.Context, key string) (LockInfo, error)
+	// IsLocked 锁当前是否被(任意进程)持有
+	IsLocked(ctx context.Context, key string) (bool, error)
+	// Owners 批量查询一组锁的持有者信息，不存在的锁不会出现在结果中
+	Owners(ctx context.Context, keys []string) (map[string]LockInfo, error)
+	// Waiters 列出最近在key上获取失败的进程(WithWaiterTracking)
+	Waiters(ctx context.Context, key string) ([]LockInfo, error)
+	// Advise 登记本进程正在处理key的意向(软锁)，不互斥，ttl后自动失效
+	Advise(ctx context.Context, key string, ttl time.Duration) error
+	// Peers 列出当前在key上登记了意向的进程
+	Peers(ctx context.Context, key string) ([]LockInfo, error)
+	// Lock 阻塞获取锁，直到成功或ctx结束
+	Lock(ctx context.Context, key string, opts ...LockOption) (*Lock, error)
+	// LockFair 按到达顺序阻塞获取锁，直到成功或ctx结束
+	LockFair(ctx context.Context, key string, opts ...LockOption) (*Lock, error)
+	// LockUntil 获取锁并持续续期到deadline，之后任其自然过期
+	LockUntil(ctx context.Context, key string, deadline time.Time, opts ...LockOption) error
+	// AcquireUntil 同LockUntil，返回可提前释放的句柄
+	AcquireUntil(ctx context.Context, key string, deadline time.Time, opts ...LockOption) (*Lock, error)
+	// AcquireInPipeline 把加锁加入调用方的pipeline/事务，执行后通过Commit确认并启动续期
+	AcquireInPipeline(ctx context.Context, pipe redisLib.Pipeliner, key string, opts ...LockOption) (*PendingLock, error)
+	// Restore 根据保存的key和value重建锁的句柄
+	Restore(key string, value string, opts ...LockOption) *Lock
+	// TryLockWithTerm 按任期尝试获取锁，锁不存在或已存储的任期不大于term时获取成功
+	TryLockWithTerm(ctx context.Context, key string, term int64, opts ...LockOption) (bool, error)
+	// TryLockIf condKey的值等于expected时才尝试获取锁，条件不满足时返回ErrConditionNotMet
+	TryLockIf(ctx context.Context, key string, condKey string, expected string, opts ...LockOption) (bool, error)
+	// TryLockStealStale 尝试获取锁，持有者的加锁时间早于olderThan之前时接管该锁
+	TryLockStealStale(ctx context.Context, key string, olderThan time.Duration, opts ...LockOption) (StealResult, error)
+	// TryLockAndIncr 尝试获取锁，仅在获取成功时原子地递增counterKey并返回新值
+	TryLockAndIncr(ctx context.Context, lockKey string, counterKey string, opts ...LockOption) (bool, int64, error)
+	// TryLockSome 尝试获取一组锁，返回其中获取成功的key
+	TryLockSome(ctx context.Context, keys []string, opts ...LockOption) ([]string, error)
+	// AcquireWithData 获取锁，成功时原子地写入关联数据
+	AcquireWithData(ctx context.Context, key string, data map[string]string, ttl time.Duration) (bool, error)
+	// AcquireData 读取锁的关联数据
+	AcquireData(ctx context.Context, key string) (map[string]string, error)
+	// Unlock 释放锁，可安全地重复调用，重复调用返回false且不会影响其他持有者的锁
+	Unlock(ctx context.Context, key string, opts ...LockOption) bool
+	// UnlockE 释放锁，返回释放结果及删除前观察到的剩余TTL
+	UnlockE(ctx context.Context, key string, opts ...LockOption) (UnlockResult, time.Duration, error)
+	// UnlockMulti 按获取顺序的逆序释放一组锁，返回释放成功的key
+	UnlockMulti(ctx context.Context, keys []string, opts ...LockOption) []string
+	// UnlockAsync 立即停止自动续期并在后台释放锁，结果通过返回的通道送达
+	UnlockAsync(ctx context.Context, key string, opts ...LockOption) <-chan error
+	// Rename 将本进程持有的锁原子地转移到新的key
+	Rename(ctx context.Context, oldKey string, newKey string, opts ...LockOption) (bool, error)
+	// Extend 手动续期本进程持有的锁
+	Extend(ctx context.Context, key string, opts ...LockOption) bool
 }
 
 type redisDriver struct {
 	client        *redisLib.Client
 	clusterClient *redisLib.ClusterClient
+	sentinelAddrs []string
+
+	states      *stateListeners
+	contention  *contentionTracker
+	order       *orderTracker
+	gates       *localGates
+	noScripting atomic.Bool
+	//服务端版本(string)，见ServerVersion
+	version atomic.Value
+
+	dbMux     sync.Mutex
+	dbClients map[int]*redisLib.Client
+
+	lifecycle sync.RWMutex
+	closed    bool
+	inflight  sync.WaitGroup
+	renewals  sync.WaitGroup
+	pool      *renewalPool
+	poolOnce  sync.Once
+
+	//未设置redis连接时TryLock只输出一次错误日志
+	unconfiguredOnce sync.Once
 }
 
 var _ Locker = (*redisDriver)(nil)
 
 var (
-	lockDriver  = &redisDriver{}
+	lockDriver  = newDriver()
 	pingTimeout = time.Second * 3
 	doOnce      = &sync.Once{}
 )
 
+func newDriver() *redisDriver {
+	return &redisDriver{states: newStateListeners(stateShardCount), contention: newContentionTracker(), order: newOrderTracker(), gates: newLocalGates()}
+}
+
 // SetRedisProviderStandalone 设置redis连接配置(standalone)
-func SetRedisProviderStandalone(opt *redisLib.Options) {
+func SetRedisProviderStandalone(opt *redisLib.Options, opts ...ProviderOption) {
 	doOnce.Do(func() {
-		initClient(opt)
+		initClient(opt, newProviderOptions(opts))
 	})
 }
 
 // SetRedisProviderCluster 设置redis连接配置(cluster)
-func SetRedisProviderCluster(opt *redisLib.ClusterOptions) {
+func SetRedisProviderCluster(opt *redisLib.ClusterOptions, opts ...ProviderOption) {
 	doOnce.Do(func() {
-		initClusterClient(opt)
+		initClusterClient(opt, newProviderOptions(opts))
 	})
 }
 
 // SetRedisProviderFailOver 设置redis连接配置(fail-over)
-func SetRedisProviderFailOver(opt *redisLib.FailoverOptions) {
+func SetRedisProviderFailOver(opt *redisLib.FailoverOptions, opts ...ProviderOption) {
 	doOnce.Do(func() {
-		initFailOverClient(opt)
+		initFailOverClient(opt, newProviderOptions(opts))
 	})
 }
 
 // SetRedisProviderClient 设置redis连接实例(单实例)
 func SetRedisProviderClient(client *redisLib.Client) {
 	doOnce.Do(func() {
+		applyRedisHooks(client)
 		lockDriver.client = client
 	})
 }
@@ -61,59 +151,111 @@ func SetRedisProviderClient(client *redisLib.Client) {
 // SetRedisProviderClusterClient 设置redis连接实例(cluster集群)
 func SetRedisProviderClusterClient(client *redisLib.ClusterClient) {
 	doOnce.Do(func() {
+		applyRedisHooks(client)
 		lockDriver.clusterClient = client
 	})
 }
 
-func initClient(opt *redisLib.Options) {
-	rdb := redisLib.NewClient(opt)
+// AddRedisHook 在当前使用的redis客户端上注册go-redis的Hook，用于接入链路追踪、指标等(如redisotel)
+//
+// 在设置redis连接之前注册的Hook会在初始化时安装，之后按需创建的客户端(如WithDB)也会安装。
+func AddRedisHook(hook redisLib.Hook) {
+	redisHooks = append(redisHooks, hook)
+	if c := lockDriver.cmdable(); c != nil {
+		c.AddHook(hook)
+	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
-	err := rdb.Ping(ctx).Err()
+var redisHooks []redisLib.Hook
+
+// 全局实例的客户端安装命令限速/统计及AddRedisHook注册的Hook，其他实例的客户端只安装前者
+func applyRedisHooks(c redisLib.UniversalClient) {
+	c.AddHook(commandHook{})
+	for _, hook := range redisHooks {
+		c.AddHook(hook)
+	}
+}
+
+func initClient(opt *redisLib.Options, po *providerOptions) {
+	rdb, err := dialClient(opt, po)
 	if err != nil {
 		panic(err)
 	}
-	cancel()
+	applyRedisHooks(rdb)
 
 	lockDriver.client = rdb
+	_, _ = lockDriver.detectVersion(context.Background())
 }
 
-func initClusterClient(opt *redisLib.ClusterOptions) {
+// 创建客户端并确认可以连通
+func dialClient(opt *redisLib.Options, po *providerOptions) (*redisLib.Client, error) {
+	if po.tlsConfig != nil {
+		o := *opt
+		o.TLSConfig = po.tlsConfig
+		opt = &o
+	}
+	rdb := redisLib.NewClient(opt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		_ = rdb.Close()
+		return nil, pingError(err)
+	}
+
+	return rdb, nil
+}
+
+func initClusterClient(opt *redisLib.ClusterOptions, po *providerOptions) {
+	if po.tlsConfig != nil {
+		o := *opt
+		o.TLSConfig = po.tlsConfig
+		opt = &o
+	}
 	rdb := redisLib.NewClusterClient(opt)
+	applyRedisHooks(rdb)
 
 	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
 	err := rdb.Ping(ctx).Err()
+	if err != nil {
+		err = pingError(err)
+	} else if clusterDeepCheck {
+		err = pingClusterMasters(ctx, rdb)
+	}
 	if err != nil {
 		panic(err)
 	}
 	cancel()
 
 	lockDriver.clusterClient = rdb
+	_, _ = lockDriver.detectVersion(context.Background())
 }
 
-func initFailOverClient(opt *redisLib.FailoverOptions) {
+func initFailOverClient(opt *redisLib.FailoverOptions, po *providerOptions) {
+	if po.tlsConfig != nil {
+		o := *opt
+		o.TLSConfig = po.tlsConfig
+		opt = &o
+	}
 	rdb := redisLib.NewFailoverClient(opt)
+	applyRedisHooks(rdb)
 
 	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
 	err := rdb.Ping(ctx).Err()
 	if err != nil {
-		panic(err)
+		panic(pingError(err))
 	}
 	cancel()
 
 	lockDriver.client = rdb
-}
-
-type stateListeners struct {
-	mux       *sync.Mutex
-	listeners map[string]chan struct{}
+	lockDriver.sentinelAddrs = opt.SentinelAddrs
+	_, _ = lockDriver.detectVersion(context.Background())
 }
 
 var (
 	lockTTL              = time.Second * 10
 	redisExecuteTimeout  = time.Second * 3
 	renewalCheckInterval = time.Second * 1
-	states               = &stateListeners{mux: &sync.Mutex{}, listeners: make(map[string]chan struct{})}
 )
 
 // Wakeup 启动
@@ -121,7 +263,9 @@ func Wakeup() Locker {
 	return lockDriver
 }
 
-// Asleep 释放redis连接
+// Asleep 立即释放redis连接，不等待进行中的操作，也不释放持有的锁
+//
+// 需要优雅关闭时请使用Shutdown
 func Asleep() {
 	if lockDriver.client != nil {
 		_ = lockDriver.client.Close()
@@ -131,112 +275,274 @@ func Asleep() {
 	}
 }
 
-func (rd *redisDriver) TryLock(ctx context.Context, key string) bool {
-	if rd.client == nil && rd.clusterClient == nil {
-		return false
+// TryLock 尝试获取锁，失败原因可通过TryLockE获取
+//
+// 未设置redis连接时同样返回false，但会(每个实例一次)输出错误日志，避免被误当作锁被占用而静默地继续执行。
+func (rd *redisDriver) TryLock(ctx context.Context, key string, opts ...LockOption) bool {
+	ok, err := rd.TryLockE(ctx, key, opts...)
+	if err == ErrNotConfigured {
+		rd.unconfiguredOnce.Do(func() {
+			logger.Printf("ERROR: TryLock(%q) called before a redis provider was set; every lock will fail as if contended", key)
+		})
 	}
+	return ok
+}
 
-	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
-		cwt, cancel := context.WithTimeout(ctx, redisExecuteTimeout)
-		defer cancel()
-		ctx = cwt
+// Configured 全局实例是否已设置redis连接
+func Configured() bool {
+	return lockDriver.cmdable() != nil
+}
+
+func (rd *redisDriver) TryLockE(ctx context.Context, key string, opts ...LockOption) (bool, error) {
+	_, err := rd.Acquire(ctx, key, opts...)
+	if err == ErrNotAcquired {
+		return false, nil
 	}
 
-	var (
-		ok  bool
-		err error
-	)
+	return err == nil, err
+}
 
-	if rd.client != nil {
-		ok, err = rd.client.SetNX(ctx, key, lockerValue(), lockTTL).Result()
-	}
+func (rd *redisDriver) TryLockAs(ctx context.Context, key string, token string, opts ...LockOption) (bool, error) {
+	return rd.TryLockE(ctx, key, append(opts, WithToken(token))...)
+}
 
-	if rd.clusterClient != nil {
-		ok, err = rd.clusterClient.SetNX(ctx, key, lockerValue(), lockTTL).Result()
+func (rd *redisDriver) Unlock(ctx context.Context, key string, opts ...LockOption) bool {
+	res, _, err := rd.UnlockE(ctx, key, opts...)
+	return err == nil && res == UnlockReleased
+}
+
+// UnlockResult 释放锁的结果
+type UnlockResult int
+
+const (
+	// UnlockFailed 未能完成释放，见返回的error
+	UnlockFailed UnlockResult = iota
+	// UnlockReleased 已释放
+	UnlockReleased
+	// UnlockNotHeld 锁已被其他持有者获取，未删除
+	UnlockNotHeld
+	// UnlockMissing 锁已不存在(已过期或已被释放)
+	UnlockMissing
+)
+
+// UnlockE 释放锁，并返回删除前观察到的剩余TTL
+//
+// 剩余TTL与删除在同一个脚本中读取，可用于记录"释放时距过期还有200ms"之类的诊断信息，
+// 若释放时的剩余TTL持续很小，说明TTL或续期间隔需要调整。未释放时remaining为0；
+// 禁用脚本(WATCH/MULTI/EXEC回退)时无法读取剩余TTL，remaining始终为0，且无法区分UnlockNotHeld与UnlockMissing。
+// 自动续期已宣告锁丢失时只移除本地状态，返回ErrAlreadyLost，不再删除键。
+func (rd *redisDriver) UnlockE(ctx context.Context, key string, opts ...LockOption) (UnlockResult, time.Duration, error) {
+	if err := validateKey(key); err != nil {
+		return UnlockFailed, 0, err
 	}
 
+	lo := newLockOptions(opts)
+	c, err := rd.clientFor(lo)
 	if err != nil {
-		return false
-	}
-
-	if ok {
-		cancelChan := make(chan struct{})
-
-		//自动续期
-		go func() {
-			ticker := time.NewTicker(renewalCheckInterval)
-			innerCtx := context.Background()
-			defer ticker.Stop()
-
-		LOOP:
-			for {
-				select {
-				case <-ticker.C:
-					if rd.client != nil {
-						if redisOK, redisErr := rd.client.Expire(innerCtx, key, lockTTL).Result(); !redisOK || redisErr != nil {
-							break LOOP
-						}
-					}
-					if rd.clusterClient != nil {
-						if redisOK, redisErr := rd.clusterClient.Expire(innerCtx, key, lockTTL).Result(); !redisOK || redisErr != nil {
-							break LOOP
-						}
-					}
-				case <-cancelChan:
-					break LOOP
-				}
-			}
-		}()
-
-		states.mux.Lock()
-		states.listeners[key] = cancelChan
-		states.mux.Unlock()
+		return UnlockFailed, 0, err
 	}
 
-	return ok
-}
+	if done, ok := rd.begin(); ok {
+		defer done()
+	}
+
+	ctx, cancel := withTimeout(ctx, lo.timeout)
+	defer cancel()
 
-func (rd *redisDriver) Unlock(ctx context.Context, key string) bool {
-	if rd.client == nil && rd.clusterClient == nil {
-		return false
+	rkey := redisKey(key)
+	channel := rd.eventChannel(lo, rkey)
+	value, err := rd.releaseLocal(lo, key, rkey)
+	if err != nil {
+		return UnlockFailed, 0, err
+	}
+	res, remaining, err := rd.unlockKey(ctx, c, key, rkey, legacyRedisKey(lo, key), value)
+	if res == UnlockReleased {
+		publishEvent(ctx, c, channel, LockEvent{Type: EventReleased, Key: key, Owner: eventOwner(value)})
 	}
+	return res, remaining, err
+}
 
-	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
-		cwt, cancel := context.WithTimeout(ctx, redisExecuteTimeout)
-		defer cancel()
-		ctx = cwt
+// UnlockAsync 立即停止自动续期，在后台释放锁，结果(nil、ErrNotHeld或redis错误)通过返回的通道送达
+//
+// 用于不希望等待释放往返的请求处理等热路径。本地状态在返回前即已移除，后台删除仍按值比较，
+// 即使期间锁被其他持有者获取也不会误删，续期也不会使已释放的锁复活。
+// 后台删除不受ctx取消的影响(仅沿用其中的值)，仍受执行超时限制；通道缓冲为1，无需接收。
+func (rd *redisDriver) UnlockAsync(ctx context.Context, key string, opts ...LockOption) <-chan error {
+	errc := make(chan error, 1)
+	if err := validateKey(key); err != nil {
+		errc <- err
+		return errc
 	}
 
-	if rd.client != nil {
-		cnt, err := rd.client.Del(ctx, key).Result()
-		return cnt > 0 && err == nil
+	lo := newLockOptions(opts)
+	c, err := rd.clientFor(lo)
+	if err != nil {
+		errc <- err
+		return errc
 	}
 
-	if rd.clusterClient != nil {
-		cnt, err := rd.clusterClient.Del(ctx, key).Result()
-		return cnt > 0 && err == nil
+	rkey := redisKey(key)
+	channel := rd.eventChannel(lo, rkey)
+	value, err := rd.releaseLocal(lo, key, rkey)
+	if err != nil {
+		errc <- err
+		return errc
 	}
 
+	done, ok := rd.begin()
 	go func() {
-		states.mux.Lock()
-		ch, ok := states.listeners[key]
 		if ok {
-			delete(states.listeners, key)
+			defer done()
 		}
-		states.mux.Unlock()
-		if ok {
-			ch <- struct{}{}
-			close(ch)
+
+		ctx, cancel := withTimeout(detach(ctx), lo.timeout)
+		defer cancel()
+		ctx, cancelExec := withExecuteTimeout(ctx)
+		defer cancelExec()
+
+		res, _, err := rd.unlockKey(ctx, c, key, rkey, legacyRedisKey(lo, key), value)
+		if err == nil && res != UnlockReleased {
+			err = ErrNotHeld
+		}
+		if res == UnlockReleased {
+			publishEvent(ctx, c, channel, LockEvent{Type: EventReleased, Key: key, Owner: eventOwner(value)})
 		}
+		errc <- err
 	}()
 
-	return false
+	return errc
 }
 
-// 锁的持有者信息
-func lockerValue() string {
-	hostname, _ := os.Hostname()
-	ip, _ := GetLocalIP()
+// 移除本地状态并停止续期，返回按值比较删除时使用的值
+//
+// 本地持有时按值比较后删除，避免误删其他持有者的锁；本地没有记录时返回空，保持直接删除的行为。
+// 续期已宣告锁丢失时只移除本地状态，返回ErrAlreadyLost，不再访问redis。
+func (rd *redisDriver) releaseLocal(lo *lockOptions, key string, rkey string) (string, error) {
+	if renewalMode == RenewalNone {
+		return "", nil
+	}
+
+	skey := rd.stateKey(lo, rkey)
+	if st, ok := rd.states.remove(skey); ok {
+		st.stop()
+		if st.lostFlag.Load() {
+			return "", ErrAlreadyLost
+		}
+		rd.order.released(key)
+		return st.value, nil
+	}
+
+	//刚释放过的锁再次Unlock时按释放时的值比较，不会误删此后被其他持有者获取的锁
+	if value, ok := rd.states.released(skey); ok {
+		return value, nil
+	}
+	return "", nil
+}
 
-	return fmt.Sprintf("lockedAt:%s@%s(%s)", time.Now().Format("2006-01-02T15:04:05Z"), hostname, ip)
+// 保留ctx中的值，但不随ctx取消或到期
+type detachedContext struct {
+	context.Context
+}
+
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// 按值比较后删除锁，value为空时直接删除；legacy不为空时按相同规则清理迁移前的旧键
+func (rd *redisDriver) unlockKey(ctx context.Context, c redisLib.UniversalClient, key string, rkey string, legacy string, value string) (UnlockResult, time.Duration, error) {
+	var (
+		res       UnlockResult
+		remaining time.Duration
+		deleted   string
+		err       error
+	)
+	switch {
+	case !rd.noScripting.Load():
+		res, remaining, deleted, err = unlockWithTTL(ctx, c, rkey, value)
+		if err == nil && res == UnlockNotHeld {
+			ownershipLostHook(key, value, deleted)
+		}
+	case value != "":
+		var ok bool
+		if ok, err = rd.compareAndDelete(ctx, c, rkey, value); ok {
+			res = UnlockReleased
+		} else if err == nil {
+			res = UnlockNotHeld
+			checkOwnership(ctx, c, key, rkey, value)
+		}
+	default:
+		var cnt int64
+		if cnt, err = c.Del(ctx, rkey).Result(); cnt > 0 {
+			res = UnlockReleased
+		} else {
+			res = UnlockMissing
+		}
+	}
+	if err != nil {
+		return UnlockFailed, 0, err
+	}
+	if legacy != "" {
+		ok, err := rd.unlockLegacy(ctx, c, legacy, value)
+		if err != nil && res != UnlockReleased {
+			return UnlockFailed, 0, err
+		}
+		if ok {
+			res = UnlockReleased
+		}
+	}
+
+	if res == UnlockReleased {
+		rd.notifyUnlock(ctx, c, rkey)
+	}
+	//钩子收到本地记录的值，直接删除时收到从redis读取的被删除的值
+	if value == "" {
+		value = deleted
+	}
+	if value != "" {
+		releasedHook(key, value, res == UnlockReleased)
+	}
+	return res, remaining, nil
+}
+
+// 当前使用的redis客户端，未设置时返回nil
+func (rd *redisDriver) cmdable() redisLib.UniversalClient {
+	if rd.client != nil {
+		return rd.client
+	}
+	if rd.clusterClient != nil {
+		return rd.clusterClient
+	}
+
+	return nil
+}
+
+// 未设置截止时间时使用默认的redis执行超时时间
+func withExecuteTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, hasDeadline := ctx.Deadline(); hasDeadline {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, redisExecuteTimeout)
+}
+
+// 设置了本次调用的超时时间时使用该超时(ctx更早的截止时间仍然生效)，否则同withExecuteTimeout
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+
+	return withExecuteTimeout(ctx)
+}
+
+// 是否为超时错误
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
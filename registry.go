@@ -0,0 +1,80 @@
+package corgi
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+var lockers = struct {
+	mux     sync.RWMutex
+	lockers map[string]Locker
+}{lockers: make(map[string]Locker)}
+
+// NewLocker 创建独立的锁实例(单实例)，与全局实例互不影响
+//
+// 命令限速(SetCommandRateLimit)及统计(SetCommandStats)对本进程内的所有实例生效，AddRedisHook注册的Hook只安装在全局实例上。
+func NewLocker(opt *redisLib.Options, opts ...ProviderOption) (Locker, error) {
+	rdb, err := dialClient(opt, newProviderOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	rdb.AddHook(commandHook{})
+
+	rd := newDriver()
+	rd.client = rdb
+	_, _ = rd.detectVersion(context.Background())
+
+	return rd, nil
+}
+
+// Register 按名称注册锁实例，供不同模块通过Get共享，名称已存在时返回错误
+func Register(name string, opt *redisLib.Options, opts ...ProviderOption) error {
+	lockers.mux.Lock()
+	defer lockers.mux.Unlock()
+
+	if _, ok := lockers.lockers[name]; ok {
+		return fmt.Errorf("corgi: locker %q already registered", name)
+	}
+
+	l, err := NewLocker(opt, opts...)
+	if err != nil {
+		return fmt.Errorf("corgi: register locker %q: %w", name, err)
+	}
+	lockers.lockers[name] = l
+
+	return nil
+}
+
+// Get 获取按名称注册的锁实例
+func Get(name string) (Locker, error) {
+	lockers.mux.RLock()
+	defer lockers.mux.RUnlock()
+
+	l, ok := lockers.lockers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrLockerNotFound, name)
+	}
+
+	return l, nil
+}
+
+// Unregister 移除按名称注册的锁实例并优雅关闭(见Shutdown)，名称不存在时返回ErrLockerNotFound
+func Unregister(ctx context.Context, name string) error {
+	lockers.mux.Lock()
+	l, ok := lockers.lockers[name]
+	delete(lockers.lockers, name)
+	lockers.mux.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrLockerNotFound, name)
+	}
+	if rd, ok := l.(*redisDriver); ok {
+		return rd.shutdown(ctx)
+	}
+
+	return nil
+}
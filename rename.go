@@ -0,0 +1,89 @@
+package corgi
+
+import (
+	"context"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// 旧键的值为ARGV[1]时以相同的值和剩余TTL写入新键(NX)并删除旧键
+// 返回1表示成功，0表示新键已存在，-1表示旧键已不由本持有者持有
+var renameScript = redisLib.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return -1
+end
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl <= 0 then
+	ttl = tonumber(ARGV[2])
+end
+if not redis.call("SET", KEYS[2], ARGV[1], "PX", ttl, "NX") then
+	return 0
+end
+redis.call("DEL", KEYS[1])
+return 1
+`)
+
+// Rename 将本进程持有的oldKey的锁原子地转移到newKey，期间不存在释放后重新获取的空档
+//
+// 新键以相同的值和剩余TTL写入，newKey已被占用时返回false且oldKey保持不变；
+// oldKey未由本进程持有时返回ErrNotHeld。成功后自动续期改为续期newKey(使用本次传入的选项)，
+// oldKey原有的句柄失效，需通过Acquire或Restore(newKey, Value)获取新的句柄。
+// 关联数据(AcquireWithData)不会随之转移；cluster模式下两个键需通过hash tag位于同一个slot。
+func (rd *redisDriver) Rename(ctx context.Context, oldKey string, newKey string, opts ...LockOption) (bool, error) {
+	if err := validateKey(oldKey); err != nil {
+		return false, err
+	}
+	if err := validateKey(newKey); err != nil {
+		return false, err
+	}
+
+	lo := newLockOptions(opts)
+	c, err := rd.clientFor(lo)
+	if err != nil {
+		return false, err
+	}
+
+	done, ok := rd.begin()
+	if !ok {
+		return false, ErrClosed
+	}
+	defer done()
+
+	ctx, cancel := withTimeout(ctx, lo.timeout)
+	defer cancel()
+
+	oldRkey, newRkey := redisKey(oldKey), redisKey(newKey)
+	st, held := rd.states.load(rd.stateKey(lo, oldRkey))
+	if !held || st.ended.Load() {
+		return false, ErrNotHeld
+	}
+
+	//与续期互斥，避免续期在转移后重新写入旧键的TTL
+	st.mux.Lock()
+	n, err := renameScript.Run(ctx, c, []string{oldRkey, newRkey}, st.value, st.leaseTTL(lo).Milliseconds()).Int()
+	st.mux.Unlock()
+	if err != nil {
+		return false, err
+	}
+	switch n {
+	case 0:
+		return false, nil
+	case -1:
+		return false, ErrNotHeld
+	}
+
+	if prev, ok := rd.states.removeIf(rd.stateKey(lo, oldRkey), st.value); ok {
+		prev.stop()
+		rd.order.released(oldKey)
+	}
+	releasedHook(oldKey, st.value, true)
+	lockedHook(newKey, st.value)
+
+	moved := newLockState(c, newKey, newRkey, st.value)
+	moved.ttl.Store(st.ttl.Load())
+	moved.headroom = st.headroom
+	moved.acquiredAt, moved.stack = st.acquiredAt, st.stack
+	rd.hold(moved, lo)
+
+	return true, nil
+}
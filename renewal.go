@@ -0,0 +1,190 @@
+package corgi
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RenewalMode 本地状态及自动续期的模式
+type RenewalMode int
+
+const (
+	// RenewalAuto 记录本地持有的锁并自动续期(默认)
+	RenewalAuto RenewalMode = iota
+	// RenewalNone 不记录任何本地状态，也不启动续期协程，锁只依赖TTL过期和显式释放
+	//
+	// 适用于由sidecar等外部组件续期或不需要续期的无状态部署。此模式下加锁只执行SET NX，
+	// 锁在TTL后过期；句柄的Unlock、Extend按值比较后执行，Lost、RenewTicks不会收到通知；
+	// 按key的Unlock无法比较值，直接删除键；按key的Extend、Shutdown时的释放、持有数量限制等
+	// 依赖本地状态的功能均不可用。
+	RenewalNone
+)
+
+var renewalMode = RenewalAuto
+
+// SetRenewalMode 设置本地状态及自动续期的模式，需在获取锁之前设置
+func SetRenewalMode(m RenewalMode) {
+	renewalMode = m
+}
+
+// 自动续期，直到收到取消信号、锁丢失、超过最长持有时间或续期context结束
+//
+// 续期命令出错时，若设置了续期宽限期，则在宽限期(且不超过锁的TTL)内持续重试，
+// 宽限期内不视为锁丢失；键已不存在时则立即视为丢失。
+func (rd *redisDriver) renewal(st *lockState, lo *lockOptions) {
+	rd.renewalEnded(st, lo, rd.renewalLoop(st, lo))
+}
+
+// 续期结束后的处理，reason为nil表示调用方已释放锁
+func (rd *redisDriver) renewalEnded(st *lockState, lo *lockOptions, reason error) {
+	if reason == nil {
+		return
+	}
+
+	//到达截止时间，锁随TTL自然过期，键已不由本进程续期，移除本地状态
+	if reason == errRenewalDeadline {
+		st.ended.Store(true)
+		st.finish(ErrDeadlinePassed)
+		if _, ok := rd.states.removeIf(rd.stateKey(lo, st.rkey), st.value); ok {
+			rd.order.released(st.key)
+		}
+		st.leaveGate()
+		return
+	}
+
+	st.lostFlag.Store(true)
+	st.ended.Store(true)
+	rd.order.released(st.key)
+	st.leaveGate()
+
+	//缓冲区大小为1且只写入一次，不会阻塞
+	st.lost <- reason
+	st.finish(reason)
+	if st.eventChannel != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), redisExecuteTimeout)
+		publishEvent(ctx, st.client, st.eventChannel, LockEvent{Type: EventLost, Key: st.key, Owner: eventOwner(st.value), Reason: reason.Error()})
+		cancel()
+	}
+	if lo.onLost != nil {
+		lo.onLost(st.key, reason)
+	}
+	if lo.autoRelease {
+		rd.release(st)
+	}
+}
+
+// 续期循环，返回锁丢失的原因，因调用方释放锁而退出时返回nil
+func (rd *redisDriver) renewalLoop(st *lockState, lo *lockOptions) error {
+	ticker := time.NewTicker(st.interval())
+	defer ticker.Stop()
+
+	var ctxDone <-chan struct{}
+	if lo.renewalCtx != nil {
+		ctxDone = lo.renewalCtx.Done()
+	}
+
+	p := newRenewalProgress(lo)
+	for {
+		select {
+		case <-st.extended:
+			//手动续期后重新计时，避免紧接着的冗余续期；Relock后同时切换到新的续期间隔
+			ticker.Reset(st.interval())
+			p.lastRenewed = time.Unix(0, st.lastExtended.Load())
+		case <-ticker.C:
+			if !renewalThrottle.wait(st.cancel) {
+				return nil
+			}
+			if done, reason := rd.renewTick(st, lo, p); done {
+				return reason
+			}
+		case <-ctxDone:
+			return lo.renewalCtx.Err()
+		case <-st.cancel:
+			return nil
+		}
+	}
+}
+
+// 单个锁的续期进度
+type renewalProgress struct {
+	acquiredAt  time.Time
+	lastRenewed time.Time
+	grace       time.Duration
+	//连续续期出错的次数
+	failures int
+}
+
+func newRenewalProgress(lo *lockOptions) *renewalProgress {
+	grace := lo.renewalGrace
+	if grace > lockTTL {
+		grace = lockTTL
+	}
+	now := time.Now()
+
+	return &renewalProgress{acquiredAt: now, lastRenewed: now, grace: grace}
+}
+
+// 执行一次到期的续期检查，返回true时续期结束，error为锁丢失的原因
+func (rd *redisDriver) renewTick(st *lockState, lo *lockOptions, p *renewalProgress) (bool, error) {
+	if lo.maxLifetime > 0 && time.Since(p.acquiredAt) >= lo.maxLifetime {
+		logger.Printf("WARNING: lock %q has been held longer than max lifetime %s, renewal stopped; is Unlock missing?", st.key, lo.maxLifetime)
+		return true, ErrMaxLifetime
+	}
+
+	if !lo.renewUntil.IsZero() && !time.Now().Before(lo.renewUntil) {
+		return true, errRenewalDeadline
+	}
+
+	if time.Since(time.Unix(0, st.lastExtended.Load())) < st.interval() {
+		return false, nil
+	}
+
+	grace := p.grace
+	if ttl := time.Duration(st.ttl.Load()); ttl > 0 && grace > ttl {
+		grace = ttl
+	}
+
+	ok, err := rd.expire(st, lo)
+	st.tick(ok && err == nil)
+	if ok && err == nil {
+		p.lastRenewed = time.Now()
+		p.failures = 0
+		st.lastRenewed.Store(p.lastRenewed.UnixNano())
+		return false, nil
+	}
+	if err != nil {
+		p.failures++
+	}
+	if err != nil && time.Since(p.lastRenewed) < grace && (lo.renewalRetries == 0 || p.failures <= lo.renewalRetries) {
+		return false, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("%w: %v", ErrRenewalFailed, err)
+	}
+	return true, ErrRenewalFailed
+}
+
+// 值匹配时设置键的过期时间，避免为其他持有者的锁续期
+func (rd *redisDriver) expire(st *lockState, lo *lockOptions) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisExecuteTimeout)
+	defer cancel()
+
+	st.mux.Lock()
+	defer st.mux.Unlock()
+
+	return rd.expireState(ctx, st, lo.untilTTL(st.leaseTTL(lo)))
+}
+
+// 续期结束后主动按值比较删除，值已被新的持有者覆盖时不会误删
+func (rd *redisDriver) release(st *lockState) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisExecuteTimeout)
+	defer cancel()
+
+	ok, err := rd.compareAndDelete(ctx, st.client, st.rkey, st.value)
+	if err != nil {
+		info, _ := parseLockerValue(st.value)
+		logger.Printf("auto release lock %q (token %s) failed: %v", st.rkey, info.Token, err)
+	}
+	releasedHook(st.key, st.value, ok && err == nil)
+}
@@ -0,0 +1,151 @@
+package corgi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// WithReplicationWait 获取锁成功后通过WAIT等待写入同步到指定数量的副本
+//
+// 在超时时间内同步的副本数不足时视为获取失败，回滚已写入的锁并返回ErrReplicationTimeout，
+// 以降低主从切换时锁丢失的风险。timeout为0时WAIT会一直阻塞，直到满足副本数或ctx超时。
+func WithReplicationWait(numReplicas int, timeout time.Duration) LockOption {
+	return func(lo *lockOptions) {
+		lo.waitReplicas = numReplicas
+		lo.requireVersion("3.0.0", "WAIT")
+		lo.waitTimeout = timeout
+	}
+}
+
+// 槽位迁移时SETNX最多跟随的重定向次数
+const maxRedirects = 3
+
+// 在同一连接上执行SETNX和WAIT(WAIT只对当前连接之前的写命令生效)
+//
+// 集群模式下直接使用节点连接，不经过ClusterClient的路由，因此需要自行跟随槽位迁移
+// 产生的MOVED/ASK重定向：MOVED时触发重新加载集群状态后改连目标节点，ASK时在目标节点上先发送ASKING。
+func (rd *redisDriver) setNXWait(ctx context.Context, key string, value string, lo *lockOptions) (bool, error) {
+	node := rd.client
+	if lo.selectDB {
+		var err error
+		if node, err = rd.dbClient(lo.db); err != nil {
+			return false, err
+		}
+	} else if rd.clusterClient != nil {
+		var err error
+		if node, err = rd.clusterClient.MasterForKey(ctx, key); err != nil {
+			return false, err
+		}
+	}
+
+	asking := false
+	for i := 0; ; i++ {
+		ok, err := rd.setNXWaitOn(ctx, node, key, value, lo, asking)
+		if rd.clusterClient == nil || i >= maxRedirects {
+			return ok, err
+		}
+
+		moved, ask, addr := redirection(err)
+		if !moved && !ask {
+			return ok, err
+		}
+		//clusterMaster同步重新读取slot映射，MOVED时无需再调用(异步的)ReloadState
+		if node, err = rd.clusterMaster(ctx, addr); err != nil {
+			return false, err
+		}
+		asking = ask
+	}
+}
+
+func (rd *redisDriver) setNXWaitOn(ctx context.Context, node *redisLib.Client, key string, value string, lo *lockOptions, asking bool) (bool, error) {
+	conn := node.Conn(ctx)
+	defer conn.Close()
+
+	if asking {
+		if err := conn.Process(ctx, redisLib.NewStatusCmd(ctx, "asking")); err != nil {
+			return false, err
+		}
+	}
+
+	ok, err := setNXResult(setNX(ctx, conn, key, value, lo.initialTTL()))
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	acked, err := conn.Wait(ctx, lo.waitReplicas, lo.waitTimeout).Result()
+	if err == nil && acked < int64(lo.waitReplicas) {
+		err = ErrReplicationTimeout
+	}
+	if err != nil {
+		rollbackCtx, cancel := context.WithTimeout(context.Background(), redisExecuteTimeout)
+		defer cancel()
+		if asking {
+			//ASK重定向时目标节点只在ASKING之后的一条命令内接受该键，回滚须在同一连接上紧跟ASKING发送
+			_, _ = conn.Pipelined(rollbackCtx, func(pipe redisLib.Pipeliner) error {
+				_ = pipe.Process(rollbackCtx, redisLib.NewStatusCmd(rollbackCtx, "asking"))
+				compareAndDeleteScript.Eval(rollbackCtx, pipe, []string{key}, value)
+				return nil
+			})
+		} else {
+			_, _ = rd.compareAndDelete(rollbackCtx, node, key, value)
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// 解析MOVED/ASK重定向错误，返回目标节点地址
+func redirection(err error) (moved bool, ask bool, addr string) {
+	var redisErr redisLib.Error
+	if !errors.As(err, &redisErr) {
+		return false, false, ""
+	}
+
+	fields := strings.Fields(redisErr.Error())
+	if len(fields) != 3 {
+		return false, false, ""
+	}
+	switch fields[0] {
+	case "MOVED":
+		moved = true
+	case "ASK":
+		ask = true
+	default:
+		return false, false, ""
+	}
+
+	return moved, ask, fields[2]
+}
+
+// 按地址查找集群中的主节点
+//
+// ForEachMaster会先同步重新读取slot映射，因此MOVED之后也能找到新的主节点。
+func (rd *redisDriver) clusterMaster(ctx context.Context, addr string) (*redisLib.Client, error) {
+	var (
+		mux   sync.Mutex
+		found *redisLib.Client
+	)
+	err := rd.clusterClient.ForEachMaster(ctx, func(ctx context.Context, client *redisLib.Client) error {
+		if client.Options().Addr == addr {
+			mux.Lock()
+			found = client
+			mux.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("corgi: cluster node %s not found", addr)
+	}
+
+	return found, nil
+}
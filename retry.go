@@ -0,0 +1,274 @@
+package corgi
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// 出错时自动重试的Locker，见WithRetry
+type retryLocker struct {
+	Locker
+
+	attempts int
+	backoff  time.Duration
+}
+
+// WithRetry 包装l，加锁遇到临时性错误(如网络超时、连接中断)时最多尝试attempts次，每次间隔backoff
+//
+// 锁被占用不是错误，不会重试，需要等待锁时应使用Lock。键不合法、未配置、已关闭、任期过期等确定性错误，
+// WithAcquireTimeout到期(ErrTimeout)以及ctx结束时也不会重试。Unlock/UnlockE同样只在出错时重试(锁已不存在不是错误)，
+// 由于释放可安全地重复调用，重试不会误删其他持有者的锁。
+//
+// 注意响应丢失的加锁请求可能实际已成功，此时重试会因锁被(自己)占用而失败，该锁在TTL后过期。
+// Extend只返回是否成功，无法区分临时性错误与锁已不再持有，不会重试。
+func WithRetry(l Locker, attempts int, backoff time.Duration) Locker {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return &retryLocker{Locker: l, attempts: attempts, backoff: backoff}
+}
+
+// 执行fn直到成功、返回不可重试的错误或达到最大尝试次数
+func (rl *retryLocker) retry(ctx context.Context, fn func() error) error {
+	var err error
+	for i := 0; i < rl.attempts; i++ {
+		if i > 0 && !sleepCtx(ctx, rl.backoff) {
+			return err
+		}
+		if err = fn(); !retryable(ctx, err) {
+			return err
+		}
+	}
+	return err
+}
+
+// 等待d，ctx先结束时返回false
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// 是否为可重试的临时性错误
+func retryable(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var stale *StaleTermError
+	if errors.As(err, &stale) {
+		return false
+	}
+	for _, permanent := range []error{
+		ErrNotAcquired, ErrNotHeld, ErrInvalidKey, ErrNotConfigured, ErrClosed, ErrTimeout,
+		ErrDBNotSupported, ErrTooManyLocks, ErrInvalidOption, ErrRateLimited, ErrAlreadyHeldBySelf, ErrAlreadyLost, ErrConditionNotMet, ErrKeyTypeConflict, ErrDeadlinePassed, ErrServerVersion, context.Canceled, context.DeadlineExceeded,
+	} {
+		if errors.Is(err, permanent) {
+			return false
+		}
+	}
+	return true
+}
+
+func (rl *retryLocker) TryLock(ctx context.Context, key string, opts ...LockOption) bool {
+	ok, _ := rl.TryLockE(ctx, key, opts...)
+	return ok
+}
+
+func (rl *retryLocker) TryLockE(ctx context.Context, key string, opts ...LockOption) (ok bool, err error) {
+	err = rl.retry(ctx, func() (err error) {
+		ok, err = rl.Locker.TryLockE(ctx, key, opts...)
+		return err
+	})
+	return ok, err
+}
+
+func (rl *retryLocker) TryLockAs(ctx context.Context, key string, token string, opts ...LockOption) (ok bool, err error) {
+	err = rl.retry(ctx, func() (err error) {
+		ok, err = rl.Locker.TryLockAs(ctx, key, token, opts...)
+		return err
+	})
+	return ok, err
+}
+
+func (rl *retryLocker) TryLockWithTerm(ctx context.Context, key string, term int64, opts ...LockOption) (ok bool, err error) {
+	err = rl.retry(ctx, func() (err error) {
+		ok, err = rl.Locker.TryLockWithTerm(ctx, key, term, opts...)
+		return err
+	})
+	return ok, err
+}
+
+func (rl *retryLocker) TryLockIf(ctx context.Context, key string, condKey string, expected string, opts ...LockOption) (ok bool, err error) {
+	err = rl.retry(ctx, func() (err error) {
+		ok, err = rl.Locker.TryLockIf(ctx, key, condKey, expected, opts...)
+		return err
+	})
+	return ok, err
+}
+
+func (rl *retryLocker) TryLockStealStale(ctx context.Context, key string, olderThan time.Duration, opts ...LockOption) (res StealResult, err error) {
+	err = rl.retry(ctx, func() (err error) {
+		res, err = rl.Locker.TryLockStealStale(ctx, key, olderThan, opts...)
+		return err
+	})
+	return res, err
+}
+
+func (rl *retryLocker) TryLockAndIncr(ctx context.Context, lockKey string, counterKey string, opts ...LockOption) (ok bool, n int64, err error) {
+	err = rl.retry(ctx, func() (err error) {
+		ok, n, err = rl.Locker.TryLockAndIncr(ctx, lockKey, counterKey, opts...)
+		return err
+	})
+	return ok, n, err
+}
+
+func (rl *retryLocker) Unlock(ctx context.Context, key string, opts ...LockOption) bool {
+	res, _, err := rl.UnlockE(ctx, key, opts...)
+	return err == nil && res == UnlockReleased
+}
+
+func (rl *retryLocker) UnlockE(ctx context.Context, key string, opts ...LockOption) (res UnlockResult, remaining time.Duration, err error) {
+	err = rl.retry(ctx, func() (err error) {
+		res, remaining, err = rl.Locker.UnlockE(ctx, key, opts...)
+		return err
+	})
+	return res, remaining, err
+}
+
+// UnlockMulti 按逆序逐个释放，每个key的释放规则与UnlockE相同
+func (rl *retryLocker) UnlockMulti(ctx context.Context, keys []string, opts ...LockOption) []string {
+	released := make([]string, 0, len(keys))
+	for i := len(keys) - 1; i >= 0; i-- {
+		if rl.Unlock(ctx, keys[i], opts...) {
+			released = append(released, keys[i])
+		}
+	}
+	return released
+}
+
+// UnlockAsync 后台释放出错时在后台继续重试，本地状态已移除，重试按释放时的值比较
+func (rl *retryLocker) UnlockAsync(ctx context.Context, key string, opts ...LockOption) <-chan error {
+	first := rl.Locker.UnlockAsync(ctx, key, opts...)
+	errc := make(chan error, 1)
+	go func() {
+		err := <-first
+		//与UnlockAsync一致，后台释放不受ctx取消的影响
+		ctx := detach(ctx)
+		for i := 1; i < rl.attempts && retryable(ctx, err); i++ {
+			if !sleepCtx(ctx, rl.backoff) {
+				break
+			}
+			var res UnlockResult
+			if res, _, err = rl.Locker.UnlockE(ctx, key, opts...); err == nil && res != UnlockReleased {
+				err = ErrNotHeld
+			}
+		}
+		errc <- err
+	}()
+	return errc
+}
+
+func (rl *retryLocker) Acquire(ctx context.Context, key string, opts ...LockOption) (l *Lock, err error) {
+	err = rl.retry(ctx, func() (err error) {
+		l, err = rl.Locker.Acquire(ctx, key, opts...)
+		return err
+	})
+	return l, err
+}
+
+func (rl *retryLocker) Lock(ctx context.Context, key string, opts ...LockOption) (l *Lock, err error) {
+	err = rl.retry(ctx, func() (err error) {
+		l, err = rl.Locker.Lock(ctx, key, opts...)
+		return err
+	})
+	return l, err
+}
+
+// TryLockSome 出错时只对尚未获取的key重试，返回各次获取成功的key
+func (rl *retryLocker) TryLockSome(ctx context.Context, keys []string, opts ...LockOption) (acquired []string, err error) {
+	remaining := keys
+	err = rl.retry(ctx, func() error {
+		got, err := rl.Locker.TryLockSome(ctx, remaining, opts...)
+		acquired = append(acquired, got...)
+		remaining = excludeKeys(remaining, got)
+		return err
+	})
+	return acquired, err
+}
+
+// keys中去除exclude后的key，保持原有顺序
+func excludeKeys(keys []string, exclude []string) []string {
+	if len(exclude) == 0 {
+		return keys
+	}
+	skip := make(map[string]struct{}, len(exclude))
+	for _, key := range exclude {
+		skip[key] = struct{}{}
+	}
+	out := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := skip[key]; !ok {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+func (rl *retryLocker) Rename(ctx context.Context, oldKey string, newKey string, opts ...LockOption) (ok bool, err error) {
+	err = rl.retry(ctx, func() (err error) {
+		ok, err = rl.Locker.Rename(ctx, oldKey, newKey, opts...)
+		return err
+	})
+	return ok, err
+}
+
+func (rl *retryLocker) LockFair(ctx context.Context, key string, opts ...LockOption) (l *Lock, err error) {
+	err = rl.retry(ctx, func() (err error) {
+		l, err = rl.Locker.LockFair(ctx, key, opts...)
+		return err
+	})
+	return l, err
+}
+
+func (rl *retryLocker) LockUntil(ctx context.Context, key string, deadline time.Time, opts ...LockOption) error {
+	_, err := rl.AcquireUntil(ctx, key, deadline, opts...)
+	return err
+}
+
+func (rl *retryLocker) AcquireUntil(ctx context.Context, key string, deadline time.Time, opts ...LockOption) (l *Lock, err error) {
+	err = rl.retry(ctx, func() (err error) {
+		l, err = rl.Locker.AcquireUntil(ctx, key, deadline, opts...)
+		return err
+	})
+	return l, err
+}
+
+// AcquireInPipeline 只重试加入pipeline之前的错误，出错时不会向pipe加入命令；Commit的结果不会重试
+func (rl *retryLocker) AcquireInPipeline(ctx context.Context, pipe redisLib.Pipeliner, key string, opts ...LockOption) (p *PendingLock, err error) {
+	err = rl.retry(ctx, func() (err error) {
+		p, err = rl.Locker.AcquireInPipeline(ctx, pipe, key, opts...)
+		return err
+	})
+	return p, err
+}
+
+func (rl *retryLocker) AcquireWithData(ctx context.Context, key string, data map[string]string, ttl time.Duration) (ok bool, err error) {
+	err = rl.retry(ctx, func() (err error) {
+		ok, err = rl.Locker.AcquireWithData(ctx, key, data, ttl)
+		return err
+	})
+	return ok, err
+}
@@ -0,0 +1,217 @@
+package corgi
+
+import (
+	"context"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// 回收过期的读者后，没有写者和读者时写入写者，返回1表示成功
+//
+// 写者存储在字符串键中，值为token；读者存储在有序集合中，成员为token，分数为过期时间(毫秒)
+var rwWriteScript = redisLib.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", ARGV[1])
+if redis.call("EXISTS", KEYS[1]) == 1 or redis.call("ZCARD", KEYS[2]) > 0 then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+return 1
+`)
+
+// 没有写者时加入读者，返回1表示成功
+var rwReadScript = redisLib.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", ARGV[1])
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+redis.call("ZADD", KEYS[2], tonumber(ARGV[1]) + tonumber(ARGV[3]), ARGV[2])
+redis.call("PEXPIRE", KEYS[2], ARGV[3])
+return 1
+`)
+
+// 写者仍为ARGV[2]时删除写者并以同一个token加入读者，两步在同一个脚本中完成，返回1表示成功
+var rwDowngradeScript = redisLib.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call("DEL", KEYS[1])
+redis.call("ZADD", KEYS[2], tonumber(ARGV[1]) + tonumber(ARGV[3]), ARGV[2])
+redis.call("PEXPIRE", KEYS[2], ARGV[3])
+return 1
+`)
+
+// 读者仍持有未过期的读锁时延长过期时间，返回1表示成功
+var rwExtendReadScript = redisLib.NewScript(`
+local expireAt = redis.call("ZSCORE", KEYS[1], ARGV[2])
+if not expireAt or tonumber(expireAt) <= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], tonumber(ARGV[1]) + tonumber(ARGV[3]), ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return 1
+`)
+
+// RWLock 读写锁，同一时刻只允许一个写者，或者任意多个读者
+//
+// 与Semaphore相同，读写锁不自动续期，持有时间超过锁的TTL时需在TTL内调用句柄的Extend；
+// 读者的过期时间以客户端时钟为准。写者优先级不高于读者，持续有读者时写者可能一直无法获取。
+// 写者与读者存储在两个键中，两者同属一个hash tag，cluster模式下位于同一个slot。
+type RWLock struct {
+	driver *redisDriver
+	key    string
+}
+
+// NewRWLock 创建key上的读写锁
+func NewRWLock(key string) *RWLock {
+	return lockDriver.NewRWLock(key)
+}
+
+func (rd *redisDriver) NewRWLock(key string) *RWLock {
+	return &RWLock{driver: rd, key: key}
+}
+
+func (rw *RWLock) writerKey() string {
+	return companionKey(rw.key, "rw")
+}
+
+func (rw *RWLock) readersKey() string {
+	return companionKey(rw.key, "rw-readers")
+}
+
+// RWLockHandle 已获取的读锁或写锁的句柄，不能在多个goroutine中同时使用
+type RWLockHandle struct {
+	rw    *RWLock
+	token string
+	write bool
+}
+
+// Writing 句柄当前是否持有写锁
+func (h *RWLockHandle) Writing() bool {
+	return h.write
+}
+
+// Lock 尝试获取写锁，已有写者或读者时返回ErrNotAcquired
+func (rw *RWLock) Lock(ctx context.Context) (*RWLockHandle, error) {
+	return rw.acquire(ctx, rwWriteScript, true)
+}
+
+// RLock 尝试获取读锁，已有写者时返回ErrNotAcquired
+func (rw *RWLock) RLock(ctx context.Context) (*RWLockHandle, error) {
+	return rw.acquire(ctx, rwReadScript, false)
+}
+
+func (rw *RWLock) acquire(ctx context.Context, script *redisLib.Script, write bool) (*RWLockHandle, error) {
+	if err := validateKey(rw.key); err != nil {
+		return nil, err
+	}
+	c := rw.driver.cmdable()
+	if c == nil {
+		return nil, ErrNotConfigured
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	token := newToken()
+	n, err := script.Run(ctx, c, []string{rw.writerKey(), rw.readersKey()}, nowMillis(), token, lockTTL.Milliseconds()).Int()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrNotAcquired
+	}
+
+	return &RWLockHandle{rw: rw, token: token, write: write}, nil
+}
+
+// DowngradeToRead 将写锁原子地转为读锁：删除写者与加入读者在同一个脚本中完成，
+// 期间其他写者无法获取，等待中的读者可在转换后立即获取
+//
+// 转换后句柄持有读锁，Unlock、Extend作用于读锁。写锁已过期或句柄持有的是读锁时返回ErrNotHeld。
+// 不支持反向的升级(读锁转为写锁)：两个读者同时升级时会互相等待对方释放读锁而死锁，
+// 需要写锁时应先释放读锁再获取写锁，并重新检查读取到的状态。
+func (h *RWLockHandle) DowngradeToRead(ctx context.Context) error {
+	if !h.write {
+		return ErrNotHeld
+	}
+	c := h.rw.driver.cmdable()
+	if c == nil {
+		return ErrNotConfigured
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	n, err := rwDowngradeScript.Run(ctx, c, []string{h.rw.writerKey(), h.rw.readersKey()}, nowMillis(), h.token, lockTTL.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotHeld
+	}
+	h.write = false
+
+	return nil
+}
+
+// Extend 延长读锁或写锁的过期时间，已过期或已释放时返回ErrNotHeld
+func (h *RWLockHandle) Extend(ctx context.Context) error {
+	c := h.rw.driver.cmdable()
+	if c == nil {
+		return ErrNotConfigured
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	var (
+		ok  bool
+		err error
+	)
+	if h.write {
+		ok, err = h.rw.driver.compareAndExpire(ctx, c, h.rw.writerKey(), h.token, lockTTL)
+	} else {
+		var n int
+		n, err = rwExtendReadScript.Run(ctx, c, []string{h.rw.readersKey()}, nowMillis(), h.token, lockTTL.Milliseconds()).Int()
+		ok = n > 0
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotHeld
+	}
+
+	return nil
+}
+
+// Unlock 释放读锁或写锁，已过期或已释放时返回ErrNotHeld
+func (h *RWLockHandle) Unlock(ctx context.Context) error {
+	c := h.rw.driver.cmdable()
+	if c == nil {
+		return ErrNotConfigured
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	var (
+		ok  bool
+		err error
+	)
+	if h.write {
+		ok, err = h.rw.driver.compareAndDelete(ctx, c, h.rw.writerKey(), h.token)
+	} else {
+		var n int64
+		n, err = c.ZRem(ctx, h.rw.readersKey(), h.token).Result()
+		ok = n > 0
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotHeld
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package corgi
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRWLock(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+	rw := rd.NewRWLock("catalog")
+
+	r1, err := rw.RLock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := rw.RLock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rw.Lock(ctx); err != ErrNotAcquired {
+		t.Fatalf("expected readers to block the writer, got %v", err)
+	}
+	if err = r1.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = r2.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := rw.Lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rw.RLock(ctx); err != ErrNotAcquired {
+		t.Fatalf("expected the writer to block readers, got %v", err)
+	}
+	if err = w.Extend(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Unlock(ctx); err != ErrNotHeld {
+		t.Fatalf("expected ErrNotHeld for a released writer, got %v", err)
+	}
+}
+
+func TestRWLockDowngrade(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+	rw := rd.NewRWLock("report")
+
+	w, err := rw.Lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//转换期间持续尝试获取写锁，任何时刻都不应成功
+	var (
+		stolen atomic.Int64
+		stop   atomic.Bool
+		wg     sync.WaitGroup
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				if other, err := rw.Lock(ctx); err == nil {
+					stolen.Add(1)
+					_ = other.Unlock(ctx)
+				}
+			}
+		}()
+	}
+
+	if err = w.DowngradeToRead(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if w.Writing() {
+		t.Fatal("expected the handle to hold a read lock")
+	}
+	if mr.Exists(rw.writerKey()) {
+		t.Fatal("expected the writer key to be removed")
+	}
+	if members, _ := mr.ZMembers(rw.readersKey()); len(members) != 1 {
+		t.Fatalf("expected one reader after downgrade, got %v", members)
+	}
+
+	//读者可以并存，写者仍被阻塞
+	r, err := rw.RLock(ctx)
+	if err != nil {
+		t.Fatalf("expected another reader to share the lock, got %v", err)
+	}
+	stop.Store(true)
+	wg.Wait()
+	if n := stolen.Load(); n != 0 {
+		t.Fatalf("expected no writer to slip in during downgrade, got %d", n)
+	}
+
+	if err = w.DowngradeToRead(ctx); err != ErrNotHeld {
+		t.Fatalf("expected ErrNotHeld when downgrading a read lock, got %v", err)
+	}
+	if err = w.Extend(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rw.Lock(ctx); err != nil {
+		t.Fatalf("expected the writer to acquire after readers left, got %v", err)
+	}
+}
+
+func TestRWLockDowngradeLost(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+	rw := rd.NewRWLock("lost")
+
+	w, err := rw.Lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = mr.Set(rw.writerKey(), "other")
+	if err = w.DowngradeToRead(ctx); err != ErrNotHeld {
+		t.Fatalf("expected ErrNotHeld, got %v", err)
+	}
+	if mr.Exists(rw.readersKey()) {
+		t.Fatal("expected no reader to be added for a lost writer")
+	}
+	if v, _ := mr.Get(rw.writerKey()); v != "other" {
+		t.Fatalf("expected the other writer to remain, got %q", v)
+	}
+}
+
+func TestRWLockKeys(t *testing.T) {
+	rw := newDriver().NewRWLock("orders")
+	tag := "{" + redisKey("orders") + "}"
+	if w, r := rw.writerKey(), rw.readersKey(); !strings.HasPrefix(w, tag) || !strings.HasPrefix(r, tag) || w == r {
+		t.Fatalf("expected writer and readers to share hash tag %s, got %s %s", tag, w, r)
+	}
+}
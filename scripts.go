@@ -0,0 +1,173 @@
+package corgi
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// 所有脚本只通过KEYS传递要操作的键：集群模式下ClusterClient据此路由到键所在的节点，
+// 并在槽位迁移时跟随MOVED/ASK重定向，续期和释放不会打到已迁出的节点。
+
+// 值匹配时才删除(compare-and-delete)
+var compareAndDeleteScript = redisLib.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// 值匹配(ARGV[1]为空时不比较)时删除，返回{结果, 删除前的剩余TTL(毫秒), 删除前的值}
+//
+// 结果为1表示已删除，0表示值不匹配，-1表示键不存在
+var unlockWithTTLScript = redisLib.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if not value then
+	return {-1, 0, ""}
+end
+if ARGV[1] ~= "" and value ~= ARGV[1] then
+	return {0, 0, value}
+end
+local ttl = redis.call("PTTL", KEYS[1])
+redis.call("DEL", KEYS[1])
+return {1, ttl, value}
+`)
+
+// 值匹配时才设置过期时间(毫秒)
+var compareAndExpireScript = redisLib.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// 值匹配时同时设置锁及其关联数据(KEYS[2])的过期时间(毫秒)
+var compareAndExpireDataScript = redisLib.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[2], ARGV[2])
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// 值匹配且剩余TTL不低于ARGV[2]毫秒时设置过期时间为ARGV[3]毫秒
+//
+// 返回{1, 剩余TTL}表示已续期，{0, 剩余TTL}表示剩余TTL低于下限，{-1, 0}表示值不匹配
+var safeExtendScript = redisLib.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return {-1, 0}
+end
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl >= 0 and ttl < tonumber(ARGV[2]) then
+	return {0, ttl}
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return {1, ttl}
+`)
+
+// WATCH事务冲突时的最大重试次数
+const watchRetries = 3
+
+// 值匹配时删除键，不支持脚本时使用WATCH/MULTI/EXEC
+func (rd *redisDriver) compareAndDelete(ctx context.Context, c redisLib.UniversalClient, key string, value string) (bool, error) {
+	if !rd.noScripting.Load() {
+		n, err := compareAndDeleteScript.Run(ctx, c, []string{key}, value).Int()
+		return n > 0, err
+	}
+
+	return watchCompareAnd(ctx, c, key, value, func(pipe redisLib.Pipeliner) {
+		pipe.Del(ctx, key)
+	})
+}
+
+// 按值比较后删除并返回删除前的剩余TTL，以及删除前的值(值不匹配时为当前的值)
+func unlockWithTTL(ctx context.Context, c redisLib.UniversalClient, key string, value string) (UnlockResult, time.Duration, string, error) {
+	vals, err := unlockWithTTLScript.Run(ctx, c, []string{key}, value).Slice()
+	if err != nil {
+		return UnlockFailed, 0, "", err
+	}
+	if len(vals) != 3 {
+		return UnlockFailed, 0, "", fmt.Errorf("corgi: unexpected unlock reply %v", vals)
+	}
+	code, _ := vals[0].(int64)
+	ttl, _ := vals[1].(int64)
+	current, _ := vals[2].(string)
+
+	switch code {
+	case 1:
+		var remaining time.Duration
+		if ttl > 0 {
+			remaining = time.Duration(ttl) * time.Millisecond
+		}
+		return UnlockReleased, remaining, current, nil
+	case 0:
+		return UnlockNotHeld, 0, current, nil
+	default:
+		return UnlockMissing, 0, "", nil
+	}
+}
+
+// 值匹配时设置过期时间，不支持脚本时使用WATCH/MULTI/EXEC
+func (rd *redisDriver) compareAndExpire(ctx context.Context, c redisLib.UniversalClient, key string, value string, ttl time.Duration) (bool, error) {
+	if !rd.noScripting.Load() {
+		n, err := compareAndExpireScript.Run(ctx, c, []string{key}, value, ttl.Milliseconds()).Int()
+		return n > 0, err
+	}
+
+	return watchCompareAnd(ctx, c, key, value, func(pipe redisLib.Pipeliner) {
+		pipe.PExpire(ctx, key, ttl)
+	})
+}
+
+// 为本地持有的锁续期，有关联数据(AcquireWithData)时一并续期
+func (rd *redisDriver) expireState(ctx context.Context, st *lockState, ttl time.Duration) (bool, error) {
+	if st.dataKey == "" {
+		return rd.compareAndExpire(ctx, st.client, st.rkey, st.value, ttl)
+	}
+	if !rd.noScripting.Load() {
+		n, err := compareAndExpireDataScript.Run(ctx, st.client, []string{st.rkey, st.dataKey}, st.value, ttl.Milliseconds()).Int()
+		return n > 0, err
+	}
+
+	return watchCompareAnd(ctx, st.client, st.rkey, st.value, func(pipe redisLib.Pipeliner) {
+		pipe.PExpire(ctx, st.dataKey, ttl)
+		pipe.PExpire(ctx, st.rkey, ttl)
+	})
+}
+
+// 乐观事务：读取键的值，未被修改且与value一致时在事务中执行fn，冲突时重试
+//
+// 需要额外的往返(WATCH、GET、MULTI/EXEC)，性能低于脚本，仅用于禁用了脚本的环境。
+func watchCompareAnd(ctx context.Context, c redisLib.UniversalClient, key string, value string, fn func(pipe redisLib.Pipeliner)) (bool, error) {
+	for i := 0; i < watchRetries; i++ {
+		matched := false
+		err := c.Watch(ctx, func(tx *redisLib.Tx) error {
+			current, err := tx.Get(ctx, key).Result()
+			if err == redisLib.Nil {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if current != value {
+				return nil
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redisLib.Pipeliner) error {
+				fn(pipe)
+				return nil
+			})
+			matched = err == nil
+			return err
+		}, key)
+		if err == redisLib.TxFailedErr {
+			continue
+		}
+
+		return matched, err
+	}
+
+	return false, redisLib.TxFailedErr
+}
@@ -0,0 +1,88 @@
+package corgi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 自检时续期前先将TTL缩短到的值，用于确认续期确实延长了TTL
+const selfTestShortTTL = time.Second
+
+var selfTestWarmUp = true
+
+// SetSelfTestWarmUp 设置SelfTest是否先执行WarmUp预热cluster拓扑(默认开启)
+func SetSelfTestWarmUp(enabled bool) {
+	selfTestWarmUp = enabled
+}
+
+// SelfTest 端到端自检：获取一个临时key，确认续期可延长TTL、持有期间再次获取会失败，
+// 释放后确认key已删除
+//
+// 适合在应用启动时调用，在处理请求前发现脚本被禁用、DB选择错误等配置问题，
+// 返回的错误说明了哪一步出现异常。cluster模式下默认先执行WarmUp，见SetSelfTestWarmUp。
+func SelfTest(ctx context.Context) error {
+	return lockDriver.selfTest(ctx)
+}
+
+func (rd *redisDriver) selfTest(ctx context.Context) error {
+	c := rd.cmdable()
+	if c == nil {
+		return ErrNotConfigured
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	if selfTestWarmUp {
+		if err := rd.warmUp(ctx); err != nil {
+			return fmt.Errorf("corgi: self test: warm up: %w", err)
+		}
+	}
+
+	key := "corgi-selftest:" + newToken()
+	rkey := redisKey(key)
+
+	l, err := rd.Acquire(ctx, key)
+	if err != nil {
+		return fmt.Errorf("corgi: self test: acquire: %w", err)
+	}
+	defer func() {
+		_ = l.Unlock(context.Background())
+	}()
+
+	if err = c.PExpire(ctx, rkey, selfTestShortTTL).Err(); err != nil {
+		return fmt.Errorf("corgi: self test: shorten ttl: %w", err)
+	}
+	if err = l.Extend(ctx); err != nil {
+		return fmt.Errorf("corgi: self test: renew: %w", err)
+	}
+	ttl, err := c.PTTL(ctx, rkey).Result()
+	if err != nil {
+		return fmt.Errorf("corgi: self test: read ttl: %w", err)
+	}
+	if ttl <= selfTestShortTTL {
+		return fmt.Errorf("corgi: self test: renew did not extend ttl (%s left)", ttl)
+	}
+
+	if _, err = rd.Acquire(ctx, key); !errors.Is(err, ErrNotAcquired) {
+		if err == nil {
+			return errors.New("corgi: self test: second acquire succeeded while the lock was held")
+		}
+		return fmt.Errorf("corgi: self test: second acquire: %w", err)
+	}
+
+	if err = l.Unlock(ctx); err != nil {
+		return fmt.Errorf("corgi: self test: release: %w", err)
+	}
+	n, err := c.Exists(ctx, rkey).Result()
+	if err != nil {
+		return fmt.Errorf("corgi: self test: check release: %w", err)
+	}
+	if n != 0 {
+		return errors.New("corgi: self test: key still exists after release")
+	}
+
+	return nil
+}
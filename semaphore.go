@@ -0,0 +1,229 @@
+package corgi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// 名额成员编码的版本，见encodeSemaphoreMember
+const semaphoreMemberVersion = "v1"
+
+// 从有序集合的成员中解析出调用方的成员名，无法识别的编码(升级前写入的成员)按原样作为成员名
+//
+// 各脚本共用，需与parseSemaphoreMember保持一致。
+const semaphoreHolderLua = `
+local function holder(m)
+	return string.match(m, "^v1|[^|]*|%d+|%d*|[^|]*|(.*)$") or m
+end
+local function find(key, member)
+	for _, m in ipairs(redis.call("ZRANGE", key, 0, -1)) do
+		if holder(m) == member then
+			return m
+		end
+	end
+	return nil
+end
+`
+
+// 回收过期名额后，成员已持有或名额未满时占用一个名额，返回1表示成功
+//
+// 组内成员存储在有序集合中，分数为名额的过期时间(毫秒)，成员为带版本的编码(ARGV[5])；
+// 已持有的成员再次获取时保留原有的编码(及获取时间)，只刷新过期时间。
+var semaphoreAcquireScript = redisLib.NewScript(semaphoreHolderLua + `
+local now = tonumber(ARGV[1])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+local entry = find(KEYS[1], ARGV[2])
+if not entry then
+	if redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[3]) then
+		return 0
+	end
+	entry = ARGV[5]
+end
+redis.call("ZADD", KEYS[1], now + tonumber(ARGV[4]), entry)
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+return 1
+`)
+
+// 成员仍持有未过期的名额时延长过期时间，返回1表示成功
+var semaphoreExtendScript = redisLib.NewScript(semaphoreHolderLua + `
+local now = tonumber(ARGV[1])
+local entry = find(KEYS[1], ARGV[2])
+if not entry or tonumber(redis.call("ZSCORE", KEYS[1], entry)) <= now then
+	return 0
+end
+redis.call("ZADD", KEYS[1], now + tonumber(ARGV[3]), entry)
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return 1
+`)
+
+// 释放成员持有的名额
+var semaphoreReleaseScript = redisLib.NewScript(semaphoreHolderLua + `
+local entry = find(KEYS[1], ARGV[1])
+if entry then
+	return redis.call("ZREM", KEYS[1], entry)
+end
+return 0
+`)
+
+// 名额成员的编码：v1|token|获取时间(毫秒)|pid|主机名|成员名
+//
+// 成员名放在最后，可包含任意字符；主机名不含"|"。
+func encodeSemaphoreMember(member string, now int64) string {
+	host, _ := os.Hostname()
+	host = strings.ReplaceAll(host, "|", "")
+	return strings.Join([]string{semaphoreMemberVersion, newToken(), strconv.FormatInt(now, 10), strconv.Itoa(os.Getpid()), host, member}, "|")
+}
+
+// HolderInfo 分组配额中一个名额的持有者
+type HolderInfo struct {
+	// Member 获取名额时传入的成员名
+	Member string
+	Token  string
+	Host   string
+	PID    int
+	// AcquiredAt 获取名额的时间，升级前写入的名额为零值
+	AcquiredAt time.Time
+	// ExpiresAt 名额的过期时间
+	ExpiresAt time.Time
+}
+
+// 解析有序集合中的成员，score为过期时间(毫秒)
+func parseSemaphoreMember(m string, score float64) HolderInfo {
+	info := HolderInfo{Member: m, ExpiresAt: time.Unix(0, int64(score)*int64(time.Millisecond))}
+	parts := strings.SplitN(m, "|", 6)
+	if len(parts) != 6 || parts[0] != semaphoreMemberVersion {
+		return info
+	}
+	acquiredAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return info
+	}
+
+	info.Member = parts[5]
+	info.Token = parts[1]
+	info.Host = parts[4]
+	info.PID, _ = strconv.Atoi(parts[3])
+	info.AcquiredAt = time.Unix(0, acquiredAt*int64(time.Millisecond))
+	return info
+}
+
+// Semaphore 分组配额，组内不同的成员共享limit个名额，用于限制对外部系统的全局并发访问
+//
+// 每个名额在锁的TTL后自动回收(避免持有者崩溃后名额泄漏)，持有时间更长时需在TTL内调用Extend。
+type Semaphore struct {
+	driver *redisDriver
+	group  string
+	limit  int
+}
+
+// GroupSemaphore 创建分组配额
+func GroupSemaphore(group string, limit int) *Semaphore {
+	return lockDriver.GroupSemaphore(group, limit)
+}
+
+func (rd *redisDriver) GroupSemaphore(group string, limit int) *Semaphore {
+	return &Semaphore{driver: rd, group: group, limit: limit}
+}
+
+func (s *Semaphore) key() string {
+	return companionKey(s.group, "semaphore")
+}
+
+// Acquire 为成员占用一个名额，名额已满时返回false；已持有名额的成员再次获取时刷新过期时间
+func (s *Semaphore) Acquire(ctx context.Context, member string) (bool, error) {
+	c := s.driver.cmdable()
+	if c == nil {
+		return false, ErrNotConfigured
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	now := nowMillis()
+	n, err := semaphoreAcquireScript.Run(ctx, c, []string{s.key()}, now, member, s.limit, lockTTL.Milliseconds(), encodeSemaphoreMember(member, now)).Int()
+	return n > 0, err
+}
+
+// Extend 延长成员持有的名额，名额已过期或已释放时返回ErrNotHeld
+func (s *Semaphore) Extend(ctx context.Context, member string) error {
+	c := s.driver.cmdable()
+	if c == nil {
+		return ErrNotConfigured
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	n, err := semaphoreExtendScript.Run(ctx, c, []string{s.key()}, nowMillis(), member, lockTTL.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotHeld
+	}
+
+	return nil
+}
+
+// Release 释放成员持有的名额
+func (s *Semaphore) Release(ctx context.Context, member string) error {
+	c := s.driver.cmdable()
+	if c == nil {
+		return ErrNotConfigured
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	return semaphoreReleaseScript.Run(ctx, c, []string{s.key()}, member).Err()
+}
+
+// Holders 列出当前持有名额的成员，按获取时间排序
+func (s *Semaphore) Holders(ctx context.Context) ([]HolderInfo, error) {
+	c := s.driver.cmdable()
+	if c == nil {
+		return nil, ErrNotConfigured
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	entries, err := c.ZRangeByScoreWithScores(ctx, s.key(), &redisLib.ZRangeBy{Min: fmt.Sprintf("(%d", nowMillis()), Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+	holders := make([]HolderInfo, 0, len(entries))
+	for _, z := range entries {
+		m, _ := z.Member.(string)
+		holders = append(holders, parseSemaphoreMember(m, z.Score))
+	}
+	sort.SliceStable(holders, func(i, j int) bool {
+		return holders[i].AcquiredAt.Before(holders[j].AcquiredAt)
+	})
+
+	return holders, nil
+}
+
+// SemaphoreHolders 列出全局实例上分组配额group当前的持有者
+func SemaphoreHolders(ctx context.Context, group string) ([]HolderInfo, error) {
+	return lockDriver.SemaphoreHolders(ctx, group)
+}
+
+func (rd *redisDriver) SemaphoreHolders(ctx context.Context, group string) ([]HolderInfo, error) {
+	return rd.GroupSemaphore(group, 0).Holders(ctx)
+}
+
+// 名额的过期时间以客户端时钟为准，各进程间的时钟偏差应远小于锁的TTL
+var semaphoreClock = time.Now
+
+// 当前时间(毫秒)
+func nowMillis() int64 {
+	return semaphoreClock().UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,106 @@
+package corgi
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGroupSemaphore(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+	sem := rd.GroupSemaphore("db-connections", 2)
+
+	for _, member := range []string{"worker-1", "worker-2"} {
+		if ok, err := sem.Acquire(ctx, member); !ok || err != nil {
+			t.Fatalf("expected %s to acquire, got %v %v", member, ok, err)
+		}
+	}
+	if ok, _ := sem.Acquire(ctx, "worker-3"); ok {
+		t.Fatal("expected quota to be exhausted")
+	}
+	if ok, _ := sem.Acquire(ctx, "worker-1"); !ok {
+		t.Fatal("expected existing member to re-acquire")
+	}
+
+	if err := sem.Release(ctx, "worker-1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := sem.Acquire(ctx, "worker-3"); !ok {
+		t.Fatal("expected released slot to be reused")
+	}
+	if err := sem.Extend(ctx, "worker-1"); err != ErrNotHeld {
+		t.Fatalf("expected ErrNotHeld for released member, got %v", err)
+	}
+
+	//名额过期后被回收
+	now := time.Now().Add(lockTTL + time.Second)
+	semaphoreClock = func() time.Time { return now }
+	defer func() { semaphoreClock = time.Now }()
+	for _, member := range []string{"worker-4", "worker-5"} {
+		if ok, err := sem.Acquire(ctx, member); !ok || err != nil {
+			t.Fatalf("expected expired slots to be reclaimed for %s, got %v %v", member, ok, err)
+		}
+	}
+	if n, _ := mr.ZMembers(companionKey("db-connections", "semaphore")); len(n) != 2 {
+		t.Fatalf("expected 2 holders after reclamation, got %v", n)
+	}
+}
+
+func TestSemaphoreHolders(t *testing.T) {
+	rd, mr := newTestDriver(t)
+	ctx := context.Background()
+	sem := rd.GroupSemaphore("exports", 3)
+
+	for _, member := range []string{"worker|a", "worker-b"} {
+		if ok, err := sem.Acquire(ctx, member); !ok || err != nil {
+			t.Fatalf("expected %s to acquire, got %v %v", member, ok, err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	//升级前写入的成员按原样作为成员名
+	key := companionKey("exports", "semaphore")
+	_, _ = mr.ZAdd(key, float64(nowMillis()+lockTTL.Milliseconds()), "legacy")
+
+	holders, err := rd.SemaphoreHolders(ctx, "exports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holders) != 3 || holders[0].Member != "legacy" || holders[1].Member != "worker|a" || holders[2].Member != "worker-b" {
+		t.Fatalf("unexpected holders %+v", holders)
+	}
+	if h := holders[1]; h.Token == "" || h.PID != os.Getpid() || h.AcquiredAt.IsZero() || !h.ExpiresAt.After(h.AcquiredAt) {
+		t.Fatalf("expected decoded holder metadata, got %+v", h)
+	}
+
+	//脚本按相同的编码找到成员
+	if ok, _ := sem.Acquire(ctx, "worker-c"); ok {
+		t.Fatal("expected quota to be exhausted")
+	}
+	if err = sem.Extend(ctx, "legacy"); err != nil {
+		t.Fatal(err)
+	}
+	if err = sem.Release(ctx, "worker|a"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := sem.Acquire(ctx, "worker-c"); !ok {
+		t.Fatal("expected released slot to be reused")
+	}
+	if members, _ := mr.ZMembers(key); len(members) != 3 {
+		t.Fatalf("expected 3 holders, got %v", members)
+	}
+}
+
+func TestSemaphoreKeyspace(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	//信号量的键不会与调用方"semaphore:<group>"上的锁冲突
+	if !rd.TryLock(ctx, "semaphore:jobs") {
+		t.Fatal("expected plain lock to acquire")
+	}
+	if ok, err := rd.GroupSemaphore("jobs", 1).Acquire(ctx, "worker-1"); !ok || err != nil {
+		t.Fatalf("expected semaphore to acquire, got %v %v", ok, err)
+	}
+}
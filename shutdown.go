@@ -0,0 +1,225 @@
+package corgi
+
+import (
+	"context"
+	"fmt"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// Shutdown 优雅关闭
+//
+// 依次执行：停止接受新的加锁请求；等待进行中的操作完成；停止自动续期并释放本进程持有的锁；
+// 等待续期协程退出；关闭redis连接。整个过程受ctx约束，返回期间遇到的错误。
+//
+// 持有的锁按批通过pipeline释放(cluster模式下由go-redis按节点拆分)，ctx结束后不再发送剩余的批次，
+// 未能释放的锁(随TTL过期)通过*ReleaseError列出，可用errors.As取得。
+func Shutdown(ctx context.Context) error {
+	return lockDriver.shutdown(ctx)
+}
+
+// 开始一次操作，已关闭时返回false
+func (rd *redisDriver) begin() (func(), bool) {
+	rd.lifecycle.RLock()
+	defer rd.lifecycle.RUnlock()
+
+	if rd.closed {
+		return nil, false
+	}
+	rd.inflight.Add(1)
+
+	return rd.inflight.Done, true
+}
+
+func (rd *redisDriver) shutdown(ctx context.Context) error {
+	rd.lifecycle.Lock()
+	rd.closed = true
+	rd.lifecycle.Unlock()
+
+	var errs []error
+
+	if err := waitContext(ctx, rd.inflight.Wait); err != nil {
+		errs = append(errs, fmt.Errorf("wait in-flight operations: %w", err))
+	}
+
+	//未能释放的锁放在最前，便于通过errors.As取得ReleaseError
+	if err := rd.releaseStates(ctx, rd.states.drain()); err != nil {
+		errs = append([]error{err}, errs...)
+	}
+
+	if err := waitContext(ctx, rd.renewals.Wait); err != nil {
+		errs = append(errs, fmt.Errorf("wait renewals: %w", err))
+	}
+	if rd.pool != nil {
+		rd.pool.stop()
+	}
+
+	errs = append(errs, rd.closeDBClients()...)
+	if rd.client != nil {
+		if err := rd.client.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close client: %w", err))
+		}
+	}
+	if rd.clusterClient != nil {
+		if err := rd.clusterClient.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close cluster client: %w", err))
+		}
+	}
+
+	return joinErrors("corgi: shutdown", errs)
+}
+
+// FlushHeldLocks 停止全局实例的所有自动续期并删除本进程记录的所有锁(按值比较)，返回删除的数量
+//
+// 仅用于测试之间清理遗留的锁，不触发释放的钩子，也不等待进行中的操作；生产环境应使用Shutdown。
+// 未设置redis连接时为空操作。
+func FlushHeldLocks(ctx context.Context) int {
+	return lockDriver.flushHeldLocks(ctx)
+}
+
+func (rd *redisDriver) flushHeldLocks(ctx context.Context) int {
+	flushed := 0
+	for _, st := range rd.states.drain() {
+		st.stop()
+		rd.order.released(st.key)
+		if st.client == nil {
+			continue
+		}
+		if ok, _ := rd.compareAndDelete(ctx, st.client, st.rkey, st.value); ok {
+			flushed++
+		}
+	}
+	_ = waitContext(ctx, rd.renewals.Wait)
+
+	return flushed
+}
+
+// 在ctx约束下等待wait返回
+func waitContext(ctx context.Context, wait func()) error {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 合并多个错误，保留第一个错误以便errors.Is/As判断
+func joinErrors(prefix string, errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("%s: %w", prefix, errs[0])
+	default:
+		return fmt.Errorf("%s: %w (and %d more errors)", prefix, errs[0], len(errs)-1)
+	}
+}
+
+// Shutdown时每个pipeline释放的锁的数量
+const shutdownReleaseBatch = 256
+
+// ReleaseError 未能释放的锁，这些锁将随TTL过期
+type ReleaseError struct {
+	// Keys 未能释放的锁的key
+	Keys []string
+	// Err 遇到的第一个错误，通常为ctx的错误
+	Err error
+}
+
+func (e *ReleaseError) Error() string {
+	return fmt.Sprintf("corgi: %d locks not released: %v", len(e.Keys), e.Err)
+}
+
+func (e *ReleaseError) Unwrap() error {
+	return e.Err
+}
+
+// 停止续期并按批释放锁，返回未能释放的锁；值已被其他持有者覆盖的锁不视为失败
+func (rd *redisDriver) releaseStates(ctx context.Context, states map[string]*lockState) error {
+	byClient := make(map[redisLib.UniversalClient][]*lockState)
+	for _, st := range states {
+		st.stop()
+		if st.client != nil {
+			byClient[st.client] = append(byClient[st.client], st)
+		}
+	}
+
+	relErr := &ReleaseError{}
+	fail := func(st *lockState, err error) {
+		relErr.Keys = append(relErr.Keys, st.key)
+		if relErr.Err == nil {
+			relErr.Err = err
+		}
+		releasedHook(st.key, st.value, false)
+	}
+	for c, sts := range byClient {
+		for i := 0; i < len(sts); i += shutdownReleaseBatch {
+			end := i + shutdownReleaseBatch
+			if end > len(sts) {
+				end = len(sts)
+			}
+			batch := sts[i:end]
+			if err := ctx.Err(); err != nil {
+				for _, st := range batch {
+					fail(st, err)
+				}
+				continue
+			}
+
+			for j, err := range rd.compareAndDeleteEach(ctx, c, batch) {
+				switch err {
+				case nil:
+					releasedHook(batch[j].key, batch[j].value, true)
+				case ErrNotHeld:
+					releasedHook(batch[j].key, batch[j].value, false)
+				default:
+					fail(batch[j], err)
+				}
+			}
+		}
+	}
+
+	if len(relErr.Keys) == 0 {
+		return nil
+	}
+	return relErr
+}
+
+// 对一批锁执行compare-and-delete，返回每个锁的错误；值已不是本进程写入的值时返回ErrNotHeld
+func (rd *redisDriver) compareAndDeleteEach(ctx context.Context, c redisLib.UniversalClient, sts []*lockState) []error {
+	errs := make([]error, len(sts))
+	if rd.noScripting.Load() {
+		for i, st := range sts {
+			ok, err := rd.compareAndDelete(ctx, c, st.rkey, st.value)
+			if err == nil && !ok {
+				err = ErrNotHeld
+			}
+			errs[i] = err
+		}
+		return errs
+	}
+
+	//pipeline中无法根据NOSCRIPT回退，直接使用EVAL
+	pipe := c.Pipeline()
+	cmds := make([]*redisLib.Cmd, len(sts))
+	for i, st := range sts {
+		cmds[i] = compareAndDeleteScript.Eval(ctx, pipe, []string{st.rkey}, st.value)
+	}
+	_, _ = pipe.Exec(ctx)
+
+	for i, cmd := range cmds {
+		n, err := cmd.Int()
+		if err == nil && n == 0 {
+			err = ErrNotHeld
+		}
+		errs[i] = err
+	}
+	return errs
+}
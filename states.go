@@ -0,0 +1,203 @@
+package corgi
+
+import (
+	"sync"
+	"time"
+)
+
+// 本地锁状态注册表的分片数量
+const stateShardCount = 32
+
+// 单个分片中释放记录超过该数量时清理已过期的记录
+const tombstoneSweepThreshold = 1024
+
+type stateShard struct {
+	mux       sync.Mutex
+	listeners map[string]*lockState
+	//最近释放的锁，在锁的TTL内记住释放时的值，使重复的Unlock成为按值比较的空操作
+	tombstones map[string]tombstone
+}
+
+type tombstone struct {
+	value      string
+	releasedAt time.Time
+}
+
+// 本地锁状态注册表，按key哈希分片以降低高并发下的互斥锁争用
+type stateListeners struct {
+	shards []*stateShard
+	//分片锁的持有时长，仅在开启SetRegistryTiming时记录
+	holds *histogram
+}
+
+func newStateListeners(shardCount int) *stateListeners {
+	if shardCount < 1 {
+		shardCount = 1
+	}
+	s := &stateListeners{shards: make([]*stateShard, shardCount), holds: newHistogram(registryHoldBounds)}
+	for i := range s.shards {
+		s.shards[i] = &stateShard{listeners: make(map[string]*lockState), tombstones: make(map[string]tombstone)}
+	}
+	return s
+}
+
+// 获取key所在的分片(FNV-1a)
+func (s *stateListeners) shard(key string) *stateShard {
+	if len(s.shards) == 1 {
+		return s.shards[0]
+	}
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return s.shards[h%uint32(len(s.shards))]
+}
+
+// 锁定分片，开启SetRegistryTiming时返回获得锁的时间
+func (s *stateListeners) lock(sh *stateShard) time.Time {
+	sh.mux.Lock()
+	if registryTiming.Load() {
+		return time.Now()
+	}
+	return time.Time{}
+}
+
+// 解锁分片并记录持有时长
+func (s *stateListeners) unlock(sh *stateShard, start time.Time) {
+	var held time.Duration
+	if !start.IsZero() {
+		held = time.Since(start)
+	}
+	sh.mux.Unlock()
+	if !start.IsZero() {
+		s.holds.observe(held)
+	}
+}
+
+// 保存key的状态，返回被替换的旧状态
+func (s *stateListeners) store(key string, st *lockState) (*lockState, bool) {
+	sh := s.shard(key)
+	start := s.lock(sh)
+	prev, ok := sh.listeners[key]
+	sh.listeners[key] = st
+	delete(sh.tombstones, key)
+	s.unlock(sh, start)
+	return prev, ok
+}
+
+func (s *stateListeners) load(key string) (*lockState, bool) {
+	sh := s.shard(key)
+	start := s.lock(sh)
+	st, ok := sh.listeners[key]
+	s.unlock(sh, start)
+	return st, ok
+}
+
+func (s *stateListeners) remove(key string) (*lockState, bool) {
+	sh := s.shard(key)
+	start := s.lock(sh)
+	st, ok := sh.listeners[key]
+	if ok {
+		delete(sh.listeners, key)
+		sh.bury(key, st.value)
+	}
+	s.unlock(sh, start)
+	return st, ok
+}
+
+// 仅当状态中的值与value一致时移除
+func (s *stateListeners) removeIf(key string, value string) (*lockState, bool) {
+	sh := s.shard(key)
+	start := s.lock(sh)
+	st, ok := sh.listeners[key]
+	if ok && st.value == value {
+		delete(sh.listeners, key)
+		sh.bury(key, st.value)
+	} else {
+		ok = false
+	}
+	s.unlock(sh, start)
+	return st, ok
+}
+
+// 取出并清空全部状态
+func (s *stateListeners) drain() map[string]*lockState {
+	all := make(map[string]*lockState)
+	for _, sh := range s.shards {
+		start := s.lock(sh)
+		for key, st := range sh.listeners {
+			all[key] = st
+		}
+		sh.listeners = make(map[string]*lockState)
+		sh.tombstones = make(map[string]tombstone)
+		s.unlock(sh, start)
+	}
+	return all
+}
+
+// 当前状态的数量
+func (s *stateListeners) count() int {
+	n := 0
+	for _, sh := range s.shards {
+		start := s.lock(sh)
+		n += len(sh.listeners)
+		s.unlock(sh, start)
+	}
+	return n
+}
+
+// 仍在续期的状态的数量，不含已丢失但尚未释放的锁
+func (s *stateListeners) live() int {
+	n := 0
+	for _, sh := range s.shards {
+		start := s.lock(sh)
+		for _, st := range sh.listeners {
+			if !st.ended.Load() {
+				n++
+			}
+		}
+		s.unlock(sh, start)
+	}
+	return n
+}
+
+// 遍历当前的全部状态，fn中不能再操作注册表
+func (s *stateListeners) each(fn func(key string, st *lockState)) {
+	for _, sh := range s.shards {
+		start := s.lock(sh)
+		for key, st := range sh.listeners {
+			fn(key, st)
+		}
+		s.unlock(sh, start)
+	}
+}
+
+// 记录释放的锁，调用方需持有分片的锁
+func (sh *stateShard) bury(key string, value string) {
+	now := time.Now()
+	if len(sh.tombstones) >= tombstoneSweepThreshold {
+		for k, ts := range sh.tombstones {
+			if now.Sub(ts.releasedAt) >= lockTTL {
+				delete(sh.tombstones, k)
+			}
+		}
+	}
+	sh.tombstones[key] = tombstone{value: value, releasedAt: now}
+}
+
+// 锁的TTL内本进程释放过key时返回释放时的值
+func (s *stateListeners) released(key string) (string, bool) {
+	sh := s.shard(key)
+	defer s.unlock(sh, s.lock(sh))
+
+	ts, ok := sh.tombstones[key]
+	if !ok {
+		return "", false
+	}
+	if time.Since(ts.releasedAt) >= lockTTL {
+		delete(sh.tombstones, key)
+		return "", false
+	}
+	return ts.value, true
+}
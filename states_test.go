@@ -0,0 +1,56 @@
+package corgi
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func benchmarkStates(b *testing.B, s *stateListeners) {
+	var seq int64
+	b.RunParallel(func(pb *testing.PB) {
+		prefix := "bench:" + strconv.FormatInt(atomic.AddInt64(&seq, 1), 10) + ":"
+		keys := make([]string, 64)
+		for i := range keys {
+			keys[i] = prefix + strconv.Itoa(i)
+		}
+		i := 0
+		for pb.Next() {
+			key := keys[i%len(keys)]
+			s.store(key, newLockState(nil, key, key, ""))
+			s.remove(key)
+			i++
+		}
+	})
+}
+
+func BenchmarkStatesSingleMutex(b *testing.B) {
+	benchmarkStates(b, newStateListeners(1))
+}
+
+func BenchmarkStatesSharded(b *testing.B) {
+	benchmarkStates(b, newStateListeners(stateShardCount))
+}
+
+func TestRegistryTiming(t *testing.T) {
+	s := newStateListeners(4)
+	s.store("a", newLockState(nil, "a", "a", ""))
+	if snap := s.holds.snapshot(); snap.Count != 0 {
+		t.Fatalf("expected no timings by default, got %+v", snap)
+	}
+
+	SetRegistryTiming(true)
+	defer SetRegistryTiming(false)
+	s.load("a")
+	s.remove("a")
+	snap := s.holds.snapshot()
+	if snap.Count != 2 || len(snap.Counts) != len(registryHoldBounds)+1 {
+		t.Fatalf("unexpected histogram %+v", snap)
+	}
+}
+
+func BenchmarkStatesTimed(b *testing.B) {
+	SetRegistryTiming(true)
+	defer SetRegistryTiming(false)
+	benchmarkStates(b, newStateListeners(stateShardCount))
+}
@@ -0,0 +1,81 @@
+package corgi
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 是否记录本地状态注册表分片锁的持有时长
+var registryTiming atomic.Bool
+
+// SetRegistryTiming 设置是否记录本地状态注册表内部互斥锁的持有时长，用于评估加锁、释放时的锁争用
+//
+// 每次访问注册表都会额外读取两次时钟，仅建议在排查性能问题时开启，默认不开启。
+func SetRegistryTiming(enabled bool) {
+	registryTiming.Store(enabled)
+}
+
+// 注册表锁持有时长的分桶上界
+var registryHoldBounds = []time.Duration{
+	time.Microsecond, 10 * time.Microsecond, 100 * time.Microsecond, time.Millisecond, 10 * time.Millisecond,
+}
+
+// RuntimeStats 锁实例的运行时统计，见Stats
+type RuntimeStats struct {
+	// Held 本进程持有的锁数量
+	Held int
+	// RegistryHold 本地状态注册表内部互斥锁的持有时长分布，未开启SetRegistryTiming时为空
+	RegistryHold Histogram
+}
+
+// Histogram 时长分布
+type Histogram struct {
+	// Bounds 各分桶的上界(包含)，最后一个分桶没有上界
+	Bounds []time.Duration
+	// Counts 各分桶的计数，比Bounds多一个
+	Counts []uint64
+	// Count 总次数
+	Count uint64
+	// Sum 总时长
+	Sum time.Duration
+}
+
+// Stats 全局实例的运行时统计
+func Stats() RuntimeStats {
+	return lockDriver.stats()
+}
+
+func (rd *redisDriver) stats() RuntimeStats {
+	return RuntimeStats{Held: rd.states.count(), RegistryHold: rd.states.holds.snapshot()}
+}
+
+type histogram struct {
+	bounds []time.Duration
+	counts []atomic.Uint64
+	sum    atomic.Int64
+}
+
+func newHistogram(bounds []time.Duration) *histogram {
+	return &histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(h.bounds) && d > h.bounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *histogram) snapshot() Histogram {
+	snap := Histogram{Bounds: h.bounds, Counts: make([]uint64, len(h.counts)), Sum: time.Duration(h.sum.Load())}
+	for i := range h.counts {
+		snap.Counts[i] = h.counts[i].Load()
+		snap.Count += snap.Counts[i]
+	}
+	if snap.Count == 0 {
+		return Histogram{}
+	}
+	return snap
+}
@@ -0,0 +1,80 @@
+package corgi
+
+import (
+	"context"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// 锁不存在时写入并返回1；当前的值仍为ARGV[3](调用方判定为陈旧的值)时覆盖并返回2；否则返回0
+var stealStaleScript = redisLib.NewScript(`
+local v = redis.call("GET", KEYS[1])
+if not v then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+if ARGV[3] ~= "" and v == ARGV[3] then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 2
+end
+return 0
+`)
+
+// StealResult TryLockStealStale的结果
+type StealResult int
+
+const (
+	// StealNotAcquired 锁被占用且未陈旧，未获取
+	StealNotAcquired StealResult = iota
+	// StealAcquired 锁不存在，正常获取
+	StealAcquired
+	// StealStolen 接管了陈旧的锁
+	StealStolen
+)
+
+// 接管陈旧锁的设置，stolen记录是否发生了接管
+type staleSteal struct {
+	olderThan time.Duration
+	stolen    bool
+}
+
+// TryLockStealStale 尝试获取锁，锁已被占用但持有者的加锁时间(lockedAt)早于olderThan之前时接管该锁
+//
+// 用于接管持有者已崩溃、既未释放也不再续期的锁，比强制删除安全：只覆盖读取时判定为陈旧的那个值，
+// 期间锁被释放或被其他持有者获取时不会覆盖。lockedAt为获取时写入、续期不会更新，
+// 仍在正常续期的长期持有者同样会被接管(其续期随后失败并宣告锁丢失)，olderThan应大于锁的最长合理持有时间
+// (可配合WithMaxLifetime)，并远大于各主机间的时钟偏差。不带加锁时间的值(二进制编码)不会被接管。
+func (rd *redisDriver) TryLockStealStale(ctx context.Context, key string, olderThan time.Duration, opts ...LockOption) (StealResult, error) {
+	steal := &staleSteal{olderThan: olderThan}
+	ok, err := rd.TryLockE(ctx, key, append(opts, func(lo *lockOptions) {
+		lo.steal = steal
+	})...)
+	switch {
+	case err != nil || !ok:
+		return StealNotAcquired, err
+	case steal.stolen:
+		return StealStolen, nil
+	default:
+		return StealAcquired, nil
+	}
+}
+
+func (rd *redisDriver) setNXStealStale(ctx context.Context, c redisLib.UniversalClient, rkey string, value string, steal *staleSteal, ttl time.Duration) (bool, error) {
+	current, err := c.Get(ctx, rkey).Result()
+	if err != nil && err != redisLib.Nil {
+		return false, err
+	}
+	stale := ""
+	if info, ok := parseLockerValue(current); ok && !info.LockedAt.IsZero() && time.Since(info.LockedAt) > steal.olderThan {
+		stale = current
+	}
+
+	n, err := stealStaleScript.Run(ctx, c, []string{rkey}, value, ttl.Milliseconds(), stale).Int()
+	if err != nil {
+		return false, err
+	}
+	steal.stolen = n == 2
+
+	return n > 0, nil
+}
@@ -0,0 +1,68 @@
+package corgi
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// 锁不存在或已存储的任期不大于ARGV[3]时写入，返回{是否获取, 已存储的任期}
+var termLockScript = redisLib.NewScript(`
+local current = 0
+local v = redis.call("GET", KEYS[1])
+if v then
+	local ok, data = pcall(cjson.decode, v)
+	if ok and type(data) == "table" and data.term then
+		current = tonumber(data.term)
+	end
+	if current > tonumber(ARGV[3]) then
+		return {0, current}
+	end
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return {1, current}
+`)
+
+// StaleTermError 已存储的任期大于请求的任期
+type StaleTermError struct {
+	// Current 当前已存储的任期
+	Current int64
+}
+
+func (e *StaleTermError) Error() string {
+	return fmt.Sprintf("corgi: stale term, current term is %d", e.Current)
+}
+
+// WithTerm 按任期获取锁，见TryLockWithTerm
+func WithTerm(term int64) LockOption {
+	return func(lo *lockOptions) {
+		lo.term = term
+	}
+}
+
+// TryLockWithTerm 按任期尝试获取锁，用于跨重启的选主
+//
+// 锁不存在，或已存储的任期不大于term(包括同一任期的持有者重启后重新获取)时获取成功，
+// 并覆盖原有的持有者；已存储的任期更大时返回*StaleTermError，其中包含当前任期，
+// 防止落后的旧leader在新leader产生后重新获取锁。term需大于0。
+func (rd *redisDriver) TryLockWithTerm(ctx context.Context, key string, term int64, opts ...LockOption) (bool, error) {
+	if term <= 0 {
+		return false, fmt.Errorf("corgi: term must be positive, got %d", term)
+	}
+
+	return rd.TryLockE(ctx, key, append(opts, WithTerm(term))...)
+}
+
+func (rd *redisDriver) setTerm(ctx context.Context, c redisLib.UniversalClient, rkey string, value string, term int64, ttl time.Duration) (bool, error) {
+	res, err := termLockScript.Run(ctx, c, []string{rkey}, value, ttl.Milliseconds(), term).Int64Slice()
+	if err != nil {
+		return false, err
+	}
+	if res[0] == 0 {
+		return false, &StaleTermError{Current: res[1]}
+	}
+
+	return true, nil
+}
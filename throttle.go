@@ -0,0 +1,84 @@
+package corgi
+
+import (
+	"sync"
+	"time"
+)
+
+// 全局的续期命令限速，为nil时不限速
+var renewalThrottle *throttle
+
+// SetRenewalRateLimit 限制本进程每秒发出的自动续期命令数量，perSecond不大于0时不限速(默认)
+//
+// 持有大量锁时按固定间隔发出续期命令，避免同一时刻集中续期冲击共享的redis。
+// 协程池模式(SetRenewalPoolSize)下，限速导致续期积压时优先为最接近过期的锁续期；
+// 每把锁独立续期协程的模式下各协程按到达顺序排队。限速过低时锁可能在续期前过期，
+// 应保证perSecond不低于持有的锁数量除以续期间隔。需在获取锁之前设置。
+func SetRenewalRateLimit(perSecond int) {
+	if perSecond <= 0 {
+		renewalThrottle = nil
+		return
+	}
+	renewalThrottle = &throttle{interval: time.Second / time.Duration(perSecond)}
+}
+
+// 按固定间隔放行的限速器
+type throttle struct {
+	mux      sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// 预约一次放行，返回需要等待的时长
+func (t *throttle) reserve() time.Duration {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	wait := t.next.Sub(now)
+	t.next = t.next.Add(t.interval)
+
+	return wait
+}
+
+// 预约n次放行，需要等待的时长超过maxWait时不预约并返回false，maxWait小于0时不限制
+func (t *throttle) reserveWithin(n int, maxWait time.Duration) (time.Duration, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	wait := t.next.Sub(now)
+	if maxWait >= 0 && wait > maxWait {
+		return 0, false
+	}
+	t.next = t.next.Add(t.interval * time.Duration(n))
+
+	return wait, true
+}
+
+// 等待放行，done先结束时返回false；未限速时立即返回
+func (t *throttle) wait(done <-chan struct{}) bool {
+	if t == nil {
+		return true
+	}
+	d := t.reserve()
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
@@ -0,0 +1,59 @@
+package corgi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ProviderOption redis连接选项
+type ProviderOption func(*providerOptions)
+
+type providerOptions struct {
+	tlsConfig *tls.Config
+}
+
+func newProviderOptions(opts []ProviderOption) *providerOptions {
+	po := &providerOptions{}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(po)
+		}
+	}
+	return po
+}
+
+// WithTLS 使用TLS连接redis，如云上托管redis的传输加密
+//
+// 自定义CA可通过cfg.RootCAs指定。会覆盖连接配置中的TLSConfig，fail-over模式下同时用于哨兵和数据节点。
+func WithTLS(cfg *tls.Config) ProviderOption {
+	return func(po *providerOptions) {
+		po.tlsConfig = cfg
+	}
+}
+
+// 连接redis失败时的错误，TLS握手失败单独说明，便于与网络不通等问题区分
+func pingError(err error) error {
+	if isTLSError(err) {
+		return fmt.Errorf("corgi: tls handshake with redis failed: %w", err)
+	}
+
+	return fmt.Errorf("corgi: ping redis: %w", err)
+}
+
+func isTLSError(err error) bool {
+	var (
+		recordErr   tls.RecordHeaderError
+		unknownCA   x509.UnknownAuthorityError
+		hostnameErr x509.HostnameError
+		invalidErr  x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(err, &recordErr), errors.As(err, &unknownCA), errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return true
+	}
+
+	return strings.Contains(err.Error(), "tls: ")
+}
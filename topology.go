@@ -0,0 +1,87 @@
+package corgi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// TopologyMode redis部署模式
+type TopologyMode string
+
+const (
+	// TopologyUnconfigured 未设置redis连接
+	TopologyUnconfigured TopologyMode = "unconfigured"
+	// TopologyStandalone 单实例
+	TopologyStandalone TopologyMode = "standalone"
+	// TopologyCluster cluster集群
+	TopologyCluster TopologyMode = "cluster"
+	// TopologyFailOver 哨兵(fail-over)
+	TopologyFailOver TopologyMode = "failover"
+)
+
+// go-redis为哨兵客户端设置的地址占位符
+const failoverClientAddr = "FailoverClient"
+
+// TopologyInfo 当前配置的部署模式及服务地址
+type TopologyInfo struct {
+	Mode TopologyMode
+	// Addrs 服务地址，哨兵模式下为哨兵地址；通过SetRedisProviderClient传入的哨兵客户端无法获取地址
+	Addrs []string
+}
+
+func (t TopologyInfo) String() string {
+	if len(t.Addrs) == 0 {
+		return string(t.Mode)
+	}
+
+	return fmt.Sprintf("%s(%s)", t.Mode, strings.Join(t.Addrs, ","))
+}
+
+// Topology 返回当前配置的部署模式及服务地址，用于诊断
+func Topology() TopologyInfo {
+	return lockDriver.topology()
+}
+
+func (rd *redisDriver) topology() TopologyInfo {
+	if rd.clusterClient != nil {
+		return TopologyInfo{Mode: TopologyCluster, Addrs: rd.clusterClient.Options().Addrs}
+	}
+
+	if rd.client != nil {
+		addr := rd.client.Options().Addr
+		if addr == failoverClientAddr {
+			return TopologyInfo{Mode: TopologyFailOver, Addrs: rd.sentinelAddrs}
+		}
+		return TopologyInfo{Mode: TopologyStandalone, Addrs: []string{addr}}
+	}
+
+	return TopologyInfo{Mode: TopologyUnconfigured}
+}
+
+// WarmUp 预先完成cluster集群的slot拓扑发现并建立到各主节点的连接，单实例及哨兵模式下为空操作
+//
+// go-redis在首次执行命令时才加载slot拓扑，cluster模式下启动后第一次加锁会因此多出若干次往返，
+// 对延迟敏感的服务可在启动时调用，避免首次加锁的延迟尖峰。
+func WarmUp(ctx context.Context) error {
+	return lockDriver.warmUp(ctx)
+}
+
+func (rd *redisDriver) warmUp(ctx context.Context) error {
+	if rd.clusterClient == nil {
+		if rd.client == nil {
+			return ErrNotConfigured
+		}
+		return nil
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	//ForEachMaster同步加载slot拓扑(ReloadState只是标记为待刷新)
+	return rd.clusterClient.ForEachMaster(ctx, func(ctx context.Context, shard *redisLib.Client) error {
+		return shard.Ping(ctx).Err()
+	})
+}
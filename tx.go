@@ -0,0 +1,133 @@
+package corgi
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// PendingLock 已加入调用方pipeline/事务但尚未确认的加锁，见AcquireInPipeline
+type PendingLock struct {
+	rd    *redisDriver
+	c     redisLib.UniversalClient
+	key   string
+	rkey  string
+	value string
+	token string
+	lo    *lockOptions
+	cmd   *redisLib.StatusCmd
+
+	once sync.Once
+	l    *Lock
+	err  error
+	//Commit得到结果后调用，装饰器(如LimitedLocker)据此结算预留的名额
+	settled func(acquired bool)
+}
+
+// AcquireInPipeline 把加锁的SET NX加入pipe，与调用方的其他命令一起由调用方执行(如TxPipelined中的MULTI/EXEC)
+//
+// pipe必须来自与本实例相同的redis连接。pipe执行后须调用返回值的Commit确认结果：加锁成功时才登记本地状态
+// 并启动自动续期，返回锁的句柄；锁被占用时返回ErrNotAcquired，事务未提交(如WATCH冲突、EXEC出错)时返回相应错误。
+// 加锁失败时SET NX的结果为redis.Nil，pipe的执行也会返回redis.Nil，应以Commit的结果为准。
+//
+// 锁的TTL从redis执行SET起计算，而续期从Commit开始计时，执行pipe后应立即调用Commit，
+// 两者之间的延迟须远小于TTL，否则锁可能在第一次续期之前过期。未调用Commit的锁不会续期，在TTL后自动过期。
+// 只支持默认的SET NX加锁，WithTerm、WithHierarchy、WithWaitReplicas及旧版键兼容等需要额外命令的选项返回ErrInvalidOption。
+func (rd *redisDriver) AcquireInPipeline(ctx context.Context, pipe redisLib.Pipeliner, key string, opts ...LockOption) (*PendingLock, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	lo := newLockOptions(opts)
+	c, err := rd.clientFor(lo)
+	if err != nil {
+		return nil, err
+	}
+	if lo.term > 0 || lo.hierarchy || lo.waitReplicas > 0 || legacyRedisKey(lo, key) != "" {
+		return nil, ErrInvalidOption
+	}
+	if err = rd.admitRenewal(); err != nil {
+		return nil, err
+	}
+
+	token := lo.token
+	if token == "" {
+		token = newToken()
+	}
+	info := newLockInfo(token)
+	info.Reason = lo.reason
+	info.Labels = ownerLabels(ctx)
+	value, token, err := provideValue(ctx, key, info)
+	if err != nil {
+		return nil, err
+	}
+
+	rkey := redisKey(key)
+	return &PendingLock{
+		rd:    rd,
+		c:     c,
+		key:   key,
+		rkey:  rkey,
+		value: value,
+		token: token,
+		lo:    lo,
+		cmd:   setNX(withAcquiring(ctx), pipe, rkey, value, lo.initialTTL()),
+	}, nil
+}
+
+// Commit 在pipe执行后确认加锁结果，成功时登记本地状态并启动自动续期，重复调用返回相同的结果
+func (p *PendingLock) Commit(ctx context.Context) (*Lock, error) {
+	p.once.Do(func() {
+		p.l, p.err = p.commit(ctx)
+		if p.settled != nil {
+			p.settled(p.err == nil)
+		}
+	})
+	return p.l, p.err
+}
+
+func (p *PendingLock) commit(ctx context.Context) (*Lock, error) {
+	ok, err := setNXResult(p.cmd)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		p.rd.contention.record(p.key, time.Now())
+		return nil, ErrNotAcquired
+	}
+
+	done, running := p.rd.begin()
+	if !running {
+		//已关闭时不再续期，尽量释放刚写入的锁
+		_, _ = p.rd.compareAndDelete(ctx, p.c, p.rkey, p.value)
+		return nil, ErrClosed
+	}
+	defer done()
+
+	registerOwner(ctx, p.c)
+	lockedHook(p.key, p.value)
+
+	stored, _ := parseLockerValue(p.value)
+	stored.Key = p.rkey
+	stored.Token = p.token
+	stored.TTL = p.lo.initialTTL()
+	l := &Lock{driver: p.rd, key: p.key, token: p.token, value: p.value, lo: p.lo, info: stored}
+	publishEvent(ctx, p.c, p.lo.eventChannel, LockEvent{Type: EventAcquired, Key: p.key, Owner: stored})
+	if renewalMode == RenewalNone {
+		return l, nil
+	}
+
+	st := newLockState(p.c, p.key, p.rkey, p.value)
+	st.applyHeadroom(p.lo)
+	st.captureStack(p.lo)
+	st.eventChannel = p.lo.eventChannel
+	p.rd.hold(st, p.lo)
+	if p.lo.detectDeadlock {
+		p.rd.order.acquired(p.key)
+	}
+	l.lost, l.ticks, l.st = st.lost, st.ticks, st
+
+	return l, nil
+}
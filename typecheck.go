@@ -0,0 +1,49 @@
+package corgi
+
+import (
+	"context"
+	"fmt"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// WithTypeChecking 获取失败时检查键的类型，键不是corgi写入的字符串时返回ErrKeyTypeConflict而不是视为锁被占用
+//
+// 用于发现锁键与其他业务使用的hash、list等键冲突(此时SET NX只会一直失败，表现为锁始终被占用)。
+// 每次获取失败多一次TYPE往返(键为字符串时再多一次GET)；使用自定义ValueProvider时只检查类型。
+func WithTypeChecking() LockOption {
+	return func(lo *lockOptions) {
+		lo.typeCheck = true
+	}
+}
+
+// 检查获取失败的键是否为corgi写入的锁，不是时返回包装了ErrKeyTypeConflict的错误
+func checkKeyType(ctx context.Context, c redisLib.UniversalClient, rkey string) error {
+	typ, err := c.Type(ctx, rkey).Result()
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case "none":
+		//检查前锁已过期
+		return nil
+	case "string":
+	default:
+		return fmt.Errorf("%w: %q is a %s", ErrKeyTypeConflict, rkey, typ)
+	}
+	if _, ok := valueProvider.(defaultValueProvider); !ok {
+		return nil
+	}
+
+	value, err := c.Get(ctx, rkey).Result()
+	if err == redisLib.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok := parseLockerValue(value); !ok {
+		return fmt.Errorf("%w: %q holds a string not written by corgi", ErrKeyTypeConflict, rkey)
+	}
+	return nil
+}
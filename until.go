@@ -0,0 +1,50 @@
+package corgi
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrDeadlinePassed LockUntil的截止时间已过，未获取锁
+var ErrDeadlinePassed = errors.New("corgi: lock deadline already passed")
+
+// 自动续期到达截止时间(WithRenewUntil)后结束，不视为锁丢失
+var errRenewalDeadline = errors.New("corgi: renewal deadline reached")
+
+// LockUntil 获取锁并持续续期到deadline，之后停止续期，锁在deadline时自然过期，无需显式释放
+//
+// 用于"持有到今天17点"之类按时间安排的场景。deadline已过时直接返回ErrDeadlinePassed，
+// 锁被占用时返回ErrNotAcquired。需要提前结束时使用AcquireUntil返回的句柄释放，或按key调用Unlock。
+func (rd *redisDriver) LockUntil(ctx context.Context, key string, deadline time.Time, opts ...LockOption) error {
+	_, err := rd.AcquireUntil(ctx, key, deadline, opts...)
+	return err
+}
+
+// AcquireUntil 同LockUntil，返回锁的句柄，工作提前完成时可通过句柄的Unlock提前释放
+//
+// 到达deadline后句柄的Lost不会收到通知。
+func (rd *redisDriver) AcquireUntil(ctx context.Context, key string, deadline time.Time, opts ...LockOption) (*Lock, error) {
+	if !time.Now().Before(deadline) {
+		return nil, ErrDeadlinePassed
+	}
+
+	return rd.Acquire(ctx, key, append(opts[:len(opts):len(opts)], func(lo *lockOptions) {
+		lo.renewUntil = deadline
+	})...)
+}
+
+// 不超过截止时间的TTL，ttl为原本使用的TTL
+func (lo *lockOptions) untilTTL(ttl time.Duration) time.Duration {
+	if lo.renewUntil.IsZero() {
+		return ttl
+	}
+	remaining := time.Until(lo.renewUntil)
+	if remaining < time.Millisecond {
+		remaining = time.Millisecond
+	}
+	if remaining < ttl {
+		return remaining
+	}
+	return ttl
+}
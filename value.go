@@ -0,0 +1,211 @@
+package corgi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// LockInfo 锁的持有者信息
+type LockInfo struct {
+	// Key redis中的键，仅在读取时填充
+	Key string `json:"-"`
+	// TTL 剩余过期时间，仅在读取时填充
+	TTL time.Duration `json:"-"`
+
+	Token    string    `json:"token"`
+	Host     string    `json:"host"`
+	IP       string    `json:"ip"`
+	PID      int       `json:"pid"`
+	LockedAt time.Time `json:"lockedAt"`
+	// Fingerprint 紧凑编码(ValueCompact)下的进程指纹
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Term 任期，见TryLockWithTerm
+	Term int64 `json:"term,omitempty"`
+	// Reason 加锁原因，见WithReason
+	Reason string `json:"reason,omitempty"`
+	// Labels 加锁时从context中提取的附加信息，见SetOwnerEnrichers
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// 加锁原因的最大长度(字节)
+const maxReasonLength = 128
+
+// 单个附加信息值的最大长度及所有附加信息的总长度上限(字节)
+const (
+	maxLabelLength  = 128
+	maxLabelsLength = 512
+)
+
+var ownerEnrichers map[string]func(ctx context.Context) string
+
+// SetOwnerEnrichers 设置加锁时从context中提取附加信息的函数，结果按名称存入持有者信息的labels
+//
+// 如同时记录trace ID和租户ID，便于排查锁的来源。返回空字符串的项不写入；单个值超过128字节时截断，
+// 按名称排序依次写入，名称与值的总长度超过512字节后其余项被丢弃。传入nil时清除。
+func SetOwnerEnrichers(enrichers map[string]func(ctx context.Context) string) {
+	m := make(map[string]func(ctx context.Context) string, len(enrichers))
+	for name, fn := range enrichers {
+		if fn != nil {
+			m[name] = fn
+		}
+	}
+	ownerEnrichers = m
+}
+
+// 从context中提取附加信息
+func ownerLabels(ctx context.Context) map[string]string {
+	if len(ownerEnrichers) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(ownerEnrichers))
+	for name := range ownerEnrichers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var (
+		labels map[string]string
+		size   int
+	)
+	for _, name := range names {
+		v := truncate(ownerEnrichers[name](ctx), maxLabelLength)
+		if v == "" {
+			continue
+		}
+		if size += len(name) + len(v); size > maxLabelsLength {
+			break
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[name] = v
+	}
+
+	return labels
+}
+
+// 旧版本写入的值中使用的时间格式
+const lockedAtLayout = "2006-01-02T15:04:05Z"
+
+var tokenGenerator = randomToken
+
+// SetTokenGenerator 设置生成锁token的函数，为nil时恢复默认的随机token(crypto/rand，128位)
+//
+// 用于在测试中注入可预测的token，以便精确断言存储的值及按值比较删除的行为。
+// 释放、续期的安全性依赖token在所有持有者之间唯一，生产环境使用可能重复的生成函数
+// 会使不同的持有者互相释放或续期对方的锁。需在获取锁之前设置。
+func SetTokenGenerator(gen func() string) {
+	if gen == nil {
+		gen = randomToken
+	}
+	tokenGenerator = gen
+}
+
+// 生成token
+func newToken() string {
+	return tokenGenerator()
+}
+
+// 随机生成token
+func randomToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 锁的持有者信息
+func lockerValue(token string) string {
+	return encodeValue(newLockInfo(token))
+}
+
+// 当前进程的持有者信息
+func newLockInfo(token string) LockInfo {
+	hostname, _ := os.Hostname()
+	ip, _ := GetLocalIP()
+
+	return LockInfo{
+		Token:    token,
+		Host:     hostname,
+		IP:       ip,
+		PID:      os.Getpid(),
+		LockedAt: time.Now().UTC(),
+	}
+}
+
+// 按配置的编码方式编码持有者信息
+//
+// 紧凑编码和二进制编码只能容纳token，带有term、reason、labels等附加信息时始终使用JSON编码。
+func encodeValue(info LockInfo) string {
+	plain := info.Term == 0 && info.Reason == "" && len(info.Labels) == 0
+	if valueEncoding == ValueCompact && plain {
+		return compactValue(info.Token)
+	}
+	if valueEncoding == ValueBinary && plain {
+		if v, ok := binaryValue(info.Token); ok {
+			return v
+		}
+	}
+
+	b, _ := json.Marshal(info)
+	return string(b)
+}
+
+// 解析锁的持有者信息
+//
+// 支持JSON、紧凑及二进制编码，并兼容旧版本写入的格式 lockedAt:<时间>@<主机名>(<ip>)
+func parseLockerValue(value string) (LockInfo, bool) {
+	var info LockInfo
+
+	if strings.HasPrefix(value, "{") {
+		if err := json.Unmarshal([]byte(value), &info); err == nil {
+			return info, true
+		}
+		//二进制编码以随机token开头，也可能以"{"开头，继续尝试其他格式
+		info = LockInfo{}
+	}
+
+	rest := strings.TrimPrefix(value, "lockedAt:")
+	if len(rest) == len(value) {
+		if info, ok := parseCompactValue(value); ok {
+			return info, true
+		}
+		info, err := DecodeOwner([]byte(value))
+		return info, err == nil
+	}
+
+	at := strings.Index(rest, "@")
+	lp := strings.LastIndex(rest, "(")
+	if at < 0 || lp < at || !strings.HasSuffix(rest, ")") {
+		return info, false
+	}
+
+	lockedAt, err := time.ParseInLocation(lockedAtLayout, rest[:at], time.Local)
+	if err != nil {
+		return info, false
+	}
+
+	info.LockedAt = lockedAt
+	info.Host = rest[at+1 : lp]
+	info.IP = rest[lp+1 : len(rest)-1]
+
+	return info, true
+}
+
+// 截断到不超过max字节，不截断多字节字符
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
@@ -0,0 +1,72 @@
+package corgi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	redisLib "github.com/go-redis/redis/v8"
+)
+
+// Verify 检查redis连接及所需能力
+//
+// 开启SetClusterDeepCheck时会逐个检查cluster的主节点。
+// 若服务端禁用了脚本(如部分托管redis或代理)，后续按值比较的释放和续期
+// 将改用WATCH/MULTI/EXEC乐观事务实现，额外的往返会带来一定的性能损耗。
+func Verify(ctx context.Context) error {
+	return lockDriver.verify(ctx)
+}
+
+func (rd *redisDriver) verify(ctx context.Context) error {
+	c := rd.cmdable()
+	if c == nil {
+		return ErrNotConfigured
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	if err := rd.ping(ctx); err != nil {
+		return err
+	}
+
+	_, _ = rd.detectVersion(ctx)
+
+	err := c.Eval(ctx, "return 1", nil).Err()
+	var redisErr redisLib.Error
+	switch {
+	case err == nil:
+		rd.noScripting.Store(false)
+	case errors.As(err, &redisErr):
+		rd.noScripting.Store(true)
+		logger.Printf("scripting is unavailable (%v), falling back to WATCH/MULTI/EXEC for ownership checks", err)
+	default:
+		return fmt.Errorf("corgi: check scripting: %w", err)
+	}
+
+	return nil
+}
+
+// Ping 检查redis是否可达，开启SetClusterDeepCheck时逐个检查cluster的主节点
+func Ping(ctx context.Context) error {
+	return lockDriver.ping(ctx)
+}
+
+func (rd *redisDriver) ping(ctx context.Context) error {
+	c := rd.cmdable()
+	if c == nil {
+		return ErrNotConfigured
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	if err := c.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("corgi: ping: %w", err)
+	}
+	if rd.clusterClient != nil && clusterDeepCheck {
+		return pingClusterMasters(ctx, rd.clusterClient)
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package corgi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrServerVersion redis服务端版本低于所用功能要求的最低版本
+var ErrServerVersion = errors.New("corgi: redis server version too old")
+
+// WithMinServerVersion 要求redis服务端版本不低于version(如"6.2.0")，否则加锁直接返回ErrServerVersion
+//
+// 用于依赖较新服务端能力的场景在加锁前明确失败，而不是在执行命令时得到难以理解的错误。
+// 版本在设置连接时通过INFO server获取，获取失败(如代理禁用了INFO)时在首次需要时重试，仍失败则返回该错误。
+// WithReplicationWait依赖的WAIT命令要求3.0.0，已自动检查。
+func WithMinServerVersion(version string) LockOption {
+	return func(lo *lockOptions) {
+		lo.requireVersion(version, "")
+	}
+}
+
+// 记录功能要求的最低版本，多个要求时取最高的版本
+func (lo *lockOptions) requireVersion(version string, feature string) {
+	if compareVersions(version, lo.minVersion) > 0 {
+		lo.minVersion, lo.minVersionFeature = version, feature
+	}
+}
+
+// ServerVersion 全局实例连接的redis服务端版本，尚未获取时返回空
+func ServerVersion() string {
+	v, _ := lockDriver.version.Load().(string)
+	return v
+}
+
+// 通过INFO server获取并记录服务端版本
+func (rd *redisDriver) detectVersion(ctx context.Context) (string, error) {
+	c := rd.cmdable()
+	if c == nil {
+		return "", ErrNotConfigured
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	info, err := c.Info(ctx, "server").Result()
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "redis_version:") {
+			v := strings.TrimPrefix(line, "redis_version:")
+			rd.version.Store(v)
+			return v, nil
+		}
+	}
+
+	return "", fmt.Errorf("corgi: redis_version not found in INFO server")
+}
+
+// 检查服务端版本是否满足加锁选项的要求
+func (rd *redisDriver) checkVersion(ctx context.Context, lo *lockOptions) error {
+	if lo.minVersion == "" {
+		return nil
+	}
+
+	v, _ := rd.version.Load().(string)
+	if v == "" {
+		var err error
+		if v, err = rd.detectVersion(ctx); err != nil {
+			return fmt.Errorf("%w: requires %s, detect server version: %v", ErrServerVersion, lo.minVersion, err)
+		}
+	}
+	if compareVersions(v, lo.minVersion) >= 0 {
+		return nil
+	}
+
+	if lo.minVersionFeature != "" {
+		return fmt.Errorf("%w: %s requires redis >= %s, server is %s", ErrServerVersion, lo.minVersionFeature, lo.minVersion, v)
+	}
+	return fmt.Errorf("%w: requires redis >= %s, server is %s", ErrServerVersion, lo.minVersion, v)
+}
+
+// 按数字逐段比较版本号，a较新时返回正数，缺少的段视为0
+func compareVersions(a string, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		x, y := versionPart(as, i), versionPart(bs, i)
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+func versionPart(parts []string, i int) int {
+	if i >= len(parts) {
+		return 0
+	}
+	n, _ := strconv.Atoi(parts[i])
+	return n
+}
@@ -0,0 +1,45 @@
+package corgi
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMinServerVersion(t *testing.T) {
+	rd, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	//miniredis不支持INFO server，无法获取版本时直接失败
+	if _, err := rd.Acquire(ctx, "versioned", WithMinServerVersion("6.2")); !errors.Is(err, ErrServerVersion) {
+		t.Fatalf("expected ErrServerVersion, got %v", err)
+	}
+
+	rd.version.Store("2.8.24")
+	_, err := rd.Acquire(ctx, "versioned", WithReplicationWait(1, time.Second))
+	if !errors.Is(err, ErrServerVersion) || !strings.Contains(err.Error(), "WAIT requires redis >= 3.0.0") {
+		t.Fatalf("expected WAIT to require 3.0.0, got %v", err)
+	}
+
+	rd.version.Store("7.0.11")
+	l, err := rd.Acquire(ctx, "versioned", WithMinServerVersion("6.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Unlock(ctx)
+
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"7.0.11", "6.2", 1},
+		{"6.2", "6.2.0", 0},
+		{"3.0.0", "3.0.1", -1},
+	} {
+		if got := compareVersions(c.a, c.b); (got > 0) != (c.want > 0) || (got < 0) != (c.want < 0) {
+			t.Fatalf("compareVersions(%q, %q) = %d, want sign of %d", c.a, c.b, got, c.want)
+		}
+	}
+}
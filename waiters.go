@@ -0,0 +1,38 @@
+package corgi
+
+import (
+	"context"
+	"time"
+)
+
+// 等待方登记的有效期，每次获取失败时刷新
+const waiterTTL = 10 * time.Second
+
+// 登记等待方的hash，与锁的键位于同一个slot
+func waitersKey(key string) string {
+	return companionKey(key, "waiters")
+}
+
+// WithWaiterTracking 获取失败(锁被占用)时在redis中登记本进程为该key的等待方，可通过Waiters查看
+//
+// 用于排查热点锁被哪些主机争抢。每次获取失败都会多一次写入，默认不开启。
+// 登记在最后一次失败后约10s内自动失效，进程崩溃后不会残留；获取成功时移除本进程的登记。
+// 同一进程内的多个等待者只登记一次。
+func WithWaiterTracking() LockOption {
+	return func(lo *lockOptions) {
+		lo.trackWaiters = true
+	}
+}
+
+// Waiters 列出最近在key上获取失败的进程(需使用WithWaiterTracking)，按最近一次登记的时间排序
+func (rd *redisDriver) Waiters(ctx context.Context, key string) ([]LockInfo, error) {
+	c := rd.cmdable()
+	if c == nil {
+		return nil, ErrNotConfigured
+	}
+
+	ctx, cancel := withExecuteTimeout(ctx)
+	defer cancel()
+
+	return registeredProcesses(ctx, c, waitersKey(key))
+}